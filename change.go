@@ -5,46 +5,80 @@ import (
 	"net/url"
 )
 
+// Change is a change to an anime list that can be sent to the list's site
 type Change interface {
-	FillForm(listType int, form *url.Values, undo ...bool)
-	URL(listType int, undo ...bool) string
+	// FillForm adds the form fields of the request that applies the change to the form
+	FillForm(listType int, form *url.Values, undo ...bool) error
+	// URL returns the URL of the request that applies the change
+	URL(listType int, undo ...bool) (string, error)
 }
 
 type AddChange struct {
 	Anime Anime
 }
 
-func (change AddChange) URL(listType int, undo ...bool) string {
+func (change AddChange) URL(listType int, undo ...bool) (string, error) {
 	undoURL := len(undo) > 0 && undo[0]
 
+	id, err := change.Anime.ID().Lookup(listType)
+	if err != nil {
+		return "", err
+	}
 	switch listType {
 	case Hummingbird:
 		if undoURL {
-			return fmt.Sprintf(HummingbirdDeleteURL, change.Anime.ID().Get(Hummingbird))
+			return fmt.Sprintf(HummingbirdDeleteURL, id), nil
 		} else {
-			return fmt.Sprintf(HummingbirdAddURL, change.Anime.ID().Get(Hummingbird))
+			return fmt.Sprintf(HummingbirdAddURL, id), nil
 		}
-	case MyAnimeList:
-		// TODO(DarinM223): set URL for MyAnimeList
-		return ""
 	default:
-		panic("Invalid list type")
+		if undoURL {
+			return fmt.Sprintf(MyAnimeListDeleteURL, id), nil
+		} else {
+			return fmt.Sprintf(MyAnimeListAddURL, id), nil
+		}
 	}
 }
 
-func (change AddChange) FillForm(listType int, form *url.Values, undo ...bool) {
-	if len(undo) <= 0 || !undo[0] {
-		switch listType {
-		case Hummingbird:
-			// TODO(DarinM223): set form for Hummingbird request
-			form.Add("status", StatusToHummingbirdString(change.Anime.Status()))
-			form.Add("rewatching", fmt.Sprintf("%t", change.Anime.Rewatching()))
-			form.Add("rewatched_times", fmt.Sprintf("%d", change.Anime.RewatchedTimes()))
-			form.Add("episodes_watched", fmt.Sprintf("%d", change.Anime.EpisodesWatched()))
-		case MyAnimeList:
-			// TODO(DarinM223): set form for MyAnimeList request
+func (change AddChange) FillForm(listType int, form *url.Values, undo ...bool) error {
+	if len(undo) > 0 && undo[0] {
+		return nil
+	}
+
+	switch listType {
+	case Hummingbird:
+		status, err := StatusToHummingbirdString(change.Anime.Status())
+		if err != nil {
+			return err
+		}
+		// TODO(DarinM223): set form for Hummingbird request
+		form.Add("status", status)
+		form.Add("rewatching", fmt.Sprintf("%t", change.Anime.Rewatching()))
+		form.Add("rewatched_times", fmt.Sprintf("%d", change.Anime.RewatchedTimes()))
+		form.Add("episodes_watched", fmt.Sprintf("%d", change.Anime.EpisodesWatched()))
+		// the optional fields are only sent if they are set
+		if change.Anime.Score() != 0 {
+			form.Add("sane_rating_update", HummingbirdRatingFromScore(change.Anime.Score()).Value)
+		}
+		if change.Anime.Notes() != "" {
+			form.Add("notes", change.Anime.Notes())
 		}
+		if change.Anime.Private() {
+			form.Add("privacy", hummingbirdPrivacy(true))
+		}
+	case MyAnimeList:
+		if _, err := StatusToMALStatus(change.Anime.Status()); err != nil {
+			return err
+		}
+		data, err := AnimeToMALEntry(change.Anime).Encode()
+		if err != nil {
+			return err
+		}
+		form.Add("data", data)
+	default:
+		return &ListTypeError{ListType: listType}
 	}
+	return nil
 }
 
 type EditChange struct {
@@ -52,29 +86,32 @@ type EditChange struct {
 	NewAnime Anime
 }
 
-func (change EditChange) URL(listType int, undo ...bool) string {
+func (change EditChange) URL(listType int, undo ...bool) (string, error) {
+	id, err := change.NewAnime.ID().Lookup(listType)
+	if err != nil {
+		return "", err
+	}
 	switch listType {
 	case Hummingbird:
-		return fmt.Sprintf(HummingbirdEditURL, change.NewAnime.ID().Get(Hummingbird))
-	case MyAnimeList:
-		// TODO(DarinM223): set URL for MyAnimeList
-		return ""
+		return fmt.Sprintf(HummingbirdEditURL, id), nil
 	default:
-		panic("Invalid list type")
+		return fmt.Sprintf(MyAnimeListEditURL, id), nil
 	}
 }
 
-func (change EditChange) FillForm(listType int, form *url.Values, undo ...bool) {
+func (change EditChange) FillForm(listType int, form *url.Values, undo ...bool) error {
 	undoForm := len(undo) > 0 && undo[0]
 
 	switch listType {
 	case Hummingbird:
 		if change.NewAnime.Status() != change.OldAnime.Status() {
-			var status string
+			anime := change.NewAnime
 			if undoForm {
-				status = StatusToHummingbirdString(change.OldAnime.Status())
-			} else {
-				status = StatusToHummingbirdString(change.NewAnime.Status())
+				anime = change.OldAnime
+			}
+			status, err := StatusToHummingbirdString(anime.Status())
+			if err != nil {
+				return err
 			}
 			form.Add("status", status)
 		}
@@ -99,16 +136,85 @@ func (change EditChange) FillForm(listType int, form *url.Values, undo ...bool)
 				form.Add("rewatching", fmt.Sprintf("%t", change.NewAnime.Rewatching()))
 			}
 		}
+
+		oldAnime, newAnime := change.OldAnime, change.NewAnime
+		if undoForm {
+			oldAnime, newAnime = newAnime, oldAnime
+		}
+		if newAnime.Score() != oldAnime.Score() {
+			// an empty rating removes the rating
+			form.Add("sane_rating_update", HummingbirdRatingFromScore(newAnime.Score()).Value)
+		}
+		if newAnime.Notes() != oldAnime.Notes() {
+			form.Add("notes", newAnime.Notes())
+		}
+		if newAnime.Private() != oldAnime.Private() {
+			form.Add("privacy", hummingbirdPrivacy(newAnime.Private()))
+		}
 	case MyAnimeList:
-		// TODO(DarinM223): set form for MyAnimeList request
+		oldAnime, newAnime := change.OldAnime, change.NewAnime
+		if undoForm {
+			oldAnime, newAnime = newAnime, oldAnime
+		}
+		if _, err := StatusToMALStatus(newAnime.Status()); err != nil {
+			return err
+		}
+		oldEntry, newEntry := AnimeToMALEntry(oldAnime), AnimeToMALEntry(newAnime)
+
+		entry := MALEntry{}
+		if *newEntry.Status != *oldEntry.Status {
+			entry.Status = newEntry.Status
+		}
+		if *newEntry.Episode != *oldEntry.Episode {
+			entry.Episode = newEntry.Episode
+		}
+		if *newEntry.TimesRewatched != *oldEntry.TimesRewatched {
+			entry.TimesRewatched = newEntry.TimesRewatched
+		}
+		if *newEntry.EnableRewatching != *oldEntry.EnableRewatching {
+			entry.EnableRewatching = newEntry.EnableRewatching
+		}
+		// the optional fields are compared on the anime because
+		// the entries leave them out when they aren't set
+		if newAnime.Score() != oldAnime.Score() {
+			score := newAnime.Score()
+			entry.Score = &score
+		}
+		if !newAnime.StartDate().Equal(oldAnime.StartDate()) {
+			date := formatMALEntryDate(newAnime.StartDate())
+			entry.DateStart = &date
+		}
+		if !newAnime.FinishDate().Equal(oldAnime.FinishDate()) {
+			date := formatMALEntryDate(newAnime.FinishDate())
+			entry.DateFinish = &date
+		}
+		if newAnime.RewatchEpisode() != oldAnime.RewatchEpisode() {
+			episode := newAnime.RewatchEpisode()
+			entry.RewatchEpisode = &episode
+		}
+		if newAnime.Notes() != oldAnime.Notes() {
+			notes := newAnime.Notes()
+			entry.Comments = &notes
+		}
+
+		if entry != (MALEntry{}) {
+			data, err := entry.Encode()
+			if err != nil {
+				return err
+			}
+			form.Add("data", data)
+		}
+	default:
+		return &ListTypeError{ListType: listType}
 	}
+	return nil
 }
 
 type DeleteChange struct {
 	Anime Anime
 }
 
-func (change DeleteChange) URL(listType int, undo ...bool) string {
+func (change DeleteChange) URL(listType int, undo ...bool) (string, error) {
 	undoURL := true
 	if len(undo) > 0 {
 		undoURL = !undo[0]
@@ -118,21 +224,43 @@ func (change DeleteChange) URL(listType int, undo ...bool) string {
 	return c.URL(listType, undoURL)
 }
 
-func (change DeleteChange) FillForm(listType int, form *url.Values, undo ...bool) {
+func (change DeleteChange) FillForm(listType int, form *url.Values, undo ...bool) error {
 	if len(undo) > 0 && undo[0] {
 		addChange := AddChange{Anime: change.Anime}
-		addChange.FillForm(listType, form)
+		return addChange.FillForm(listType, form)
+	}
+	return nil
+}
+
+// changeAnimeID returns the ID of the anime that the change is for
+func changeAnimeID(change Change, listType int) int {
+	switch c := change.(type) {
+	case AddChange:
+		return c.Anime.ID().Get(listType)
+	case EditChange:
+		return c.NewAnime.ID().Get(listType)
+	case DeleteChange:
+		return c.Anime.ID().Get(listType)
+	default:
+		return 0
 	}
 }
 
 // MergeChanges takes a list of changes and returns a
-// smaller list with similar changes merged
+// smaller list with similar changes merged. Changes for anime without
+// an ID on the list can't be told apart, so they're kept unmerged at the end
 func MergeChanges(changes []Change, listType int) []Change {
 	addMap := NewLRUMap()
 	editMap := NewLRUMap()
 	deleteMap := NewLRUMap()
+	var unmapped []Change
 
 	for _, change := range changes {
+		if changeAnimeID(change, listType) == 0 {
+			unmapped = append(unmapped, change)
+			continue
+		}
+
 		switch c := change.(type) {
 		case AddChange:
 			animeID := c.Anime.ID().Get(listType)
@@ -144,6 +272,9 @@ func MergeChanges(changes []Change, listType int) []Change {
 			animeID := c.NewAnime.ID().Get(listType)
 			if addMap.Contains(animeID) {
 				addMap.Add(animeID, c.NewAnime)
+			} else if previous, err := editMap.Get(animeID); err == nil {
+				// the merged edit starts from the anime before the first edit so that it can be undone
+				editMap.Add(animeID, EditChange{OldAnime: previous.(EditChange).OldAnime, NewAnime: c.NewAnime})
 			} else {
 				editMap.Add(animeID, change)
 			}
@@ -177,5 +308,5 @@ func MergeChanges(changes []Change, listType int) []Change {
 		newChanges[len(addKeys)+len(editKeys)+i] = change.(Change)
 	}
 
-	return newChanges
+	return append(newChanges, unmapped...)
 }
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// ChangeEncodingVersion is the version of the JSON encoding of changes.
+// It must be increased whenever the encoding changes in a way that older versions can't decode
+const ChangeEncodingVersion = 1
+
+// changeJSON is the JSON representation of a change
+type changeJSON struct {
+	Version  int        `json:"version"`
+	Kind     string     `json:"kind"`
+	Anime    *animeJSON `json:"anime,omitempty"`
+	OldAnime *animeJSON `json:"old_anime,omitempty"`
+	NewAnime *animeJSON `json:"new_anime,omitempty"`
+}
+
+// animeJSON is the JSON representation of an anime with the type of the anime
+// so that it can be decoded back into the same type
+type animeJSON struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+var (
+	animeTypeNames = make(map[reflect.Type]string)
+	animeTypes     = make(map[string]reflect.Type)
+)
+
+func init() {
+	RegisterAnimeType("hummingbird", HummingbirdAnime{})
+	RegisterAnimeType("myanimelist", MALAnime{})
+}
+
+// RegisterAnimeType registers the type of the anime under a name so that changes
+// containing anime of that type can be encoded and decoded.
+// The type must be able to be marshaled to and unmarshaled from JSON
+func RegisterAnimeType(name string, anime Anime) {
+	animeType := reflect.TypeOf(anime)
+	if _, ok := animeTypes[name]; ok {
+		panic(fmt.Sprintf("Anime type %q is already registered", name))
+	}
+	animeTypeNames[animeType] = name
+	animeTypes[name] = animeType
+}
+
+func encodeAnime(anime Anime) (*animeJSON, error) {
+	name, ok := animeTypeNames[reflect.TypeOf(anime)]
+	if !ok {
+		return nil, fmt.Errorf("Cannot encode anime of unregistered type %T", anime)
+	}
+
+	data, err := json.Marshal(anime)
+	if err != nil {
+		return nil, err
+	}
+	return &animeJSON{Type: name, Data: data}, nil
+}
+
+func decodeAnime(encoded *animeJSON) (Anime, error) {
+	if encoded == nil {
+		return nil, errors.New("Missing anime")
+	}
+
+	animeType, ok := animeTypes[encoded.Type]
+	if !ok {
+		return nil, fmt.Errorf("Cannot decode anime of unregistered type %q", encoded.Type)
+	}
+
+	anime := reflect.New(animeType)
+	if err := json.Unmarshal(encoded.Data, anime.Interface()); err != nil {
+		return nil, err
+	}
+	return anime.Elem().Interface().(Anime), nil
+}
+
+// MarshalChange encodes a change as JSON tagged with the kind of the change
+// and the types of its anime
+func MarshalChange(change Change) ([]byte, error) {
+	encoded := changeJSON{Version: ChangeEncodingVersion}
+	var err error
+	switch c := change.(type) {
+	case AddChange:
+		encoded.Kind = "add"
+		encoded.Anime, err = encodeAnime(c.Anime)
+	case EditChange:
+		encoded.Kind = "edit"
+		if encoded.OldAnime, err = encodeAnime(c.OldAnime); err == nil {
+			encoded.NewAnime, err = encodeAnime(c.NewAnime)
+		}
+	case DeleteChange:
+		encoded.Kind = "delete"
+		encoded.Anime, err = encodeAnime(c.Anime)
+	default:
+		return nil, fmt.Errorf("Cannot encode change of type %T", change)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(encoded)
+}
+
+// UnmarshalChange decodes a change encoded by MarshalChange
+func UnmarshalChange(data []byte) (Change, error) {
+	var encoded changeJSON
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return nil, err
+	}
+
+	// changes written before the encoding was versioned have no version
+	if encoded.Version != 0 && encoded.Version != ChangeEncodingVersion {
+		return nil, fmt.Errorf("Unsupported change encoding version %d", encoded.Version)
+	}
+
+	switch encoded.Kind {
+	case "add":
+		anime, err := decodeAnime(encoded.Anime)
+		if err != nil {
+			return nil, err
+		}
+		return AddChange{Anime: anime}, nil
+	case "edit":
+		oldAnime, err := decodeAnime(encoded.OldAnime)
+		if err != nil {
+			return nil, err
+		}
+		newAnime, err := decodeAnime(encoded.NewAnime)
+		if err != nil {
+			return nil, err
+		}
+		return EditChange{OldAnime: oldAnime, NewAnime: newAnime}, nil
+	case "delete":
+		anime, err := decodeAnime(encoded.Anime)
+		if err != nil {
+			return nil, err
+		}
+		return DeleteChange{Anime: anime}, nil
+	default:
+		return nil, fmt.Errorf("Cannot decode change of kind %q", encoded.Kind)
+	}
+}
+
+// MarshalChanges encodes a list of changes as a JSON array of encoded changes
+func MarshalChanges(changes []Change) ([]byte, error) {
+	encoded := make([]json.RawMessage, len(changes))
+	for i, change := range changes {
+		data, err := MarshalChange(change)
+		if err != nil {
+			return nil, err
+		}
+		encoded[i] = data
+	}
+	return json.Marshal(encoded)
+}
+
+// UnmarshalChanges decodes a list of changes encoded by MarshalChanges
+func UnmarshalChanges(data []byte) ([]Change, error) {
+	var encoded []json.RawMessage
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return nil, err
+	}
+
+	changes := make([]Change, len(encoded))
+	for i, encodedChange := range encoded {
+		change, err := UnmarshalChange(encodedChange)
+		if err != nil {
+			return nil, fmt.Errorf("Error decoding change %d: %v", i, err)
+		}
+		changes[i] = change
+	}
+	return changes, nil
+}
+
+func (change AddChange) MarshalJSON() ([]byte, error) {
+	return MarshalChange(change)
+}
+
+func (change EditChange) MarshalJSON() ([]byte, error) {
+	return MarshalChange(change)
+}
+
+func (change DeleteChange) MarshalJSON() ([]byte, error) {
+	return MarshalChange(change)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files in testdata")
+
+var changeEncodingTestChanges = []Change{
+	AddChange{HummingbirdAnime{
+		NumEpisodesWatched: 11,
+		AnimeStatus:        "currently-watching",
+		NumRewatchedTimes:  2,
+		LastUpdated:        time.Date(2016, 6, 1, 12, 0, 0, 0, time.UTC),
+		Rating:             HummingbirdRating{Type: "advanced", Value: "3.5"},
+		EntryNotes:         "Rewatching with friends",
+		IsPrivate:          true,
+		Data: HummingbirdAnimeData{
+			Id:           69,
+			MalID:        20,
+			Title:        "Sample text",
+			EpisodeCount: 12,
+		},
+	}},
+	EditChange{
+		OldAnime: MALAnime{
+			SeriesAnimeDBID:   20,
+			SeriesTitle:       "Sample text",
+			MyWatchedEpisodes: 11,
+			MyStatus:          MALStatusWatching,
+		},
+		NewAnime: MALAnime{
+			SeriesAnimeDBID:   20,
+			SeriesTitle:       "Sample text",
+			MyWatchedEpisodes: 12,
+			MyStatus:          MALStatusCompleted,
+			MyScore:           8,
+			MyStartDate:       "2016-05-20",
+			MyFinishDate:      "2016-06-01",
+			MyLastUpdated:     1464782400,
+			HummingbirdID:     69,
+		},
+	},
+	DeleteChange{HummingbirdAnime{
+		AnimeStatus: "dropped",
+		Data:        HummingbirdAnimeData{Id: 70},
+	}},
+}
+
+func TestMarshalChanges_Golden(t *testing.T) {
+	data, err := MarshalChanges(changeEncodingTestChanges)
+	if err != nil {
+		t.Fatalf("TestMarshalChanges_Golden failed: %v", err)
+	}
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, data, "", "  "); err != nil {
+		t.Fatalf("TestMarshalChanges_Golden failed: %v", err)
+	}
+	indented.WriteByte('\n')
+
+	goldenPath := filepath.Join("testdata", "changes.golden.json")
+	if *updateGolden {
+		if err := ioutil.WriteFile(goldenPath, indented.Bytes(), 0644); err != nil {
+			t.Fatalf("TestMarshalChanges_Golden failed: %v", err)
+		}
+	}
+
+	golden, err := ioutil.ReadFile(goldenPath)
+	if err != nil {
+		t.Fatalf("TestMarshalChanges_Golden failed: %v", err)
+	}
+	if !bytes.Equal(indented.Bytes(), golden) {
+		t.Errorf("TestMarshalChanges_Golden failed: want\n%s\ngot\n%s", golden, indented.Bytes())
+	}
+
+	changes, err := UnmarshalChanges(golden)
+	if err != nil {
+		t.Fatalf("TestMarshalChanges_Golden failed: %v", err)
+	}
+	if !reflect.DeepEqual(changes, changeEncodingTestChanges) {
+		t.Errorf("TestMarshalChanges_Golden failed: want %+v got %+v", changeEncodingTestChanges, changes)
+	}
+}
+
+func TestMarshalChange_RoundTrip(t *testing.T) {
+	for _, change := range changeEncodingTestChanges {
+		// changes can be marshaled directly with encoding/json
+		data, err := json.Marshal(change)
+		if err != nil {
+			t.Fatalf("TestMarshalChange_RoundTrip failed: %v", err)
+		}
+
+		decoded, err := UnmarshalChange(data)
+		if err != nil {
+			t.Fatalf("TestMarshalChange_RoundTrip failed: %v", err)
+		}
+		if !reflect.DeepEqual(decoded, change) {
+			t.Errorf("TestMarshalChange_RoundTrip failed: want %+v got %+v", change, decoded)
+		}
+	}
+}
+
+var unmarshalChangeErrorTests = []struct {
+	data          string
+	expectedError string
+}{
+	{`{"version":2,"kind":"add"}`, "Unsupported change encoding version 2"},
+	{`{"version":1,"kind":"rename"}`, `Cannot decode change of kind "rename"`},
+	{`{"version":1,"kind":"add"}`, "Missing anime"},
+	{`{"version":1,"kind":"add","anime":{"type":"anilist","data":{}}}`, `Cannot decode anime of unregistered type "anilist"`},
+}
+
+func TestUnmarshalChange_Errors(t *testing.T) {
+	for _, test := range unmarshalChangeErrorTests {
+		_, err := UnmarshalChange([]byte(test.data))
+		if err == nil || !strings.Contains(err.Error(), test.expectedError) {
+			t.Errorf("TestUnmarshalChange_Errors failed: want error %q got %v", test.expectedError, err)
+		}
+	}
+}
+
+func TestMarshalChange_UnregisteredAnime(t *testing.T) {
+	anime := syncedAnime{Anime: HummingbirdAnime{}, id: AnimeID{1, 2}}
+	if _, err := MarshalChange(AddChange{anime}); err == nil {
+		t.Errorf("TestMarshalChange_UnregisteredAnime failed: expected an error encoding an unregistered anime type")
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// ChangeLog is an append-only file of the changes made to an anime list that
+// haven't been pushed yet, so that they aren't lost if the program exits before pushing.
+// Each line of the file is a JSON encoded change
+type ChangeLog struct {
+	path string
+	file *os.File
+}
+
+// OpenChangeLog opens or creates the change log at the path and returns the changes in it.
+// A partially written change at the end of the log is discarded
+func OpenChangeLog(path string) (*ChangeLog, []Change, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	changes, validLength, err := readChanges(file)
+	if err != nil {
+		file.Close()
+		return nil, nil, fmt.Errorf("Error reading change log %s: %v", path, err)
+	}
+
+	// remove a partially written change so that new changes are appended after the last full one
+	if err := file.Truncate(validLength); err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	if _, err := file.Seek(validLength, io.SeekStart); err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+
+	return &ChangeLog{path: path, file: file}, changes, nil
+}
+
+// readChanges reads the changes in the reader and returns them
+// with the length of the data up to the last complete change
+func readChanges(r io.Reader) ([]Change, int64, error) {
+	var changes []Change
+	var validLength int64
+
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// a line without a newline was not fully written
+			return changes, validLength, nil
+		} else if err != nil {
+			return nil, 0, err
+		}
+
+		if len(bytes.TrimSpace(line)) > 0 {
+			change, err := UnmarshalChange(line)
+			if err != nil {
+				return nil, 0, err
+			}
+			changes = append(changes, change)
+		}
+		validLength += int64(len(line))
+	}
+}
+
+// Append writes a change to the end of the log and syncs it to disk
+func (log *ChangeLog) Append(change Change) error {
+	data, err := MarshalChange(change)
+	if err != nil {
+		return err
+	}
+
+	if _, err := log.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return log.file.Sync()
+}
+
+// Compact replaces the changes in the log with the changes.
+// The new log is written to a temporary file and renamed over the old one
+// so that the log is never left half written
+func (log *ChangeLog) Compact(changes []Change) error {
+	tempFile, err := os.CreateTemp(filepath.Dir(log.path), filepath.Base(log.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempFile.Name())
+
+	writer := bufio.NewWriter(tempFile)
+	for _, change := range changes {
+		data, err := MarshalChange(change)
+		if err != nil {
+			tempFile.Close()
+			return err
+		}
+		writer.Write(data)
+		writer.WriteByte('\n')
+	}
+	if err := writer.Flush(); err != nil {
+		tempFile.Close()
+		return err
+	}
+	if err := tempFile.Sync(); err != nil {
+		tempFile.Close()
+		return err
+	}
+	if err := os.Rename(tempFile.Name(), log.path); err != nil {
+		tempFile.Close()
+		return err
+	}
+
+	// keep appending to the new log
+	log.file.Close()
+	log.file = tempFile
+	_, err = log.file.Seek(0, io.SeekEnd)
+	return err
+}
+
+// failureJSON is a change that failed to be sent as it is saved next to the change log
+type failureJSON struct {
+	ID     int             `json:"id"`
+	Change json.RawMessage `json:"change"`
+	Error  string          `json:"error"`
+}
+
+// failuresPath returns the path of the file next to the log with the failures of the last push
+func (log *ChangeLog) failuresPath() string {
+	return log.path + ".failures"
+}
+
+// SaveFailures writes the changes that failed to be sent by the last push next to the log,
+// so that they can still be reported after the program exits. The file is removed if
+// nothing failed
+func (log *ChangeLog) SaveFailures(failures []ChangeFailure) error {
+	if len(failures) == 0 {
+		if err := os.Remove(log.failuresPath()); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	encoded := make([]failureJSON, len(failures))
+	for i, failure := range failures {
+		change, err := MarshalChange(failure.Change)
+		if err != nil {
+			return err
+		}
+		encoded[i] = failureJSON{ID: failure.ID, Change: change, Error: failure.Err.Error()}
+	}
+	data, err := json.Marshal(encoded)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(log.failuresPath(), data, 0600)
+}
+
+// LoadFailures reads the failures saved next to the log by SaveFailures
+func (log *ChangeLog) LoadFailures() ([]ChangeFailure, error) {
+	data, err := os.ReadFile(log.failuresPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var encoded []failureJSON
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return nil, fmt.Errorf("Error reading push failures %s: %v", log.failuresPath(), err)
+	}
+	failures := make([]ChangeFailure, len(encoded))
+	for i, failure := range encoded {
+		change, err := UnmarshalChange(failure.Change)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading push failures %s: %v", log.failuresPath(), err)
+		}
+		failures[i] = ChangeFailure{ID: failure.ID, Change: change, Err: errors.New(failure.Error)}
+	}
+	return failures, nil
+}
+
+// Close closes the log file
+func (log *ChangeLog) Close() error {
+	return log.file.Close()
+}
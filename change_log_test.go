@@ -0,0 +1,189 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var changeLogTestChanges = []Change{
+	AddChange{newSyncTestAnime(1, 101, 3)},
+	EditChange{
+		OldAnime: newSyncTestAnime(1, 101, 3),
+		NewAnime: AnimeToMAL(newSyncTestAnime(1, 101, 4)),
+	},
+	DeleteChange{AnimeToMAL(newSyncTestAnime(2, 102, 5))},
+}
+
+func newChangeLogTestPath(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "myhumminglist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "changes.log")
+}
+
+func TestChangeLog_AppendAndReplay(t *testing.T) {
+	path := newChangeLogTestPath(t)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	log, changes, err := OpenChangeLog(path)
+	if err != nil || len(changes) != 0 {
+		t.Fatalf("TestChangeLog_AppendAndReplay failed: %v %+v", err, changes)
+	}
+	for _, change := range changeLogTestChanges {
+		if err := log.Append(change); err != nil {
+			t.Fatalf("TestChangeLog_AppendAndReplay failed: %v", err)
+		}
+	}
+	log.Close()
+
+	log, changes, err = OpenChangeLog(path)
+	if err != nil {
+		t.Fatalf("TestChangeLog_AppendAndReplay failed: %v", err)
+	}
+	defer log.Close()
+	if !reflect.DeepEqual(changes, changeLogTestChanges) {
+		t.Errorf("TestChangeLog_AppendAndReplay failed: want %+v got %+v", changeLogTestChanges, changes)
+	}
+}
+
+func TestChangeLog_DiscardsPartialChange(t *testing.T) {
+	path := newChangeLogTestPath(t)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	log, _, err := OpenChangeLog(path)
+	if err != nil {
+		t.Fatalf("TestChangeLog_DiscardsPartialChange failed: %v", err)
+	}
+	log.Append(changeLogTestChanges[0])
+	log.file.Write([]byte(`{"kind":"add","anime":{"ty`))
+	log.Close()
+
+	log, changes, err := OpenChangeLog(path)
+	if err != nil {
+		t.Fatalf("TestChangeLog_DiscardsPartialChange failed: %v", err)
+	}
+	log.Append(changeLogTestChanges[2])
+	log.Close()
+
+	_, changes, err = OpenChangeLog(path)
+	expectedChanges := []Change{changeLogTestChanges[0], changeLogTestChanges[2]}
+	if err != nil || !reflect.DeepEqual(changes, expectedChanges) {
+		t.Errorf("TestChangeLog_DiscardsPartialChange failed: want %+v got %+v %v", expectedChanges, changes, err)
+	}
+}
+
+func TestChangeLog_Compact(t *testing.T) {
+	path := newChangeLogTestPath(t)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	log, _, err := OpenChangeLog(path)
+	if err != nil {
+		t.Fatalf("TestChangeLog_Compact failed: %v", err)
+	}
+	for _, change := range changeLogTestChanges {
+		log.Append(change)
+	}
+	if err := log.Compact(changeLogTestChanges[2:]); err != nil {
+		t.Fatalf("TestChangeLog_Compact failed: %v", err)
+	}
+	log.Append(changeLogTestChanges[0])
+	log.Close()
+
+	_, changes, err := OpenChangeLog(path)
+	expectedChanges := []Change{changeLogTestChanges[2], changeLogTestChanges[0]}
+	if err != nil || !reflect.DeepEqual(changes, expectedChanges) {
+		t.Errorf("TestChangeLog_Compact failed: want %+v got %+v %v", expectedChanges, changes, err)
+	}
+}
+
+func TestChangeLog_SaveFailures(t *testing.T) {
+	path := newChangeLogTestPath(t)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	log, _, err := OpenChangeLog(path)
+	if err != nil {
+		t.Fatalf("TestChangeLog_SaveFailures failed: %v", err)
+	}
+	defer log.Close()
+	failures := []ChangeFailure{{ID: 1, Change: changeLogTestChanges[1], Err: errors.New("Status code is 500")}}
+	if err := log.SaveFailures(failures); err != nil {
+		t.Fatalf("TestChangeLog_SaveFailures failed: %v", err)
+	}
+	if loaded, err := log.LoadFailures(); err != nil || !reflect.DeepEqual(loaded, failures) {
+		t.Errorf("TestChangeLog_SaveFailures failed: want %+v got %+v %v", failures, loaded, err)
+	}
+
+	// saving a push without failures removes the saved failures
+	if err := log.SaveFailures(nil); err != nil {
+		t.Fatalf("TestChangeLog_SaveFailures failed: %v", err)
+	}
+	if loaded, err := log.LoadFailures(); err != nil || len(loaded) != 0 {
+		t.Errorf("TestChangeLog_SaveFailures failed: expected no failures got %+v %v", loaded, err)
+	}
+}
+
+func TestHummingbirdAnimeList_ChangeLogSurvivesRestart(t *testing.T) {
+	path := newChangeLogTestPath(t)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	list := NewHummingbirdAnimeList("darin_minamoto", "")
+	if err := list.OpenChangeLog(path); err != nil {
+		t.Fatalf("TestHummingbirdAnimeList_ChangeLogSurvivesRestart failed: %v", err)
+	}
+	list.Add(newSyncTestAnime(1, 101, 3))
+	list.Add(newSyncTestAnime(2, 102, 4))
+	list.Edit(newSyncTestAnime(1, 101, 5))
+	list.Remove(newSyncTestAnime(2, 102, 4))
+	list.log.Close()
+
+	restarted := NewHummingbirdAnimeList("darin_minamoto", "")
+	if err := restarted.OpenChangeLog(path); err != nil {
+		t.Fatalf("TestHummingbirdAnimeList_ChangeLogSurvivesRestart failed: %v", err)
+	}
+	defer restarted.log.Close()
+
+	if !reflect.DeepEqual(restarted.changes, list.changes) {
+		t.Errorf("TestHummingbirdAnimeList_ChangeLogSurvivesRestart failed: want %+v got %+v", list.changes, restarted.changes)
+	}
+	if !reflect.DeepEqual(restarted.anime, list.anime) {
+		t.Errorf("TestHummingbirdAnimeList_ChangeLogSurvivesRestart failed: want %+v got %+v", list.anime, restarted.anime)
+	}
+
+	// compacting rewrites the log with the merged changes
+	if err := restarted.compactLog(); err != nil {
+		t.Fatalf("TestHummingbirdAnimeList_ChangeLogSurvivesRestart failed: %v", err)
+	}
+	_, changes, err := OpenChangeLog(path)
+	expectedChanges := []Change{AddChange{AnimeToHummingbird(newSyncTestAnime(1, 101, 5))}}
+	if err != nil || !reflect.DeepEqual(changes, expectedChanges) {
+		t.Errorf("TestHummingbirdAnimeList_ChangeLogSurvivesRestart failed: want %+v got %+v %v", expectedChanges, changes, err)
+	}
+}
+
+func TestMyAnimeListAnimeList_ChangeLogSurvivesRestart(t *testing.T) {
+	path := newChangeLogTestPath(t)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	list := NewMyAnimeListAnimeList("darin_minamoto", "")
+	if err := list.OpenChangeLog(path); err != nil {
+		t.Fatalf("TestMyAnimeListAnimeList_ChangeLogSurvivesRestart failed: %v", err)
+	}
+	list.Add(AnimeToMAL(newSyncTestAnime(1, 101, 3)))
+	list.Edit(AnimeToMAL(newSyncTestAnime(1, 101, 5)))
+	list.log.Close()
+
+	restarted := NewMyAnimeListAnimeList("darin_minamoto", "")
+	if err := restarted.OpenChangeLog(path); err != nil {
+		t.Fatalf("TestMyAnimeListAnimeList_ChangeLogSurvivesRestart failed: %v", err)
+	}
+	defer restarted.log.Close()
+
+	if !reflect.DeepEqual(restarted.changes, list.changes) || !reflect.DeepEqual(restarted.anime, list.anime) {
+		t.Errorf("TestMyAnimeListAnimeList_ChangeLogSurvivesRestart failed: want %+v got %+v", list.anime, restarted.anime)
+	}
+}
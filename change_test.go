@@ -1,13 +1,13 @@
 package main
 
 import (
+	"encoding/xml"
 	"fmt"
 	"net/url"
 	"reflect"
 	"testing"
 )
 
-// TODO(DarinM223): add MyAnimeList tests
 var changeURLTests = []struct {
 	listType    int
 	change      Change
@@ -56,6 +56,48 @@ var changeURLTests = []struct {
 		true,
 		fmt.Sprintf(HummingbirdAddURL, 69),
 	},
+	{
+		MyAnimeList,
+		AddChange{MALAnime{SeriesAnimeDBID: 20}},
+		false,
+		fmt.Sprintf(MyAnimeListAddURL, 20),
+	},
+	{
+		MyAnimeList,
+		AddChange{HummingbirdAnime{Data: HummingbirdAnimeData{Id: 69, MalID: 20}}},
+		true,
+		fmt.Sprintf(MyAnimeListDeleteURL, 20),
+	},
+	{
+		MyAnimeList,
+		EditChange{
+			MALAnime{SeriesAnimeDBID: 20},
+			MALAnime{SeriesAnimeDBID: 20},
+		},
+		false,
+		fmt.Sprintf(MyAnimeListEditURL, 20),
+	},
+	{
+		MyAnimeList,
+		EditChange{
+			MALAnime{SeriesAnimeDBID: 20},
+			MALAnime{SeriesAnimeDBID: 20},
+		},
+		true,
+		fmt.Sprintf(MyAnimeListEditURL, 20),
+	},
+	{
+		MyAnimeList,
+		DeleteChange{MALAnime{SeriesAnimeDBID: 20}},
+		false,
+		fmt.Sprintf(MyAnimeListDeleteURL, 20),
+	},
+	{
+		MyAnimeList,
+		DeleteChange{MALAnime{SeriesAnimeDBID: 20}},
+		true,
+		fmt.Sprintf(MyAnimeListAddURL, 20),
+	},
 }
 
 var defaultHummingbirdAnime = HummingbirdAnime{
@@ -66,7 +108,14 @@ var defaultHummingbirdAnime = HummingbirdAnime{
 	Data:               HummingbirdAnimeData{Id: 69},
 }
 
-// TODO(DarinM223): add MyAnimeList tests
+var defaultMALAnime = MALAnime{
+	SeriesAnimeDBID:   20,
+	MyWatchedEpisodes: 11,
+	MyStatus:          MALStatusWatching,
+	MyTimesRewatched:  2,
+	MyRewatching:      0,
+}
+
 var changeFillFormTests = []struct {
 	listType     int
 	change       Change
@@ -173,6 +222,195 @@ var changeFillFormTests = []struct {
 		undo:         true,
 		expectedForm: map[string][]string{},
 	},
+	{
+		listType: MyAnimeList,
+		change:   AddChange{defaultMALAnime},
+		undo:     false,
+		expectedForm: map[string][]string{
+			"data": []string{xml.Header + "<entry><episode>11</episode><status>1</status>" +
+				"<enable_rewatching>0</enable_rewatching><times_rewatched>2</times_rewatched></entry>"},
+		},
+	},
+	{
+		listType: MyAnimeList,
+		change:   AddChange{defaultHummingbirdAnime},
+		undo:     false,
+		expectedForm: map[string][]string{
+			"data": []string{xml.Header + "<entry><episode>11</episode><status>1</status>" +
+				"<enable_rewatching>0</enable_rewatching><times_rewatched>2</times_rewatched></entry>"},
+		},
+	},
+	{
+		listType:     MyAnimeList,
+		change:       AddChange{defaultMALAnime},
+		undo:         true,
+		expectedForm: map[string][]string{},
+	},
+	{
+		listType:     MyAnimeList,
+		change:       DeleteChange{defaultMALAnime},
+		undo:         false,
+		expectedForm: map[string][]string{},
+	},
+	{
+		listType: MyAnimeList,
+		change:   DeleteChange{defaultMALAnime},
+		undo:     true,
+		expectedForm: map[string][]string{
+			"data": []string{xml.Header + "<entry><episode>11</episode><status>1</status>" +
+				"<enable_rewatching>0</enable_rewatching><times_rewatched>2</times_rewatched></entry>"},
+		},
+	},
+	{
+		listType: MyAnimeList,
+		change: EditChange{
+			MALAnime{
+				MyWatchedEpisodes: 2,
+				MyStatus:          MALStatusWatching,
+				MyTimesRewatched:  0,
+				MyRewatching:      0,
+			},
+			MALAnime{
+				MyWatchedEpisodes: 3,
+				MyStatus:          MALStatusCompleted,
+				MyTimesRewatched:  1,
+				MyRewatching:      1,
+			},
+		},
+		undo: false,
+		expectedForm: map[string][]string{
+			"data": []string{xml.Header + "<entry><episode>3</episode><status>2</status>" +
+				"<enable_rewatching>1</enable_rewatching><times_rewatched>1</times_rewatched></entry>"},
+		},
+	},
+	{
+		listType: MyAnimeList,
+		change: EditChange{
+			MALAnime{
+				MyWatchedEpisodes: 2,
+				MyStatus:          MALStatusWatching,
+				MyTimesRewatched:  0,
+				MyRewatching:      0,
+			},
+			MALAnime{
+				MyWatchedEpisodes: 3,
+				MyStatus:          MALStatusCompleted,
+				MyTimesRewatched:  1,
+				MyRewatching:      1,
+			},
+		},
+		undo: true,
+		expectedForm: map[string][]string{
+			"data": []string{xml.Header + "<entry><episode>2</episode><status>1</status>" +
+				"<enable_rewatching>0</enable_rewatching><times_rewatched>0</times_rewatched></entry>"},
+		},
+	},
+	{
+		listType: MyAnimeList,
+		change: EditChange{
+			defaultMALAnime,
+			MALAnime{
+				SeriesAnimeDBID:   20,
+				MyWatchedEpisodes: 12,
+				MyStatus:          MALStatusWatching,
+				MyTimesRewatched:  2,
+				MyRewatching:      0,
+			},
+		},
+		undo: false,
+		expectedForm: map[string][]string{
+			"data": []string{xml.Header + "<entry><episode>12</episode></entry>"},
+		},
+	},
+	{
+		listType: MyAnimeList,
+		change: EditChange{
+			defaultMALAnime,
+			defaultHummingbirdAnime,
+		},
+		undo:         false,
+		expectedForm: map[string][]string{},
+	},
+	{
+		listType: Hummingbird,
+		change: EditChange{
+			defaultHummingbirdAnime,
+			HummingbirdAnime{
+				NumEpisodesWatched: 11,
+				AnimeStatus:        "currently-watching",
+				NumRewatchedTimes:  2,
+				Rating:             HummingbirdRating{Type: "advanced", Value: "3.5"},
+				EntryNotes:         "Sample text",
+				IsPrivate:          true,
+				Data:               HummingbirdAnimeData{Id: 69},
+			},
+		},
+		undo: false,
+		expectedForm: map[string][]string{
+			"sane_rating_update": []string{"3.5"},
+			"notes":              []string{"Sample text"},
+			"privacy":            []string{"private"},
+		},
+	},
+	{
+		listType: Hummingbird,
+		change: EditChange{
+			defaultHummingbirdAnime,
+			HummingbirdAnime{
+				NumEpisodesWatched: 11,
+				AnimeStatus:        "currently-watching",
+				NumRewatchedTimes:  2,
+				Rating:             HummingbirdRating{Type: "advanced", Value: "3.5"},
+				EntryNotes:         "Sample text",
+				IsPrivate:          true,
+				Data:               HummingbirdAnimeData{Id: 69},
+			},
+		},
+		undo: true,
+		expectedForm: map[string][]string{
+			"sane_rating_update": []string{""},
+			"notes":              []string{""},
+			"privacy":            []string{"public"},
+		},
+	},
+	{
+		listType: MyAnimeList,
+		change: EditChange{
+			defaultMALAnime,
+			MALAnime{
+				SeriesAnimeDBID:   20,
+				MyWatchedEpisodes: 11,
+				MyStatus:          MALStatusWatching,
+				MyTimesRewatched:  2,
+				MyScore:           7,
+				MyStartDate:       "2016-05-20",
+				MyRewatchingEp:    3,
+				MyComments:        "Sample text",
+			},
+		},
+		undo: false,
+		expectedForm: map[string][]string{
+			"data": []string{xml.Header + "<entry><score>7</score><date_start>05202016</date_start>" +
+				"<rewatch_episode>3</rewatch_episode><comments>Sample text</comments></entry>"},
+		},
+	},
+	{
+		listType: MyAnimeList,
+		change: DeleteChange{MALAnime{
+			SeriesAnimeDBID:   20,
+			MyWatchedEpisodes: 11,
+			MyStatus:          MALStatusWatching,
+			MyScore:           7,
+			MyStartDate:       "2016-05-20",
+			MyFinishDate:      "0000-00-00",
+		}},
+		undo: true,
+		expectedForm: map[string][]string{
+			"data": []string{xml.Header + "<entry><episode>11</episode><status>1</status>" +
+				"<enable_rewatching>0</enable_rewatching><times_rewatched>0</times_rewatched>" +
+				"<score>7</score><date_start>05202016</date_start></entry>"},
+		},
+	},
 }
 
 var mergeChangesTests = []struct {
@@ -252,12 +490,28 @@ var mergeChangesTests = []struct {
 			AddChange{HummingbirdAnime{Data: HummingbirdAnimeData{Id: 620}}},
 		},
 	},
+	{
+		listType: MyAnimeList,
+		changes: []Change{
+			AddChange{HummingbirdAnime{Data: HummingbirdAnimeData{Id: 1}}},
+			AddChange{HummingbirdAnime{Data: HummingbirdAnimeData{Id: 2, MalID: 102}}},
+			AddChange{HummingbirdAnime{Data: HummingbirdAnimeData{Id: 3}}},
+			DeleteChange{HummingbirdAnime{Data: HummingbirdAnimeData{Id: 4}}},
+		},
+		expectedChanges: []Change{
+			AddChange{HummingbirdAnime{Data: HummingbirdAnimeData{Id: 2, MalID: 102}}},
+			AddChange{HummingbirdAnime{Data: HummingbirdAnimeData{Id: 1}}},
+			AddChange{HummingbirdAnime{Data: HummingbirdAnimeData{Id: 3}}},
+			DeleteChange{HummingbirdAnime{Data: HummingbirdAnimeData{Id: 4}}},
+		},
+	},
 }
 
 func TestChangeURL(t *testing.T) {
 	for _, test := range changeURLTests {
-		if test.change.URL(test.listType, test.undo) != test.expectedURL {
-			t.Errorf("TestChangeURL failed: want %s got %s", test.expectedURL, test.change.URL(test.listType, test.undo))
+		changeURL, err := test.change.URL(test.listType, test.undo)
+		if err != nil || changeURL != test.expectedURL {
+			t.Errorf("TestChangeURL failed: want %s got %s %v", test.expectedURL, changeURL, err)
 		}
 	}
 }
@@ -265,9 +519,9 @@ func TestChangeURL(t *testing.T) {
 func TestChangeFillForm(t *testing.T) {
 	for _, test := range changeFillFormTests {
 		form := url.Values{}
-		test.change.FillForm(test.listType, &form, test.undo)
-		if !reflect.DeepEqual(form, test.expectedForm) {
-			t.Errorf("TestChangeFillForm failed: want %v got %v", test.expectedForm, form)
+		err := test.change.FillForm(test.listType, &form, test.undo)
+		if err != nil || !reflect.DeepEqual(form, test.expectedForm) {
+			t.Errorf("TestChangeFillForm failed: want %v got %v %v", test.expectedForm, form, err)
 		}
 	}
 }
@@ -0,0 +1,429 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// changeList is implemented by the anime lists that embed a changeTracker
+type changeList interface {
+	// applyChange changes the anime in the list to what they are after the change,
+	// or to what they were before the change if undo is true
+	applyChange(change Change, undo ...bool)
+	// sendChanges sends the requests that apply the changes, or undo them if undo is true,
+	// and returns the results in the same order as the changes
+	sendChanges(changes []Change, undo ...bool) ([]RequestResult, error)
+}
+
+// rebaseList is an anime list whose changes can be replayed on top of a fetched library
+type rebaseList interface {
+	changeList
+	Contains(id int) bool
+	Get(id int) (Anime, error)
+}
+
+// changeTracker keeps track of the changes made to an anime list, writes them to
+// the change log and keeps the history of the changes so they can be undone
+type changeTracker struct {
+	listType    int
+	changes     []Change
+	pastChanges []Change
+	history     History
+	// failures are the changes that failed to be sent by the last push.
+	// They are saved next to the change log so they are kept after the program exits
+	failures []ChangeFailure
+	// quarantined are the library entries that the last fetch left out of the list
+	quarantined []*EntryError
+	// stale are the changes that the last fetch dropped because they no longer apply
+	stale []ChangeFailure
+
+	// log persists changes that haven't been pushed, if it is set
+	log *ChangeLog
+	// logErr is the first error from writing to the log
+	logErr error
+}
+
+// ChangeFailure is a change that failed to be sent
+type ChangeFailure struct {
+	// ID is the ID of the anime for the list type
+	ID     int
+	Change Change
+	Err    error
+}
+
+// PushError is returned when some of the changes sent to a list failed
+type PushError struct {
+	ListType int
+	// Total is the number of changes that were sent
+	Total    int
+	Failures []ChangeFailure
+}
+
+func (err *PushError) Error() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%d of %d %s changes failed", len(err.Failures), err.Total, ListTypeName(err.ListType))
+	for i, failure := range err.Failures {
+		if i == 0 {
+			buf.WriteString(": ")
+		} else {
+			buf.WriteString("; ")
+		}
+		fmt.Fprintf(&buf, "%s: %v", DescribeChange(failure.Change, err.ListType), failure.Err)
+	}
+	return buf.String()
+}
+
+// changeFailures returns the changes whose requests failed.
+// The results must be in the same order as the changes
+func changeFailures(listType int, changes []Change, results []RequestResult) []ChangeFailure {
+	var failures []ChangeFailure
+	for i, result := range results {
+		if result.Err != nil {
+			failures = append(failures, ChangeFailure{
+				ID:     changeAnimeID(changes[i], listType),
+				Change: changes[i],
+				Err:    result.Err,
+			})
+		}
+	}
+	return failures
+}
+
+// sentMask returns whether each of the changes was sent, given the results of sending the merged
+// changes that they were merged into. Changes of anime with an ID are matched with the merged change
+// of the anime. Changes of anime without an ID for the list all have the ID 0 and aren't merged,
+// so they are matched with the merged changes without an ID in order instead
+func sentMask(listType int, changes []Change, mergedChanges []Change, results []RequestResult) []bool {
+	failedIDs := make(map[int]bool)
+	var unmappedSent []bool
+	for i, change := range mergedChanges {
+		if id := changeAnimeID(change, listType); id == 0 {
+			unmappedSent = append(unmappedSent, results[i].Err == nil)
+		} else if results[i].Err != nil {
+			failedIDs[id] = true
+		}
+	}
+
+	sent := make([]bool, len(changes))
+	for i, change := range changes {
+		if id := changeAnimeID(change, listType); id != 0 {
+			sent[i] = !failedIDs[id]
+		} else if len(unmappedSent) > 0 {
+			sent[i], unmappedSent = unmappedSent[0], unmappedSent[1:]
+		}
+	}
+	return sent
+}
+
+func newChangeTracker(listType int) changeTracker {
+	return changeTracker{
+		listType:    listType,
+		changes:     []Change{},
+		pastChanges: []Change{},
+	}
+}
+
+// History returns the changes made to the list that can be undone, oldest first.
+// The history is only kept in memory, so pushed changes can't be undone after the list is
+// loaded again, like by the next run of the command line tool
+func (ct *changeTracker) History() []HistoryEntry {
+	return ct.history.Entries()
+}
+
+// record adds a change to the list of changes and writes it to the change log
+func (ct *changeTracker) record(change Change) {
+	ct.changes = append(ct.changes, change)
+	ct.history.record(change)
+	ct.appendLog(change)
+}
+
+// appendLog writes a change to the change log
+func (ct *changeTracker) appendLog(change Change) {
+	if ct.log != nil && ct.logErr == nil {
+		ct.logErr = ct.log.Append(change)
+	}
+}
+
+// openChangeLog opens the change log at the path and adds the changes in it to the list
+func (ct *changeTracker) openChangeLog(path string, list changeList) error {
+	log, changes, err := OpenChangeLog(path)
+	if err != nil {
+		return err
+	}
+	failures, err := log.LoadFailures()
+	if err != nil {
+		log.Close()
+		return err
+	}
+
+	for _, change := range changes {
+		list.applyChange(change)
+		ct.changes = append(ct.changes, change)
+		ct.history.record(change)
+	}
+	ct.failures = failures
+	ct.log = log
+	ct.logErr = nil
+	return nil
+}
+
+// CloseChangeLog stops writing changes to the change log and closes it
+func (ct *changeTracker) CloseChangeLog() error {
+	if ct.log == nil {
+		return nil
+	}
+	err := ct.log.Close()
+	ct.log = nil
+	return err
+}
+
+// compactLog rewrites the change log with the merged changes that haven't been pushed
+func (ct *changeTracker) compactLog() error {
+	if ct.log == nil {
+		return nil
+	}
+	ct.logErr = ct.log.Compact(MergeChanges(ct.changes, ct.listType))
+	return ct.logErr
+}
+
+// rebase replays the changes that haven't been pushed on top of the library that was just
+// fetched into the list. Changes that are already in the library are dropped, and changes
+// that no longer apply are dropped and returned by StaleChanges
+func (ct *changeTracker) rebase(list rebaseList) error {
+	rebased := make([]Change, len(ct.changes))
+	changes := []Change{}
+	ct.stale = nil
+	for i, change := range ct.changes {
+		id := changeAnimeID(change, ct.listType)
+		var current Anime
+		if list.Contains(id) {
+			current, _ = list.Get(id)
+		}
+
+		rebasedChange, err := rebaseChange(change, current)
+		if err != nil {
+			ct.stale = append(ct.stale, ChangeFailure{ID: id, Change: change, Err: err})
+			continue
+		}
+		if rebasedChange == nil {
+			continue
+		}
+		list.applyChange(rebasedChange)
+		rebased[i] = rebasedChange
+		changes = append(changes, rebasedChange)
+	}
+
+	ct.changes = changes
+	ct.history.rebase(rebased)
+	return ct.compactLog()
+}
+
+// rebaseChange returns the change that makes the same edit as the change on top of the current
+// anime, which is nil if the anime isn't in the list. It returns nil if the current anime
+// already has the edit and an error if the change no longer applies
+func rebaseChange(change Change, current Anime) (Change, error) {
+	switch c := change.(type) {
+	case AddChange:
+		if current == nil {
+			return c, nil
+		} else if SameAnime(current, c.Anime) {
+			return nil, nil
+		}
+		return EditChange{OldAnime: current, NewAnime: c.Anime}, nil
+	case EditChange:
+		if current == nil {
+			return nil, ErrRemovedRemotely
+		} else if SameAnime(current, c.NewAnime) {
+			return nil, nil
+		}
+		return EditChange{OldAnime: current, NewAnime: c.NewAnime}, nil
+	case DeleteChange:
+		if current == nil {
+			return nil, nil
+		}
+		return DeleteChange{Anime: current}, nil
+	default:
+		return change, nil
+	}
+}
+
+// StaleChanges returns the changes that the last fetch dropped because they no longer
+// apply to the library, like edits of anime that were removed from the list remotely
+func (ct *changeTracker) StaleChanges() []ChangeFailure {
+	return ct.stale
+}
+
+// Quarantined returns the library entries that the last fetch left out of the list
+func (ct *changeTracker) Quarantined() []*EntryError {
+	return ct.quarantined
+}
+
+// Failures returns the changes that failed to be sent by the last push and are still queued
+func (ct *changeTracker) Failures() []ChangeFailure {
+	queued := make(map[int]bool)
+	for _, change := range ct.changes {
+		queued[changeAnimeID(change, ct.listType)] = true
+	}
+
+	var failures []ChangeFailure
+	for _, failure := range ct.failures {
+		if queued[failure.ID] {
+			failures = append(failures, failure)
+		}
+	}
+	return failures
+}
+
+// push sends the merged changes that haven't been pushed and moves the changes of the anime
+// that were sent to the past changes. The changes of the anime that failed to send stay
+// queued so that the next push only sends them, and are returned in a *PushError
+func (ct *changeTracker) push(list changeList, mergedChanges []Change) error {
+	results, err := list.sendChanges(mergedChanges)
+	if err != nil {
+		return err
+	}
+
+	ct.failures = changeFailures(ct.listType, mergedChanges, results)
+	var sentChanges []Change
+	for i, change := range mergedChanges {
+		if results[i].Err == nil {
+			sentChanges = append(sentChanges, change)
+		}
+	}
+	ct.pastChanges = append(ct.pastChanges, sentChanges...)
+
+	// the changes that haven't been pushed are in the same order in the history
+	sent := sentMask(ct.listType, ct.changes, mergedChanges, results)
+	ct.history.pushed(sentChanges, sent)
+	queued := []Change{}
+	for i, change := range ct.changes {
+		if !sent[i] {
+			queued = append(queued, change)
+		}
+	}
+	ct.changes = queued
+
+	logErr := ct.compactLog()
+	if ct.log != nil && logErr == nil {
+		logErr = ct.log.SaveFailures(ct.failures)
+	}
+	if len(ct.failures) > 0 {
+		return &PushError{
+			ListType: ct.listType,
+			Total:    len(mergedChanges),
+			Failures: append([]ChangeFailure(nil), ct.failures...),
+		}
+	}
+	return logErr
+}
+
+// undo undoes the last change if it hasn't been pushed, otherwise
+// it sends requests that undo the last push
+func (ct *changeTracker) undo(list changeList) error {
+	unit := ct.history.lastUnit()
+	if len(unit) == 0 {
+		return errors.New("Cannot undo from empty history")
+	}
+
+	if !unit[0].Pushed() {
+		// the change was never sent so it only has to be removed locally
+		ct.changes = ct.changes[:len(ct.changes)-1]
+		list.applyChange(unit[0].Change, true)
+		ct.history.undo(unit)
+		return ct.compactLog()
+	}
+
+	sentUnit, err := ct.sendBatch(list, unit, true)
+	if len(sentUnit) == 0 {
+		return err
+	}
+	for i := len(sentUnit) - 1; i >= 0; i-- {
+		list.applyChange(sentUnit[i].Change, true)
+	}
+	ct.pastChanges = removeChanges(ct.pastChanges, ct.history.batches[sentUnit[0].Batch])
+	ct.history.undo(sentUnit)
+	return err
+}
+
+// redo redoes the last change that was undone, sending the requests
+// of the push again if it was pushed
+func (ct *changeTracker) redo(list changeList) error {
+	unit := ct.history.lastUndone()
+	if len(unit) == 0 {
+		return errors.New("Cannot redo without an undone change")
+	}
+
+	if !unit[0].Pushed() {
+		for _, entry := range unit {
+			list.applyChange(entry.Change)
+			ct.changes = append(ct.changes, entry.Change)
+			ct.appendLog(entry.Change)
+		}
+		ct.history.redo(unit)
+		return nil
+	}
+
+	sentUnit, err := ct.sendBatch(list, unit)
+	if len(sentUnit) == 0 {
+		return err
+	}
+	for _, entry := range sentUnit {
+		list.applyChange(entry.Change)
+	}
+	ct.pastChanges = append(ct.pastChanges, ct.history.batches[sentUnit[0].Batch]...)
+	ct.history.redo(sentUnit)
+	return err
+}
+
+// removeChanges returns the changes without the last occurrence of each of the removed changes.
+// The changes of a push aren't always at the end of the past changes, like after a retried push
+func removeChanges(changes []Change, removed []Change) []Change {
+	left := append([]Change(nil), changes...)
+	for _, change := range removed {
+		for i := len(left) - 1; i >= 0; i-- {
+			if reflect.DeepEqual(left[i], change) {
+				left = append(left[:i], left[i+1:]...)
+				break
+			}
+		}
+	}
+	return left
+}
+
+// sendBatch sends the merged changes of the pushed batch of the unit again, or their inverses when undoing.
+// If some of them fail, the entries of the anime that were sent are split off into a new batch so that
+// they are undone or redone on their own, and the rest of the unit is left to be sent again with the
+// merged changes that failed. It returns the entries that were sent and a *PushError if some of them failed
+func (ct *changeTracker) sendBatch(list changeList, unit []HistoryEntry, undo ...bool) ([]HistoryEntry, error) {
+	batchChanges := ct.history.batches[unit[0].Batch]
+	results, err := list.sendChanges(batchChanges, undo...)
+	if err != nil {
+		return nil, err
+	}
+	failures := changeFailures(ct.listType, batchChanges, results)
+	if len(failures) == 0 {
+		return unit, nil
+	}
+
+	pushErr := &PushError{ListType: ct.listType, Total: len(batchChanges), Failures: failures}
+	var sentChanges, failedChanges []Change
+	for i, change := range batchChanges {
+		if results[i].Err == nil {
+			sentChanges = append(sentChanges, change)
+		} else {
+			failedChanges = append(failedChanges, change)
+		}
+	}
+	if len(sentChanges) == 0 {
+		return nil, pushErr
+	}
+
+	unitChanges := make([]Change, len(unit))
+	for i, entry := range unit {
+		unitChanges[i] = entry.Change
+	}
+	sent := sentMask(ct.listType, unitChanges, batchChanges, results)
+	return ct.history.split(unit[0].Batch, sentChanges, failedChanges, sent), pushErr
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestChangeTracker_PartialPush(t *testing.T) {
+	list := &stubChangeList{failIDs: map[int]bool{2: true}}
+	tracker := newChangeTracker(Hummingbird)
+
+	changes := []Change{
+		AddChange{newSyncTestAnime(1, 101, 3)},
+		AddChange{newSyncTestAnime(2, 102, 3)},
+		EditChange{OldAnime: newSyncTestAnime(2, 102, 3), NewAnime: newSyncTestAnime(2, 102, 4)},
+		DeleteChange{newSyncTestAnime(3, 103, 3)},
+	}
+	for _, change := range changes {
+		tracker.record(change)
+	}
+	mergedChanges := MergeChanges(tracker.changes, Hummingbird)
+
+	err := tracker.push(list, mergedChanges)
+	pushErr, ok := err.(*PushError)
+	if !ok {
+		t.Fatalf("TestChangeTracker_PartialPush failed: expected a *PushError got %v", err)
+	}
+	if pushErr.Total != 3 || len(pushErr.Failures) != 1 || pushErr.Failures[0].ID != 2 {
+		t.Errorf("TestChangeTracker_PartialPush failed: expected anime 2 to fail got %+v", pushErr)
+	}
+	expectedMessage := `1 of 3 Hummingbird changes failed: add 2 "Sample text": Status code is 500`
+	if pushErr.Error() != expectedMessage {
+		t.Errorf("TestChangeTracker_PartialPush failed: want %q got %q", expectedMessage, pushErr.Error())
+	}
+
+	// only the changes of the anime that failed stay queued
+	if !reflect.DeepEqual(tracker.changes, changes[1:3]) {
+		t.Errorf("TestChangeTracker_PartialPush failed: want queued %+v got %+v", changes[1:3], tracker.changes)
+	}
+	if len(tracker.pastChanges) != 2 {
+		t.Errorf("TestChangeTracker_PartialPush failed: expected the 2 sent changes to be past changes got %+v", tracker.pastChanges)
+	}
+	if failures := tracker.Failures(); len(failures) != 1 || failures[0].ID != 2 {
+		t.Errorf("TestChangeTracker_PartialPush failed: expected the failure to be attached to the queue got %+v", failures)
+	}
+	for _, entry := range tracker.History() {
+		animeID := changeAnimeID(entry.Change, Hummingbird)
+		if entry.Pushed() != (animeID != 2) {
+			t.Errorf("TestChangeTracker_PartialPush failed: unexpected history entry %+v", entry)
+		}
+	}
+
+	// the next push only sends the changes that failed
+	list.failIDs = nil
+	if err := tracker.push(list, MergeChanges(tracker.changes, Hummingbird)); err != nil {
+		t.Fatalf("TestChangeTracker_PartialPush failed: %v", err)
+	}
+	if len(list.sent) != 1 || changeAnimeID(list.sent[0], Hummingbird) != 2 {
+		t.Errorf("TestChangeTracker_PartialPush failed: expected only anime 2 to be sent again got %+v", list.sent)
+	}
+	if len(tracker.changes) != 0 || len(tracker.Failures()) != 0 || len(tracker.pastChanges) != 3 {
+		t.Errorf("TestChangeTracker_PartialPush failed: expected every change to be pushed")
+	}
+}
+
+func TestChangeTracker_PushAllFailed(t *testing.T) {
+	list := &stubChangeList{failIDs: map[int]bool{1: true}}
+	tracker := newChangeTracker(Hummingbird)
+	change := AddChange{newSyncTestAnime(1, 101, 3)}
+	tracker.record(change)
+
+	if err := tracker.push(list, []Change{change}); err == nil {
+		t.Fatalf("TestChangeTracker_PushAllFailed failed: expected an error")
+	}
+	if len(tracker.changes) != 1 || len(tracker.pastChanges) != 0 || tracker.History()[0].Pushed() {
+		t.Errorf("TestChangeTracker_PushAllFailed failed: expected the change to stay queued")
+	}
+
+	// the queued change can still be undone locally
+	if err := tracker.undo(list); err != nil || len(tracker.changes) != 0 || len(tracker.Failures()) != 0 {
+		t.Errorf("TestChangeTracker_PushAllFailed failed: expected the queued change to be undone got %v", err)
+	}
+}
+
+func TestChangeTracker_PushRequestError(t *testing.T) {
+	list := &stubChangeList{sendErr: errors.New("Cannot create request")}
+	tracker := newChangeTracker(Hummingbird)
+	change := AddChange{newSyncTestAnime(1, 101, 3)}
+	tracker.record(change)
+
+	if err := tracker.push(list, []Change{change}); err != list.sendErr {
+		t.Errorf("TestChangeTracker_PushRequestError failed: want error %v got %v", list.sendErr, err)
+	}
+	if len(tracker.changes) != 1 || len(tracker.Failures()) != 0 {
+		t.Errorf("TestChangeTracker_PushRequestError failed: expected nothing to change")
+	}
+}
+
+func TestChangeTracker_PartialPushUnmapped(t *testing.T) {
+	// the anime have no MyAnimeList IDs, so the failures can only be told apart by their order
+	list := &stubChangeList{failIDs: map[int]bool{2: true}}
+	tracker := newChangeTracker(MyAnimeList)
+
+	changes := []Change{
+		AddChange{newSyncTestAnime(1, 0, 3)},
+		AddChange{newSyncTestAnime(2, 0, 3)},
+		AddChange{newSyncTestAnime(3, 0, 3)},
+	}
+	for _, change := range changes {
+		tracker.record(change)
+	}
+	if err := tracker.push(list, MergeChanges(tracker.changes, MyAnimeList)); err == nil {
+		t.Fatalf("TestChangeTracker_PartialPushUnmapped failed: expected the change of anime 2 to fail")
+	}
+
+	if !reflect.DeepEqual(tracker.changes, []Change{changes[1]}) {
+		t.Errorf("TestChangeTracker_PartialPushUnmapped failed: expected only anime 2 to stay queued got %+v", tracker.changes)
+	}
+	expectedPast := []Change{changes[0], changes[2]}
+	if !reflect.DeepEqual(tracker.pastChanges, expectedPast) {
+		t.Errorf("TestChangeTracker_PartialPushUnmapped failed: want past changes %+v got %+v", expectedPast, tracker.pastChanges)
+	}
+	for i, entry := range tracker.History() {
+		if entry.Pushed() != (i != 1) {
+			t.Errorf("TestChangeTracker_PartialPushUnmapped failed: unexpected history entry %+v", entry)
+		}
+	}
+}
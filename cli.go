@@ -0,0 +1,1294 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const cliUserAgent = "myhumminglist"
+
+const cliUsage = `Usage: myhumminglist [flags] <command> [arguments]
+
+Commands:
+  fetch                   fetch the library from the site
+  list                    show the anime in the library
+  status                  show the changes that haven't been pushed
+  add <id> [flags]        add an anime, see "add -h" for the flags
+  edit <id> [flags]       edit an anime, see "edit -h" for the flags
+  remove <id>             remove an anime
+  push [-dry-run]         send the changes that haven't been pushed
+  undo                    undo the last change that hasn't been pushed
+  redo                    redo the last undone change
+  sync [flags]            sync the replica lists with the primary list
+  ids [list]              show the mappings between Hummingbird and MyAnimeList IDs
+  ids set <hb-id> <mal-id>
+                          map a Hummingbird ID to a MyAnimeList ID by hand
+  ids remove <hb-id>      remove the mapping of a Hummingbird ID
+  ids seed <file>         map the IDs in an anime-offline-database JSON dump
+  ids review              show the anime that sync skips because they aren't mapped
+  ids suggest [flags]     suggest mappings for those anime by matching their titles,
+                          see "ids suggest -h" for the flags
+  store-credential        store the credential on standard input in the credential
+                          file of the account chosen by -list
+
+The accounts are read from the config file given by -config or $MYHUMMINGLIST_CONFIG.
+Without a config file the account flags define a "hummingbird" primary account
+and a "myanimelist" replica account.
+
+The library of each list is cached in the state directory by fetch, and changes
+are kept in a change log in the state directory until they are pushed.
+The ID mappings are kept in the state directory and learned from fetched libraries.
+
+Flags:
+`
+
+// cliList is an anime list that the command line tool loads from and saves to its state directory
+type cliList interface {
+	Animelist
+	OpenChangeLog(path string) error
+	CloseChangeLog() error
+	Failures() []ChangeFailure
+	Quarantined() []*EntryError
+	StaleChanges() []ChangeFailure
+	loadAnime(anime []Anime)
+}
+
+// cli is the state of a run of the command line tool
+type cli struct {
+	stdin    io.Reader
+	stdout   io.Writer
+	stderr   io.Writer
+	stateDir string
+	json     bool
+	listName string
+
+	configPath string
+	config     *Config
+
+	hummingbirdUser  string
+	hummingbirdToken string
+	hummingbirdURL   string
+	malUser          string
+	malPassword      string
+	malURL           string
+}
+
+// cliAnime is the JSON output of an anime
+type cliAnime struct {
+	ID             int    `json:"id"`
+	HummingbirdID  int    `json:"hummingbird_id,omitempty"`
+	MyAnimeListID  int    `json:"myanimelist_id,omitempty"`
+	Title          string `json:"title"`
+	Status         string `json:"status"`
+	Episodes       int    `json:"episodes_watched"`
+	RewatchedTimes int    `json:"rewatched_times"`
+	Rewatching     bool   `json:"rewatching"`
+	Score          int    `json:"score,omitempty"`
+	Notes          string `json:"notes,omitempty"`
+	Private        bool   `json:"private,omitempty"`
+	StartDate      string `json:"start_date,omitempty"`
+	FinishDate     string `json:"finish_date,omitempty"`
+	RewatchEpisode int    `json:"rewatch_episode,omitempty"`
+}
+
+func newCLIAnime(anime Anime, listType int) cliAnime {
+	return cliAnime{
+		ID:             anime.ID().Get(listType),
+		HummingbirdID:  anime.ID().Hummingbird,
+		MyAnimeListID:  anime.ID().MyAnimeList,
+		Title:          anime.Title(),
+		Status:         statusName(anime.Status()),
+		Episodes:       anime.EpisodesWatched(),
+		RewatchedTimes: anime.RewatchedTimes(),
+		Rewatching:     anime.Rewatching(),
+		Score:          anime.Score(),
+		Notes:          anime.Notes(),
+		Private:        anime.Private(),
+		StartDate:      formatCLIDate(anime.StartDate()),
+		FinishDate:     formatCLIDate(anime.FinishDate()),
+		RewatchEpisode: anime.RewatchEpisode(),
+	}
+}
+
+// cliDateLayout is the layout of the dates in the flags and output of the command line tool
+const cliDateLayout = "2006-01-02"
+
+// formatCLIDate formats a date, returning an empty string if it isn't known
+func formatCLIDate(date time.Time) string {
+	if date.IsZero() {
+		return ""
+	}
+	return date.Format(cliDateLayout)
+}
+
+// parseCLIDate parses a date flag, an empty date clears the date
+func parseCLIDate(name string, value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	date, err := time.Parse(cliDateLayout, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid %s date %q, expected YYYY-MM-DD", name, value)
+	}
+	return date, nil
+}
+
+// cliFieldFlags are the flags of the optional fields that not every list keeps
+var cliFieldFlags = map[string]AnimeField{
+	"score":           FieldScore,
+	"notes":           FieldNotes,
+	"private":         FieldPrivate,
+	"start":           FieldStartDate,
+	"finish":          FieldFinishDate,
+	"rewatch-episode": FieldRewatchEpisode,
+}
+
+// runCLI runs the command line tool with the arguments and returns the exit code
+func runCLI(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	c := &cli{stdin: stdin, stdout: stdout, stderr: stderr}
+
+	flags := flag.NewFlagSet("myhumminglist", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprint(stderr, cliUsage)
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&c.stateDir, "state-dir", defaultStateDir(), "directory of the cached libraries and pending changes")
+	flags.BoolVar(&c.json, "json", false, "print JSON instead of text")
+	flags.StringVar(&c.configPath, "config", os.Getenv("MYHUMMINGLIST_CONFIG"), "JSON config file of the accounts")
+	flags.StringVar(&c.listName, "list", "", "account of the list to use, the primary account if it is empty")
+	flags.StringVar(&c.hummingbirdUser, "user", "", "Hummingbird username")
+	flags.StringVar(&c.hummingbirdToken, "token", "", "Hummingbird auth token, use a config with credentials to keep it out of the shell history")
+	flags.StringVar(&c.hummingbirdURL, "hummingbird-url", "", "base URL of the Hummingbird API")
+	flags.StringVar(&c.malUser, "mal-user", "", "MyAnimeList username")
+	flags.StringVar(&c.malPassword, "mal-password", "", "MyAnimeList password, use a config with credentials to keep it out of the shell history")
+	flags.StringVar(&c.malURL, "mal-url", "", "base URL of the MyAnimeList API")
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	err := c.loadConfig()
+	if err == nil {
+		err = c.run(flags.Arg(0), flags.Args()[1:])
+	}
+	if err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		fmt.Fprintf(stderr, "myhumminglist: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// defaultStateDir returns the state directory in the user's cache directory
+func defaultStateDir() string {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return ".myhumminglist"
+	}
+	return filepath.Join(cacheDir, "myhumminglist")
+}
+
+// run runs a command with its arguments
+func (c *cli) run(command string, args []string) error {
+	switch command {
+	case "fetch":
+		return c.fetch(args)
+	case "list":
+		return c.list(args)
+	case "status":
+		return c.status(args)
+	case "add", "edit":
+		return c.change(command, args)
+	case "remove":
+		return c.remove(args)
+	case "push":
+		return c.push(args)
+	case "undo", "redo":
+		return c.undo(command, args)
+	case "sync":
+		return c.sync(args)
+	case "ids":
+		return c.ids(args)
+	case "store-credential":
+		return c.storeCredential(args)
+	default:
+		return fmt.Errorf("Unknown command %q", command)
+	}
+}
+
+// loadConfig loads the config file or builds a config from the account flags
+func (c *cli) loadConfig() error {
+	if c.configPath != "" {
+		config, err := LoadConfig(c.configPath)
+		if err != nil {
+			return err
+		}
+		c.config = config
+	} else {
+		c.config = c.flagConfig()
+		if len(c.config.Accounts) == 0 {
+			return errors.New("No accounts, use -config or -user and -mal-user")
+		}
+		if err := c.config.Validate(); err != nil {
+			return err
+		}
+	}
+
+	for _, account := range c.config.Accounts {
+		if account.UserAgent == "" {
+			account.UserAgent = cliUserAgent
+		}
+	}
+	return nil
+}
+
+// flagConfig returns the config of the account flags
+func (c *cli) flagConfig() *Config {
+	config := &Config{Accounts: make(map[string]*AccountConfig)}
+	if c.hummingbirdUser != "" {
+		config.Accounts["hummingbird"] = &AccountConfig{
+			Service:  "hummingbird",
+			Username: c.hummingbirdUser,
+			Token:    Credential(c.hummingbirdToken),
+			BaseURL:  c.hummingbirdURL,
+		}
+		config.Primary = "hummingbird"
+	}
+	if c.malUser != "" {
+		config.Accounts["myanimelist"] = &AccountConfig{
+			Service:  "myanimelist",
+			Username: c.malUser,
+			Password: Credential(c.malPassword),
+			BaseURL:  c.malURL,
+		}
+		if config.Primary == "" {
+			config.Primary = "myanimelist"
+		} else {
+			config.Replicas = []string{"myanimelist"}
+		}
+	}
+	return config
+}
+
+// accountName returns the account chosen by the -list flag
+func (c *cli) accountName() string {
+	if c.listName != "" {
+		return c.listName
+	}
+	return c.config.Primary
+}
+
+// newList returns an empty list for the account
+func (c *cli) newList(name string) (cliList, error) {
+	list, err := c.config.NewList(name)
+	if err != nil {
+		return nil, err
+	}
+	return list.(cliList), nil
+}
+
+// stateName returns the name of the account's files in the state directory, like "hummingbird-darin"
+func (c *cli) stateName(name string) string {
+	account := c.config.Accounts[name]
+	return account.Service + "-" + account.Username
+}
+
+// statePath returns the path of a file in the state directory for the account
+func (c *cli) statePath(name string, suffix string) string {
+	return filepath.Join(c.stateDir, c.stateName(name)+suffix)
+}
+
+// openList returns the list of the account with the cached library and the pending changes.
+// The change log of the list must be closed with CloseChangeLog
+func (c *cli) openList(name string) (cliList, error) {
+	list, err := c.newList(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(c.stateDir, 0700); err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(c.statePath(name, ".library.json"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	} else if err == nil {
+		anime, err := decodeAnimeList(data)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading the cached library of %s: %v", name, err)
+		}
+		list.loadAnime(anime)
+	}
+
+	if err := list.OpenChangeLog(c.statePath(name, ".changes.log")); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// saveLibrary writes the anime in the list of the account to the library cache
+func (c *cli) saveLibrary(name string, list cliList) error {
+	data, err := encodeAnimeList(list.Anime())
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(c.stateDir, 0700); err != nil {
+		return err
+	}
+	return writeFileAtomic(c.statePath(name, ".library.json"), data)
+}
+
+// printJSON prints the value as indented JSON
+func (c *cli) printJSON(value interface{}) error {
+	encoder := json.NewEncoder(c.stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+func (c *cli) fetch(args []string) error {
+	name := c.accountName()
+	// the pending changes in the change log are replayed on top of the fetched library
+	list, err := c.openList(name)
+	if err != nil {
+		return err
+	}
+	defer list.CloseChangeLog()
+	if err := list.Fetch(); err != nil {
+		return err
+	}
+	if err := c.saveLibrary(name, list); err != nil {
+		return err
+	}
+	ids, err := LoadIDMap(c.idMapPath())
+	if err != nil {
+		return err
+	}
+	mapped := ids.Learn(list.Anime())
+	if mapped > 0 {
+		if err := ids.Save(c.idMapPath()); err != nil {
+			return err
+		}
+	}
+	skipped := make([]string, len(list.Quarantined()))
+	for i, entryErr := range list.Quarantined() {
+		skipped[i] = entryErr.Error()
+	}
+	stale := make([]string, len(list.StaleChanges()))
+	for i, failure := range list.StaleChanges() {
+		stale[i] = fmt.Sprintf("Dropped %s: %v", DescribeChange(failure.Change, list.Type()), failure.Err)
+	}
+
+	if c.json {
+		return c.printJSON(map[string]interface{}{
+			"list":    ListTypeName(list.Type()),
+			"anime":   len(list.Anime()),
+			"mapped":  mapped,
+			"skipped": skipped,
+			"stale":   stale,
+		})
+	}
+	fmt.Fprintf(c.stdout, "Fetched %d anime from %s\n", len(list.Anime()), ListTypeName(list.Type()))
+	for _, message := range append(skipped, stale...) {
+		fmt.Fprintln(c.stderr, message)
+	}
+	return nil
+}
+
+func (c *cli) list(args []string) error {
+	list, err := c.openList(c.accountName())
+	if err != nil {
+		return err
+	}
+	defer list.CloseChangeLog()
+
+	anime := list.Anime()
+	if c.json {
+		entries := make([]cliAnime, len(anime))
+		for i, a := range anime {
+			entries[i] = newCLIAnime(a, list.Type())
+		}
+		return c.printJSON(entries)
+	}
+
+	w := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTATUS\tEPISODES\tREWATCHED\tTITLE")
+	for _, a := range anime {
+		rewatched := strconv.Itoa(a.RewatchedTimes())
+		if a.Rewatching() {
+			rewatched += " (rewatching)"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%d\t%s\t%s\n", a.ID().Get(list.Type()), statusName(a.Status()),
+			a.EpisodesWatched(), rewatched, a.Title())
+	}
+	return w.Flush()
+}
+
+func (c *cli) status(args []string) error {
+	list, err := c.openList(c.accountName())
+	if err != nil {
+		return err
+	}
+	defer list.CloseChangeLog()
+
+	plan, err := list.PlanPush()
+	if err != nil {
+		return err
+	}
+	failures := make(map[int]error)
+	for _, failure := range list.Failures() {
+		failures[failure.ID] = failure.Err
+	}
+
+	if c.json {
+		return c.printJSON(map[string]interface{}{
+			"list":    ListTypeName(list.Type()),
+			"anime":   len(list.Anime()),
+			"pending": plan.Changes,
+		})
+	}
+
+	fmt.Fprintf(c.stdout, "%s: %d anime, %d change(s) to push\n", ListTypeName(list.Type()), len(list.Anime()), len(plan.Changes))
+	for _, change := range plan.Changes {
+		fmt.Fprintf(c.stdout, "  %s", DescribeChange(change, list.Type()))
+		if err, ok := failures[changeAnimeID(change, list.Type())]; ok {
+			fmt.Fprintf(c.stdout, " (failed: %v)", err)
+		}
+		fmt.Fprintln(c.stdout)
+	}
+	return nil
+}
+
+// statusName returns the Hummingbird name of a status, like "currently-watching", or "unknown"
+func statusName(status int) string {
+	name, err := StatusToHummingbirdString(status)
+	if err != nil {
+		return "unknown"
+	}
+	return name
+}
+
+// parseStatus parses a status name like "completed" or "currently-watching"
+func parseStatus(name string) (int, error) {
+	switch strings.ToLower(name) {
+	case "watching", "currently-watching":
+		return StatusWatching, nil
+	case "completed":
+		return StatusCompleted, nil
+	case "on-hold", "onhold":
+		return StatusOnHold, nil
+	case "dropped":
+		return StatusDropped, nil
+	case "plan-to-watch", "plantowatch":
+		return StatusPlanToWatch, nil
+	default:
+		return 0, fmt.Errorf("Unknown status %q", name)
+	}
+}
+
+// parseIDArgs parses the ID argument of a command and the flags before or after it
+func parseIDArgs(flags *flag.FlagSet, args []string) (int, error) {
+	var rawID string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		rawID, args = args[0], args[1:]
+	}
+	if err := flags.Parse(args); err != nil {
+		return 0, err
+	}
+	if rawID == "" && flags.NArg() > 0 {
+		rawID = flags.Arg(0)
+	}
+	if rawID == "" {
+		return 0, fmt.Errorf("%s requires the ID of an anime", flags.Name())
+	}
+	return parseAnimeID(rawID)
+}
+
+// change adds or edits an anime with the values in the flags
+func (c *cli) change(command string, args []string) error {
+	flags := flag.NewFlagSet(command, flag.ContinueOnError)
+	flags.SetOutput(c.stderr)
+	status := flags.String("status", "currently-watching", "watching, completed, on-hold, dropped or plan-to-watch")
+	episodes := flags.Int("episodes", 0, "number of episodes watched")
+	rewatching := flags.Bool("rewatching", false, "whether the anime is being rewatched")
+	rewatchedTimes := flags.Int("rewatched-times", 0, "number of times the anime was rewatched")
+	title := flags.String("title", "", "title of the anime")
+	hummingbirdID := flags.Int("hummingbird-id", 0, "Hummingbird ID of the anime when adding to MyAnimeList")
+	malID := flags.Int("mal-id", 0, "MyAnimeList ID of the anime when adding to Hummingbird")
+	score := flags.Int("score", 0, "score of the anime out of 10, 0 removes the score")
+	notes := flags.String("notes", "", "personal notes about the anime")
+	private := flags.Bool("private", false, "whether the anime is private")
+	start := flags.String("start", "", "date the anime was started as YYYY-MM-DD, empty to clear it")
+	finish := flags.String("finish", "", "date the anime was finished as YYYY-MM-DD, empty to clear it")
+	rewatchEpisode := flags.Int("rewatch-episode", 0, "episode that the rewatch of the anime is on")
+
+	id, err := parseIDArgs(flags, args)
+	if err != nil {
+		return err
+	}
+
+	list, err := c.openList(c.accountName())
+	if err != nil {
+		return err
+	}
+	defer list.CloseChangeLog()
+
+	var anime HummingbirdAnime
+	var existing Anime
+	if command == "add" {
+		if list.Contains(id) {
+			return fmt.Errorf("Anime with ID %d is already in the anime list", id)
+		}
+		anime.Data.Id, anime.Data.MalID = *hummingbirdID, *malID
+		if list.Type() == Hummingbird {
+			anime.Data.Id = id
+		} else {
+			anime.Data.MalID = id
+		}
+		anime.Data.Title = *title
+		anime.AnimeStatus, _ = StatusToHummingbirdString(StatusWatching)
+	} else {
+		existing, err = list.Get(id)
+		if err != nil {
+			return err
+		}
+		anime = AnimeToHummingbird(existing)
+	}
+
+	var flagErr error
+	var startDate, finishDate *time.Time
+	setRewatchEpisode := false
+	flags.Visit(func(f *flag.Flag) {
+		if field, ok := cliFieldFlags[f.Name]; ok && ListFields(list.Type())&field == 0 {
+			flagErr = fmt.Errorf("%s doesn't keep the %s of anime", ListTypeName(list.Type()), f.Name)
+			return
+		}
+		switch f.Name {
+		case "status":
+			parsed, err := parseStatus(*status)
+			if err != nil {
+				flagErr = err
+				return
+			}
+			anime.AnimeStatus, _ = StatusToHummingbirdString(parsed)
+		case "episodes":
+			anime.NumEpisodesWatched = *episodes
+		case "rewatching":
+			anime.IsRewatching = *rewatching
+		case "rewatched-times":
+			anime.NumRewatchedTimes = *rewatchedTimes
+		case "title":
+			anime.Data.Title = *title
+		case "score":
+			if *score < 0 || *score > 10 {
+				flagErr = fmt.Errorf("Invalid score %d, expected 0 to 10", *score)
+				return
+			}
+			anime.Rating = HummingbirdRatingFromScore(*score)
+		case "notes":
+			anime.EntryNotes = *notes
+		case "private":
+			anime.IsPrivate = *private
+		case "start":
+			date, err := parseCLIDate("start", *start)
+			if err != nil {
+				flagErr = err
+				return
+			}
+			startDate = &date
+		case "finish":
+			date, err := parseCLIDate("finish", *finish)
+			if err != nil {
+				flagErr = err
+				return
+			}
+			finishDate = &date
+		case "rewatch-episode":
+			setRewatchEpisode = true
+		}
+	})
+	if flagErr != nil {
+		return flagErr
+	}
+
+	// the anime is edited as a Hummingbird anime, so the fields that it doesn't
+	// keep are taken from the anime in the list
+	var edited Anime = anime
+	if existing != nil {
+		edited = syncedAnime{Anime: anime, id: anime.ID(), counterpart: existing}
+	}
+	converted, err := ConvertAnime(edited, list.Type())
+	if err != nil {
+		return err
+	}
+	if mal, ok := converted.(MALAnime); ok {
+		if startDate != nil {
+			mal.MyStartDate = formatMALDate(*startDate)
+		}
+		if finishDate != nil {
+			mal.MyFinishDate = formatMALDate(*finishDate)
+		}
+		if setRewatchEpisode {
+			mal.MyRewatchingEp = *rewatchEpisode
+		}
+		converted = mal
+	}
+	if command == "add" {
+		list.Add(converted)
+	} else {
+		list.Edit(converted)
+	}
+	return c.printChanged(command, converted, list.Type())
+}
+
+func (c *cli) remove(args []string) error {
+	flags := flag.NewFlagSet("remove", flag.ContinueOnError)
+	flags.SetOutput(c.stderr)
+	id, err := parseIDArgs(flags, args)
+	if err != nil {
+		return err
+	}
+
+	list, err := c.openList(c.accountName())
+	if err != nil {
+		return err
+	}
+	defer list.CloseChangeLog()
+
+	anime, err := list.Get(id)
+	if err != nil {
+		return err
+	}
+	list.Remove(anime)
+	return c.printChanged("remove", anime, list.Type())
+}
+
+// printChanged prints the anime that a command changed
+func (c *cli) printChanged(command string, anime Anime, listType int) error {
+	if c.json {
+		return c.printJSON(map[string]interface{}{"command": command, "anime": newCLIAnime(anime, listType)})
+	}
+	fmt.Fprintf(c.stdout, "Queued %s of %d %q, run push to send it\n", command, anime.ID().Get(listType), anime.Title())
+	return nil
+}
+
+func (c *cli) push(args []string) error {
+	flags := flag.NewFlagSet("push", flag.ContinueOnError)
+	flags.SetOutput(c.stderr)
+	dryRun := flags.Bool("dry-run", false, "print the requests without sending them")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	list, err := c.openList(c.accountName())
+	if err != nil {
+		return err
+	}
+	defer list.CloseChangeLog()
+
+	plan, err := list.PlanPush()
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		if c.json {
+			return c.printJSON(plan)
+		}
+		fmt.Fprint(c.stdout, plan)
+		return nil
+	}
+
+	pushErr := list.Push()
+	if pushErr != nil {
+		if _, ok := pushErr.(*PushError); !ok {
+			return pushErr
+		}
+	}
+	// the cache has the anime that were pushed so they aren't lost if the change log is compacted
+	if err := c.saveLibrary(c.accountName(), list); err != nil {
+		return err
+	}
+
+	failed := len(list.Failures())
+	if c.json {
+		var failures []map[string]interface{}
+		for _, failure := range list.Failures() {
+			failures = append(failures, map[string]interface{}{"id": failure.ID, "error": failure.Err.Error()})
+		}
+		if err := c.printJSON(map[string]interface{}{
+			"list":     ListTypeName(list.Type()),
+			"pushed":   len(plan.Changes) - failed,
+			"failures": failures,
+		}); err != nil {
+			return err
+		}
+	} else {
+		fmt.Fprintf(c.stdout, "Pushed %d of %d change(s) to %s\n", len(plan.Changes)-failed, len(plan.Changes), ListTypeName(list.Type()))
+	}
+	return pushErr
+}
+
+func (c *cli) undo(command string, args []string) error {
+	list, err := c.openList(c.accountName())
+	if err != nil {
+		return err
+	}
+	defer list.CloseChangeLog()
+
+	if command == "undo" {
+		err = list.Undo()
+	} else {
+		err = list.Redo()
+	}
+	if err != nil {
+		return err
+	}
+	if err := c.saveLibrary(c.accountName(), list); err != nil {
+		return err
+	}
+
+	if c.json {
+		return c.printJSON(map[string]interface{}{"command": command, "anime": len(list.Anime())})
+	}
+	fmt.Fprintf(c.stdout, "Done %s\n", command)
+	return nil
+}
+
+func (c *cli) sync(args []string) error {
+	flags := flag.NewFlagSet("sync", flag.ContinueOnError)
+	flags.SetOutput(c.stderr)
+	resolverName := flags.String("resolver", c.config.Resolver, "how conflicts are resolved: none, primary, progress, recent or merge,\n"+
+		"which keeps the status and rewatches of the primary list and the episodes of the replica list")
+	push := flags.Bool("push", false, "push all of the lists after syncing")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	resolver, ok := conflictResolvers[*resolverName]
+	if !ok && *resolverName != "" {
+		return fmt.Errorf("Unknown conflict resolver %q", *resolverName)
+	}
+	names, lists, err := c.openSyncLists()
+	if err != nil {
+		return err
+	}
+	defer closeChangeLogs(lists)
+
+	replicas := make([]Animelist, len(c.config.Replicas))
+	replicaNames := make(map[Animelist]string)
+	for i, name := range c.config.Replicas {
+		replicas[i] = lists[i+1]
+		replicaNames[replicas[i]] = name
+	}
+	manager := NewAnimelistManager(lists[0], replicas...)
+	manager.SetConflictResolver(resolver)
+	ids, err := LoadIDMap(c.idMapPath())
+	if err != nil {
+		return err
+	}
+	manager.SetIDMap(ids)
+
+	snapshotPaths := make([]string, len(c.config.Replicas))
+	for i, name := range c.config.Replicas {
+		snapshotPaths[i] = filepath.Join(c.stateDir, "sync-"+c.stateName(c.config.Primary)+"-"+c.stateName(name)+".snapshot.json")
+		data, err := os.ReadFile(snapshotPaths[i])
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		snapshot, err := decodeAnimeMap(data)
+		if err != nil {
+			return fmt.Errorf("Error reading the sync snapshot of %s: %v", name, err)
+		}
+		manager.SetSnapshot(i, snapshot)
+	}
+
+	conflicts, err := manager.Sync()
+	if err != nil {
+		return err
+	}
+	unmapped := manager.Unmapped()
+	for i, path := range snapshotPaths {
+		data, err := encodeAnimeMap(manager.Snapshot(i))
+		if err != nil {
+			return err
+		}
+		if err := writeFileAtomic(path, data); err != nil {
+			return err
+		}
+	}
+
+	plans, err := manager.PlanPush()
+	if err != nil {
+		return err
+	}
+	if *push {
+		if err := manager.Push(); err != nil {
+			return err
+		}
+		for i, list := range lists {
+			if err := c.saveLibrary(names[i], list); err != nil {
+				return err
+			}
+		}
+	}
+
+	if c.json {
+		pending := make(map[string]int)
+		for i, plan := range plans {
+			pending[names[i]] = len(plan.Changes)
+		}
+		type jsonConflict struct {
+			Replica string `json:"replica"`
+			ID      int    `json:"id"`
+		}
+		jsonConflicts := make([]jsonConflict, len(conflicts))
+		for i, conflict := range conflicts {
+			jsonConflicts[i] = jsonConflict{Replica: replicaNames[conflict.Replica], ID: conflict.ID}
+		}
+		return c.printJSON(map[string]interface{}{
+			"pending":   pending,
+			"pushed":    *push,
+			"conflicts": jsonConflicts,
+			"unmapped":  len(unmapped),
+		})
+	}
+
+	verb := "to push"
+	if *push {
+		verb = "pushed"
+	}
+	for i, plan := range plans {
+		fmt.Fprintf(c.stdout, "%s: %d change(s) %s\n", names[i], len(plan.Changes), verb)
+	}
+	for _, conflict := range conflicts {
+		fmt.Fprintf(c.stdout, "Conflict: %s %d was changed differently on %s and %s\n",
+			ListTypeName(conflict.Replica.Type()), conflict.ID, c.config.Primary, replicaNames[conflict.Replica])
+	}
+	if len(unmapped) > 0 {
+		fmt.Fprintf(c.stderr, "%d anime weren't synced because they have no ID for the other list, see \"ids review\"\n",
+			len(unmapped))
+	}
+	return nil
+}
+
+// idMapPath returns the path of the ID map in the state directory
+func (c *cli) idMapPath() string {
+	return filepath.Join(c.stateDir, "ids.json")
+}
+
+func (c *cli) ids(args []string) error {
+	command := "list"
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+	ids, err := LoadIDMap(c.idMapPath())
+	if err != nil {
+		return err
+	}
+
+	switch command {
+	case "list":
+		return c.listIDs(ids)
+	case "set":
+		if len(args) != 2 {
+			return errors.New("ids set requires a Hummingbird ID and a MyAnimeList ID")
+		}
+		hummingbirdID, err := parseAnimeID(args[0])
+		if err != nil {
+			return err
+		}
+		malID, err := parseAnimeID(args[1])
+		if err != nil {
+			return err
+		}
+		ids.Set(AnimeID{Hummingbird: hummingbirdID, MyAnimeList: malID}, IDSourceManual)
+		if err := c.saveIDMap(ids); err != nil {
+			return err
+		}
+		fmt.Fprintf(c.stdout, "Mapped Hummingbird %d to MyAnimeList %d\n", hummingbirdID, malID)
+		return nil
+	case "remove":
+		if len(args) != 1 {
+			return errors.New("ids remove requires a Hummingbird ID")
+		}
+		id, err := parseAnimeID(args[0])
+		if err != nil {
+			return err
+		}
+		if !ids.Remove(Hummingbird, id) {
+			return fmt.Errorf("Hummingbird %d isn't mapped", id)
+		}
+		if err := c.saveIDMap(ids); err != nil {
+			return err
+		}
+		fmt.Fprintf(c.stdout, "Removed the mapping of Hummingbird %d\n", id)
+		return nil
+	case "seed":
+		if len(args) != 1 {
+			return errors.New("ids seed requires the path of an offline dump")
+		}
+		file, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		seeded, err := ids.Seed(file)
+		if err != nil {
+			return err
+		}
+		if err := c.saveIDMap(ids); err != nil {
+			return err
+		}
+		fmt.Fprintf(c.stdout, "Seeded %d mapping(s) from %s\n", seeded, args[0])
+		return nil
+	case "review":
+		return c.reviewIDs(ids)
+	case "suggest":
+		return c.suggestIDs(ids, args)
+	default:
+		return fmt.Errorf("Unknown ids command %q", command)
+	}
+}
+
+// suggestIDs prints the candidates for the anime that sync skips because they aren't mapped,
+// and maps the anime to their best candidates with -accept
+func (c *cli) suggestIDs(ids *IDMap, args []string) error {
+	flags := flag.NewFlagSet("ids suggest", flag.ContinueOnError)
+	flags.SetOutput(c.stderr)
+	minConfidence := flags.Float64("min", 0.6, "lowest confidence of the candidates, from 0 to 1")
+	limit := flags.Int("limit", 3, "most candidates to show for each anime, 0 for every candidate")
+	accept := flags.Bool("accept", false, "map every anime to its best candidate if it is the best candidate's best candidate too")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *minConfidence < 0 || *minConfidence > 1 {
+		return fmt.Errorf("Invalid confidence %v, it must be from 0 to 1", *minConfidence)
+	}
+
+	manager, lists, listNames, err := c.idManager(ids)
+	if err != nil {
+		return err
+	}
+	defer closeChangeLogs(lists)
+	suggestions := manager.SuggestIDs(*minConfidence, *limit)
+
+	accepted := 0
+	if *accept {
+		for _, suggestion := range MutualSuggestions(suggestions) {
+			if ids.Accept(suggestion.Unmapped.Anime, suggestion.Matches[0]) {
+				accepted++
+			}
+		}
+		if err := c.saveIDMap(ids); err != nil {
+			return err
+		}
+	}
+
+	if c.json {
+		type jsonCandidate struct {
+			ID            int     `json:"id"`
+			Title         string  `json:"title"`
+			Confidence    float64 `json:"confidence"`
+			EpisodesMatch bool    `json:"episodes_match"`
+		}
+		type jsonSuggestion struct {
+			List       string          `json:"list"`
+			ID         int             `json:"id"`
+			Title      string          `json:"title"`
+			Candidates []jsonCandidate `json:"candidates"`
+		}
+		entries := make([]jsonSuggestion, len(suggestions))
+		for i, suggestion := range suggestions {
+			unmapped := suggestion.Unmapped
+			entries[i] = jsonSuggestion{
+				List:       listNames[unmapped.List],
+				ID:         unmapped.Anime.ID().Get(unmapped.List.Type()),
+				Title:      unmapped.Anime.Title(),
+				Candidates: make([]jsonCandidate, len(suggestion.Matches)),
+			}
+			for j, match := range suggestion.Matches {
+				entries[i].Candidates[j] = jsonCandidate{
+					ID:            match.Anime.ID().Get(unmapped.ListType),
+					Title:         match.Anime.Title(),
+					Confidence:    match.Confidence,
+					EpisodesMatch: match.EpisodesMatch,
+				}
+			}
+		}
+		return c.printJSON(map[string]interface{}{
+			"suggestions": entries,
+			"accepted":    accepted,
+		})
+	}
+
+	w := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "LIST\tID\tTITLE\tCANDIDATE\tCONFIDENCE\tCANDIDATE TITLE")
+	for _, suggestion := range suggestions {
+		unmapped := suggestion.Unmapped
+		for _, match := range suggestion.Matches {
+			confidence := fmt.Sprintf("%.0f%%", match.Confidence*100)
+			if match.EpisodesMatch {
+				confidence += " (same episodes)"
+			}
+			fmt.Fprintf(w, "%s\t%d\t%s\t%s %d\t%s\t%s\n", listNames[unmapped.List],
+				unmapped.Anime.ID().Get(unmapped.List.Type()), unmapped.Anime.Title(), ListTypeName(unmapped.ListType),
+				match.Anime.ID().Get(unmapped.ListType), confidence, match.Anime.Title())
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if *accept {
+		fmt.Fprintf(c.stdout, "Mapped %d anime to their best candidate\n", accepted)
+	}
+	return nil
+}
+
+// parseAnimeID parses an anime ID argument
+func parseAnimeID(rawID string) (int, error) {
+	id, err := strconv.Atoi(rawID)
+	if err != nil || id <= 0 {
+		return 0, fmt.Errorf("Invalid anime ID %q", rawID)
+	}
+	return id, nil
+}
+
+// saveIDMap writes the ID map to the state directory
+func (c *cli) saveIDMap(ids *IDMap) error {
+	if err := os.MkdirAll(c.stateDir, 0700); err != nil {
+		return err
+	}
+	return ids.Save(c.idMapPath())
+}
+
+func (c *cli) listIDs(ids *IDMap) error {
+	mappings := ids.Mappings()
+	if c.json {
+		type jsonMapping struct {
+			Hummingbird int    `json:"hummingbird"`
+			MyAnimeList int    `json:"myanimelist"`
+			Source      string `json:"source"`
+		}
+		entries := make([]jsonMapping, len(mappings))
+		for i, mapping := range mappings {
+			entries[i] = jsonMapping{mapping.ID.Hummingbird, mapping.ID.MyAnimeList, mapping.Source.String()}
+		}
+		return c.printJSON(entries)
+	}
+
+	w := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "HUMMINGBIRD\tMYANIMELIST\tSOURCE")
+	for _, mapping := range mappings {
+		fmt.Fprintf(w, "%d\t%d\t%s\n", mapping.ID.Hummingbird, mapping.ID.MyAnimeList, mapping.Source)
+	}
+	return w.Flush()
+}
+
+// openSyncLists opens the lists of the primary account and the replica accounts and returns them with
+// the names of the accounts. The change logs of the lists must be closed with closeChangeLogs
+func (c *cli) openSyncLists() ([]string, []cliList, error) {
+	if len(c.config.Replicas) == 0 {
+		return nil, nil, errors.New("There are no replica accounts to sync")
+	}
+
+	names := append([]string{c.config.Primary}, c.config.Replicas...)
+	var lists []cliList
+	for _, name := range names {
+		list, err := c.openList(name)
+		if err != nil {
+			closeChangeLogs(lists)
+			return nil, nil, err
+		}
+		lists = append(lists, list)
+	}
+	return names, lists, nil
+}
+
+// closeChangeLogs closes the change logs of the lists
+func closeChangeLogs(lists []cliList) {
+	for _, list := range lists {
+		list.CloseChangeLog()
+	}
+}
+
+// idManager opens the lists of the accounts that are synced and returns a manager of them that uses
+// the ID map, with the names of the accounts of the lists. The lists must be closed with closeChangeLogs
+func (c *cli) idManager(ids *IDMap) (*AnimelistManager, []cliList, map[Animelist]string, error) {
+	names, lists, err := c.openSyncLists()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	listNames := make(map[Animelist]string)
+	replicas := make([]Animelist, len(lists)-1)
+	for i, list := range lists {
+		listNames[list] = names[i]
+		if i > 0 {
+			replicas[i-1] = list
+		}
+	}
+	manager := NewAnimelistManager(lists[0], replicas...)
+	manager.SetIDMap(ids)
+	return manager, lists, listNames, nil
+}
+
+// reviewIDs prints the anime in the cached libraries that sync skips because they aren't mapped
+func (c *cli) reviewIDs(ids *IDMap) error {
+	manager, lists, listNames, err := c.idManager(ids)
+	if err != nil {
+		return err
+	}
+	defer closeChangeLogs(lists)
+	unmapped := manager.Unmapped()
+
+	if c.json {
+		type jsonUnmapped struct {
+			List    string `json:"list"`
+			ID      int    `json:"id"`
+			Title   string `json:"title"`
+			Missing string `json:"missing"`
+		}
+		entries := make([]jsonUnmapped, len(unmapped))
+		for i, entry := range unmapped {
+			entries[i] = jsonUnmapped{
+				List:    listNames[entry.List],
+				ID:      entry.Anime.ID().Get(entry.List.Type()),
+				Title:   entry.Anime.Title(),
+				Missing: ListTypeName(entry.ListType),
+			}
+		}
+		return c.printJSON(entries)
+	}
+
+	w := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "LIST\tID\tMISSING\tTITLE")
+	for _, entry := range unmapped {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", listNames[entry.List], entry.Anime.ID().Get(entry.List.Type()),
+			ListTypeName(entry.ListType), entry.Anime.Title())
+	}
+	return w.Flush()
+}
+
+func (c *cli) storeCredential(args []string) error {
+	name := c.accountName()
+	account, ok := c.config.Accounts[name]
+	if !ok {
+		return fmt.Errorf("Unknown account %q", name)
+	}
+	if account.Credentials == nil || account.Credentials.File == "" {
+		return fmt.Errorf("The account %s has no credential file in the config", name)
+	}
+
+	line, err := bufio.NewReader(c.stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+	credential := Credential(strings.TrimSpace(line))
+	if credential == "" {
+		return errors.New("No credential on standard input")
+	}
+
+	provider := account.Credentials.provider().(EncryptedFileCredentials)
+	if err := provider.Store(account.Service, account.Username, credential); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.stdout, "Stored the credential of %s in %s\n", name, provider.Path)
+	return nil
+}
+
+// encodeAnimeList encodes anime of registered types as a JSON array
+func encodeAnimeList(anime []Anime) ([]byte, error) {
+	encoded := make([]*animeJSON, len(anime))
+	for i, a := range anime {
+		var err error
+		if encoded[i], err = encodeAnime(a); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(encoded)
+}
+
+// decodeAnimeList decodes anime encoded by encodeAnimeList
+func decodeAnimeList(data []byte) ([]Anime, error) {
+	var encoded []*animeJSON
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return nil, err
+	}
+
+	anime := make([]Anime, len(encoded))
+	for i, e := range encoded {
+		var err error
+		if anime[i], err = decodeAnime(e); err != nil {
+			return nil, err
+		}
+	}
+	return anime, nil
+}
+
+// encodeAnimeMap encodes a map of anime of registered types as a JSON object
+func encodeAnimeMap(anime map[int]Anime) ([]byte, error) {
+	encoded := make(map[string]*animeJSON)
+	for id, a := range anime {
+		e, err := encodeAnime(a)
+		if err != nil {
+			return nil, err
+		}
+		encoded[strconv.Itoa(id)] = e
+	}
+	return json.Marshal(encoded)
+}
+
+// decodeAnimeMap decodes a map of anime encoded by encodeAnimeMap
+func decodeAnimeMap(data []byte) (map[int]Anime, error) {
+	var encoded map[string]*animeJSON
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return nil, err
+	}
+
+	anime := make(map[int]Anime)
+	for rawID, e := range encoded {
+		id, err := strconv.Atoi(rawID)
+		if err != nil {
+			return nil, err
+		}
+		if anime[id], err = decodeAnime(e); err != nil {
+			return nil, err
+		}
+	}
+	return anime, nil
+}
+
+// writeFileAtomic writes the data to a temporary file and renames it over the file
+// so that the file is never left half written
+func writeFileAtomic(path string, data []byte) error {
+	tempFile, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempFile.Name())
+
+	if _, err := tempFile.Write(data); err != nil {
+		tempFile.Close()
+		return err
+	}
+	if err := tempFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tempFile.Name(), path)
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// cliTest runs the command line tool against fake sites with a temporary state directory
+type cliTest struct {
+	t           *testing.T
+	hummingbird *FakeHummingbird
+	myAnimeList *FakeMyAnimeList
+	flags       []string
+}
+
+func newCLITest(t *testing.T) (*cliTest, func()) {
+	hummingbird := NewFakeHummingbird()
+	hummingbird.AddUser("darin_minamoto", "token")
+	myAnimeList := NewFakeMyAnimeList()
+	myAnimeList.AddUser("darin_minamoto", "password")
+	hummingbirdServer := httptest.NewServer(hummingbird)
+	malServer := httptest.NewServer(myAnimeList)
+	restoreThrottles := withoutListThrottles()
+
+	test := &cliTest{
+		t:           t,
+		hummingbird: hummingbird,
+		myAnimeList: myAnimeList,
+		flags: []string{
+			"-state-dir", t.TempDir(), "-config", "",
+			"-user", "darin_minamoto", "-token", "token", "-hummingbird-url", hummingbirdServer.URL,
+			"-mal-user", "darin_minamoto", "-mal-password", "password", "-mal-url", malServer.URL,
+		},
+	}
+	return test, func() {
+		restoreThrottles()
+		hummingbirdServer.Close()
+		malServer.Close()
+	}
+}
+
+// run runs the command line tool and fails the test if the exit code isn't the expected one
+func (c *cliTest) run(expectedCode int, args ...string) string {
+	var stdout, stderr bytes.Buffer
+	code := runCLI(append(append([]string{}, c.flags...), args...), nil, &stdout, &stderr)
+	if code != expectedCode {
+		c.t.Fatalf("%v exited with %d, expected %d: %s%s", args, code, expectedCode, stdout.String(), stderr.String())
+	}
+	return stdout.String()
+}
+
+func TestRunCLI_EditAndPush(t *testing.T) {
+	test, cleanup := newCLITest(t)
+	defer cleanup()
+	test.hummingbird.AddAnime(HummingbirdAnimeData{Id: 3, MalID: 103, Title: "Sample text"})
+	test.hummingbird.SetEntry("darin_minamoto", newSyncTestAnime(1, 101, 3))
+	test.hummingbird.SetEntry("darin_minamoto", newSyncTestAnime(2, 102, 5))
+
+	if output := test.run(0, "fetch"); !strings.Contains(output, "Fetched 2 anime") {
+		t.Errorf("TestRunCLI_EditAndPush failed: unexpected fetch output %q", output)
+	}
+
+	// each command loads the cached library and the pending changes of the previous commands
+	test.run(0, "add", "3", "-episodes", "1", "-mal-id", "103")
+	test.run(0, "edit", "-status", "completed", "-episodes", "12", "1")
+	test.run(0, "remove", "2")
+	test.run(0, "add", "4")
+	test.run(0, "undo")
+	// fetching again keeps the pending changes
+	test.run(0, "fetch")
+
+	if output := test.run(0, "status"); !strings.Contains(output, "3 change(s) to push") {
+		t.Errorf("TestRunCLI_EditAndPush failed: unexpected status output %q", output)
+	}
+	if output := test.run(0, "push", "-dry-run"); !strings.Contains(output, "/api/v1/libraries/2/remove") {
+		t.Errorf("TestRunCLI_EditAndPush failed: unexpected dry run output %q", output)
+	}
+	if len(test.hummingbird.Requests()) != 2 {
+		t.Errorf("TestRunCLI_EditAndPush failed: expected the dry run to send nothing got %+v", test.hummingbird.Requests())
+	}
+
+	test.run(0, "push")
+	library := test.hummingbird.Library("darin_minamoto")
+	if len(library) != 2 || library[0].Data.Id != 1 || library[1].Data.Id != 3 {
+		t.Fatalf("TestRunCLI_EditAndPush failed: unexpected library %+v", library)
+	}
+	if library[0].AnimeStatus != "completed" || library[0].NumEpisodesWatched != 12 {
+		t.Errorf("TestRunCLI_EditAndPush failed: expected anime 1 to be edited got %+v", library[0])
+	}
+
+	var anime []cliAnime
+	if err := json.Unmarshal([]byte(test.run(0, "-json", "list")), &anime); err != nil {
+		t.Fatalf("TestRunCLI_EditAndPush failed: %v", err)
+	}
+	if len(anime) != 2 || anime[1].ID != 3 || anime[1].MyAnimeListID != 103 || anime[1].Episodes != 1 {
+		t.Errorf("TestRunCLI_EditAndPush failed: unexpected list %+v", anime)
+	}
+	if output := test.run(0, "status"); !strings.Contains(output, "0 change(s) to push") {
+		t.Errorf("TestRunCLI_EditAndPush failed: unexpected status output %q", output)
+	}
+}
+
+func TestRunCLI_Sync(t *testing.T) {
+	test, cleanup := newCLITest(t)
+	defer cleanup()
+	test.hummingbird.SetEntry("darin_minamoto", newSyncTestAnime(1, 101, 3))
+	test.myAnimeList.AddAnime(newFakeMALTestAnime(101, 0, 0))
+
+	test.run(0, "-list", "hummingbird", "fetch")
+	test.run(0, "-list", "myanimelist", "fetch")
+	if output := test.run(0, "sync", "-push"); !strings.Contains(output, "myanimelist: 1 change(s) pushed") {
+		t.Errorf("TestRunCLI_Sync failed: unexpected sync output %q", output)
+	}
+	if library := test.myAnimeList.Library("darin_minamoto"); len(library) != 1 || library[0].MyWatchedEpisodes != 3 {
+		t.Errorf("TestRunCLI_Sync failed: expected the anime to be synced got %+v", library)
+	}
+
+	// the snapshot is kept, so syncing again changes nothing
+	if output := test.run(0, "sync"); !strings.Contains(output, "myanimelist: 0 change(s) to push") {
+		t.Errorf("TestRunCLI_Sync failed: unexpected sync output %q", output)
+	}
+}
+
+var runCLIErrorTests = []struct {
+	args []string
+	code int
+}{
+	{[]string{}, 2},
+	{[]string{"unknown"}, 1},
+	{[]string{"-list", "anidb", "list"}, 1},
+	{[]string{"edit"}, 1},
+	{[]string{"edit", "abc"}, 1},
+	{[]string{"edit", "1"}, 1},
+	{[]string{"add", "1", "-status", "watched"}, 1},
+	{[]string{"add", "1", "-score", "11"}, 1},
+	{[]string{"add", "1", "-start", "2016-05-20"}, 1},
+	{[]string{"-list", "myanimelist", "add", "101", "-finish", "20/05/2016"}, 1},
+	{[]string{"-list", "myanimelist", "add", "101", "-private"}, 1},
+	{[]string{"sync", "-resolver", "newest"}, 1},
+}
+
+func TestRunCLI_Errors(t *testing.T) {
+	test, cleanup := newCLITest(t)
+	defer cleanup()
+
+	for _, tt := range runCLIErrorTests {
+		var stdout, stderr bytes.Buffer
+		code := runCLI(append(append([]string{}, test.flags...), tt.args...), nil, &stdout, &stderr)
+		if code != tt.code {
+			t.Errorf("TestRunCLI_Errors failed: %v exited with %d, expected %d", tt.args, code, tt.code)
+		}
+		if stderr.Len() == 0 {
+			t.Errorf("TestRunCLI_Errors failed: %v printed no error", tt.args)
+		}
+	}
+}
+
+func TestRunCLI_OptionalFields(t *testing.T) {
+	test, cleanup := newCLITest(t)
+	defer cleanup()
+	test.myAnimeList.AddAnime(newFakeMALTestAnime(101, 0, 0))
+
+	flags := append(test.flags, "-list", "myanimelist")
+	malTest := &cliTest{t: t, flags: flags}
+	malTest.run(0, "add", "101", "-episodes", "3", "-score", "7", "-start", "2016-05-20", "-notes", "Sample text")
+	malTest.run(0, "push")
+	malTest.run(0, "edit", "101", "-episodes", "4")
+
+	var anime []cliAnime
+	if err := json.Unmarshal([]byte(malTest.run(0, "-json", "list")), &anime); err != nil {
+		t.Fatalf("TestRunCLI_OptionalFields failed: %v", err)
+	}
+	if len(anime) != 1 || anime[0].Episodes != 4 || anime[0].Score != 7 || anime[0].StartDate != "2016-05-20" ||
+		anime[0].Notes != "Sample text" {
+		t.Errorf("TestRunCLI_OptionalFields failed: expected the edit to keep the optional fields got %+v", anime)
+	}
+	if output := malTest.run(0, "push", "-dry-run"); strings.Contains(output, "date_start") || strings.Contains(output, "score") {
+		t.Errorf("TestRunCLI_OptionalFields failed: expected only the episodes to be sent got %q", output)
+	}
+}
+
+func TestRunCLI_StatusShowsPushFailures(t *testing.T) {
+	test, cleanup := newCLITest(t)
+	defer cleanup()
+	test.hummingbird.SetEntry("darin_minamoto", newSyncTestAnime(1, 101, 3))
+	test.hummingbird.SetEntry("darin_minamoto", newSyncTestAnime(2, 102, 5))
+	test.run(0, "fetch")
+
+	test.run(0, "edit", "-episodes", "4", "1")
+	test.run(0, "edit", "-episodes", "6", "2")
+	test.hummingbird.InjectFault(FakeFault{Method: "POST", PathPrefix: "/api/v1/libraries/2", StatusCode: 500})
+	test.run(1, "push")
+
+	// the status is shown by a new run, so the failures of the push were saved
+	if output := test.run(0, "status"); !strings.Contains(output, "1 change(s) to push") || !strings.Contains(output, "(failed: ") {
+		t.Errorf("TestRunCLI_StatusShowsPushFailures failed: expected the failure of anime 2 got %q", output)
+	}
+
+	test.hummingbird.ClearFaults()
+	test.run(0, "push")
+	if output := test.run(0, "status"); strings.Contains(output, "failed") {
+		t.Errorf("TestRunCLI_StatusShowsPushFailures failed: expected no failures after a successful push got %q", output)
+	}
+}
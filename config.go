@@ -0,0 +1,490 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ConfigEnvPrefix is the prefix of the environment variables that override the config
+const ConfigEnvPrefix = "MYHUMMINGLIST_"
+
+// Config defines the accounts of the anime lists and how they are synced.
+// It is usually loaded from a JSON file with LoadConfig, like
+//
+//	{
+//	  "accounts": {
+//	    "main": {"service": "hummingbird", "username": "darin", "token_env": "HUMMINGBIRD_TOKEN"},
+//	    "mal": {"service": "myanimelist", "username": "darin", "timeout": "1m",
+//	            "credentials": {"file": "credentials.json", "passphrase_env": "MYHUMMINGLIST_PASSPHRASE"},
+//	            "rate_limit": {"max_concurrent": 2, "requests_per_second": 1, "burst": 2}}
+//	  },
+//	  "primary": "main",
+//	  "replicas": ["mal"],
+//	  "resolver": "progress"
+//	}
+type Config struct {
+	// Accounts are the accounts of the anime lists by name
+	Accounts map[string]*AccountConfig `json:"accounts"`
+	// Primary is the name of the account of the primary list
+	Primary string `json:"primary"`
+	// Replicas are the names of the accounts of the lists that are synced to the primary list
+	Replicas []string `json:"replicas,omitempty"`
+	// Resolver is the name of the conflict resolver used when syncing, conflicts are returned if it is empty.
+	// It is one of none, primary, progress, recent or merge
+	Resolver string `json:"resolver,omitempty"`
+}
+
+// AccountConfig defines an account on an anime list site
+type AccountConfig struct {
+	// Service is the site of the account, "hummingbird" or "myanimelist"
+	Service  string `json:"service"`
+	Username string `json:"username"`
+	// Token is the auth token of a Hummingbird account
+	Token Credential `json:"token,omitempty"`
+	// Password is the password of a MyAnimeList account
+	Password Credential `json:"password,omitempty"`
+	// TokenEnv is the name of an environment variable with the token or password
+	TokenEnv string `json:"token_env,omitempty"`
+	// Credentials is where the token or password comes from if it isn't in the config
+	Credentials *CredentialConfig `json:"credentials,omitempty"`
+
+	// BaseURL replaces the scheme and host of the site's URLs
+	BaseURL   string `json:"base_url,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+	// Timeout is how long a fetch, push or undo waits for its requests, like "30s"
+	Timeout string `json:"timeout,omitempty"`
+	// MaxAttempts is the number of times a request is sent before giving up
+	MaxAttempts int `json:"max_attempts,omitempty"`
+	// RateLimit limits the requests of the account instead of the shared limits of the site
+	RateLimit *RateLimitConfig `json:"rate_limit,omitempty"`
+}
+
+// CredentialConfig defines the CredentialProvider of an account. Exactly one of Env, File and Command is set
+type CredentialConfig struct {
+	// Env is the name of an environment variable with the credential
+	Env string `json:"env,omitempty"`
+	// File is the path of a credential file, relative to the config file,
+	// that is encrypted with the passphrase in the PassphraseEnv environment variable
+	File          string `json:"file,omitempty"`
+	PassphraseEnv string `json:"passphrase_env,omitempty"`
+	// Command is a git credential style helper program followed by its arguments
+	Command []string `json:"command,omitempty"`
+}
+
+// RateLimitConfig defines the limits of a Throttle
+type RateLimitConfig struct {
+	MaxConcurrent     int     `json:"max_concurrent"`
+	RequestsPerSecond float64 `json:"requests_per_second"`
+	Burst             int     `json:"burst"`
+}
+
+// ConfigError is an invalid value in a config
+type ConfigError struct {
+	// Path is the file the config was loaded from, empty if it wasn't loaded from a file
+	Path string
+	// Key is the dotted path of the invalid value like "accounts.main.timeout",
+	// empty if the error isn't about a single value
+	Key string
+	Err error
+}
+
+func (e *ConfigError) Error() string {
+	var prefix string
+	if e.Path != "" {
+		prefix = e.Path + ": "
+	}
+	if e.Key != "" {
+		prefix += e.Key + ": "
+	}
+	return prefix + e.Err.Error()
+}
+
+// configServices are the list types of the services of accounts
+var configServices = map[string]int{
+	"hummingbird": Hummingbird,
+	"myanimelist": MyAnimeList,
+}
+
+// LoadConfig reads the JSON config at the path, applies the environment variable
+// overrides and validates it
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := ParseConfig(data, os.LookupEnv)
+	if configErr, ok := err.(*ConfigError); ok {
+		configErr.Path = path
+		return nil, configErr
+	} else if err != nil {
+		return nil, err
+	}
+
+	for _, account := range config.Accounts {
+		if credentials := account.Credentials; credentials != nil && credentials.File != "" && !filepath.IsAbs(credentials.File) {
+			credentials.File = filepath.Join(filepath.Dir(path), credentials.File)
+		}
+	}
+	return config, nil
+}
+
+// ParseConfig decodes a JSON config, applies the overrides in the environment
+// variables looked up by lookupEnv and validates it
+func ParseConfig(data []byte, lookupEnv func(string) (string, bool)) (*Config, error) {
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, configDecodeError(err)
+	}
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, configDecodeError(err)
+	}
+	if err := checkConfigKeys("", raw, configKeys); err != nil {
+		return nil, err
+	}
+
+	if err := config.applyEnv(lookupEnv); err != nil {
+		return nil, err
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// configDecodeError returns a ConfigError with the key of a JSON decoding error if it has one
+func configDecodeError(err error) error {
+	switch err := err.(type) {
+	case *json.UnmarshalTypeError:
+		return &ConfigError{Key: err.Field, Err: fmt.Errorf("Expected a %s value, got %s", err.Type, err.Value)}
+	case *json.SyntaxError:
+		return &ConfigError{Err: fmt.Errorf("Invalid JSON at offset %d: %v", err.Offset, err)}
+	}
+	return &ConfigError{Err: err}
+}
+
+// configKey is a key allowed in a config, with the keys allowed in its value if it is an object.
+// The keys of an object with the name "*" can have any name
+type configKey map[string]configKey
+
+var rateLimitKeys = configKey{"max_concurrent": nil, "requests_per_second": nil, "burst": nil}
+
+var credentialKeys = configKey{"env": nil, "file": nil, "passphrase_env": nil, "command": nil}
+
+var accountKeys = configKey{
+	"service": nil, "username": nil, "token": nil, "password": nil, "token_env": nil, "credentials": credentialKeys,
+	"base_url": nil, "user_agent": nil, "timeout": nil, "max_attempts": nil, "rate_limit": rateLimitKeys,
+}
+
+var configKeys = configKey{
+	"accounts": configKey{"*": accountKeys},
+	"primary":  nil,
+	"replicas": nil,
+	"resolver": nil,
+}
+
+// checkConfigKeys returns a ConfigError for the first key in the object that isn't allowed
+func checkConfigKeys(prefix string, object map[string]interface{}, allowed configKey) error {
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		children, ok := allowed[key]
+		if !ok {
+			children, ok = allowed["*"]
+		}
+		if !ok {
+			return &ConfigError{Key: prefix + key, Err: errors.New("Unknown key")}
+		}
+		if value, isObject := object[key].(map[string]interface{}); isObject && children != nil {
+			if err := checkConfigKeys(prefix+key+".", value, children); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// configEnvName returns the environment variable name of a config key, like
+// MYHUMMINGLIST_ACCOUNTS_MAIN_TOKEN for accounts.main.token
+func configEnvName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, key)
+	return ConfigEnvPrefix + name
+}
+
+// applyEnv overrides the config with the environment variables named after its keys.
+// MYHUMMINGLIST_REPLICAS is a comma separated list of account names
+func (c *Config) applyEnv(lookupEnv func(string) (string, bool)) error {
+	if value, ok := lookupEnv(configEnvName("primary")); ok {
+		c.Primary = value
+	}
+	if value, ok := lookupEnv(configEnvName("replicas")); ok {
+		c.Replicas = nil
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				c.Replicas = append(c.Replicas, name)
+			}
+		}
+	}
+	if value, ok := lookupEnv(configEnvName("resolver")); ok {
+		c.Resolver = value
+	}
+
+	for name, account := range c.Accounts {
+		if account == nil {
+			continue
+		}
+		prefix := "accounts." + name + "."
+		fields := map[string]*string{
+			"service":    &account.Service,
+			"username":   &account.Username,
+			"token_env":  &account.TokenEnv,
+			"base_url":   &account.BaseURL,
+			"user_agent": &account.UserAgent,
+			"timeout":    &account.Timeout,
+		}
+		for key, field := range fields {
+			if value, ok := lookupEnv(configEnvName(prefix + key)); ok {
+				*field = value
+			}
+		}
+		if value, ok := lookupEnv(configEnvName(prefix + "token")); ok {
+			account.Token = Credential(value)
+		}
+		if value, ok := lookupEnv(configEnvName(prefix + "password")); ok {
+			account.Password = Credential(value)
+		}
+
+		key := prefix + "max_attempts"
+		if value, ok := lookupEnv(configEnvName(key)); ok {
+			maxAttempts, err := strconv.Atoi(value)
+			if err != nil {
+				return &ConfigError{Key: key, Err: fmt.Errorf("Invalid number %q in %s", value, configEnvName(key))}
+			}
+			account.MaxAttempts = maxAttempts
+		}
+	}
+	return nil
+}
+
+// Validate checks that the config can build the lists and returns a ConfigError for the first invalid value
+func (c *Config) Validate() error {
+	if len(c.Accounts) == 0 {
+		return &ConfigError{Key: "accounts", Err: errors.New("At least one account is required")}
+	}
+
+	names := make([]string, 0, len(c.Accounts))
+	for name := range c.Accounts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := c.Accounts[name].validate("accounts." + name); err != nil {
+			return err
+		}
+	}
+
+	if c.Primary == "" {
+		return &ConfigError{Key: "primary", Err: errors.New("The primary account is required")}
+	}
+	if _, ok := c.Accounts[c.Primary]; !ok {
+		return &ConfigError{Key: "primary", Err: fmt.Errorf("Unknown account %q", c.Primary)}
+	}
+	seen := map[string]bool{c.Primary: true}
+	for i, name := range c.Replicas {
+		key := fmt.Sprintf("replicas[%d]", i)
+		if _, ok := c.Accounts[name]; !ok {
+			return &ConfigError{Key: key, Err: fmt.Errorf("Unknown account %q", name)}
+		}
+		if seen[name] {
+			return &ConfigError{Key: key, Err: fmt.Errorf("Account %q is already the primary or a replica", name)}
+		}
+		seen[name] = true
+	}
+
+	if _, ok := conflictResolvers[c.Resolver]; c.Resolver != "" && !ok {
+		return &ConfigError{Key: "resolver", Err: fmt.Errorf("Unknown conflict resolver %q", c.Resolver)}
+	}
+	return nil
+}
+
+// validate checks the account at the key
+func (a *AccountConfig) validate(key string) error {
+	if a == nil {
+		return &ConfigError{Key: key, Err: errors.New("The account is empty")}
+	}
+
+	listType, ok := configServices[a.Service]
+	if !ok {
+		return &ConfigError{Key: key + ".service", Err: fmt.Errorf("Unknown service %q, expected hummingbird or myanimelist", a.Service)}
+	}
+	if a.Username == "" {
+		return &ConfigError{Key: key + ".username", Err: errors.New("The username is required")}
+	}
+	if listType == Hummingbird && a.Password != "" {
+		return &ConfigError{Key: key + ".password", Err: errors.New("Hummingbird accounts use a token instead of a password")}
+	}
+	if listType == MyAnimeList && a.Token != "" {
+		return &ConfigError{Key: key + ".token", Err: errors.New("MyAnimeList accounts use a password instead of a token")}
+	}
+	if a.TokenEnv != "" && a.Credentials != nil {
+		return &ConfigError{Key: key + ".credentials", Err: errors.New("Only one of token_env and credentials can be set")}
+	}
+	if err := a.Credentials.validate(key + ".credentials"); err != nil {
+		return err
+	}
+
+	if a.BaseURL != "" {
+		baseURL, err := url.Parse(a.BaseURL)
+		if err != nil || baseURL.Scheme == "" || baseURL.Host == "" {
+			return &ConfigError{Key: key + ".base_url", Err: fmt.Errorf("Invalid URL %q", a.BaseURL)}
+		}
+	}
+	if a.Timeout != "" {
+		if timeout, err := time.ParseDuration(a.Timeout); err != nil || timeout <= 0 {
+			return &ConfigError{Key: key + ".timeout", Err: fmt.Errorf("Invalid duration %q", a.Timeout)}
+		}
+	}
+	if a.MaxAttempts < 0 {
+		return &ConfigError{Key: key + ".max_attempts", Err: errors.New("The number of attempts can't be negative")}
+	}
+	if limit := a.RateLimit; limit != nil {
+		switch {
+		case limit.MaxConcurrent < 0:
+			return &ConfigError{Key: key + ".rate_limit.max_concurrent", Err: errors.New("The limit can't be negative")}
+		case limit.RequestsPerSecond < 0:
+			return &ConfigError{Key: key + ".rate_limit.requests_per_second", Err: errors.New("The limit can't be negative")}
+		case limit.Burst < 0:
+			return &ConfigError{Key: key + ".rate_limit.burst", Err: errors.New("The limit can't be negative")}
+		}
+	}
+	return nil
+}
+
+// ListType returns the list type of the account's service
+func (a *AccountConfig) ListType() int {
+	return configServices[a.Service]
+}
+
+// validate checks the credential config at the key
+func (c *CredentialConfig) validate(key string) error {
+	if c == nil {
+		return nil
+	}
+
+	sources := 0
+	for _, set := range []bool{c.Env != "", c.File != "", len(c.Command) > 0} {
+		if set {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return &ConfigError{Key: key, Err: errors.New("Exactly one of env, file and command must be set")}
+	}
+	if c.File != "" && c.PassphraseEnv == "" {
+		return &ConfigError{Key: key + ".passphrase_env", Err: errors.New("A credential file needs the environment variable of its passphrase")}
+	}
+	if c.File == "" && c.PassphraseEnv != "" {
+		return &ConfigError{Key: key + ".passphrase_env", Err: errors.New("The passphrase is only used by credential files")}
+	}
+	return nil
+}
+
+// provider returns the credential provider of the credential config
+func (c *CredentialConfig) provider() CredentialProvider {
+	switch {
+	case c.File != "":
+		return EncryptedFileCredentials{Path: c.File, Passphrase: EnvPassphrase(c.PassphraseEnv)}
+	case len(c.Command) > 0:
+		return CommandCredentials{Command: c.Command}
+	default:
+		return EnvCredentials{Variable: c.Env}
+	}
+}
+
+// credential returns the token or password of the account in the config
+func (a *AccountConfig) credential() Credential {
+	if a.ListType() == MyAnimeList {
+		return a.Password
+	}
+	return a.Token
+}
+
+// options returns the list options of the account
+func (a *AccountConfig) options() ListOptions {
+	options := ListOptions{BaseURL: a.BaseURL, UserAgent: a.UserAgent}
+	if a.Timeout != "" {
+		options.Timeout, _ = time.ParseDuration(a.Timeout)
+	}
+	if a.MaxAttempts > 0 {
+		retry := DefaultRetryPolicy
+		retry.MaxAttempts = a.MaxAttempts
+		options.Retry = &retry
+	}
+	if limit := a.RateLimit; limit != nil {
+		options.Throttle = NewThrottle(limit.MaxConcurrent, limit.RequestsPerSecond, limit.Burst)
+	}
+	if a.TokenEnv != "" {
+		options.Credentials = EnvCredentials{Variable: a.TokenEnv}
+	} else if a.Credentials != nil {
+		options.Credentials = a.Credentials.provider()
+	}
+	return options
+}
+
+// NewList returns an empty anime list for the account with the name
+func (c *Config) NewList(name string) (Animelist, error) {
+	account, ok := c.Accounts[name]
+	if !ok || account == nil {
+		return nil, fmt.Errorf("Unknown account %q", name)
+	}
+
+	switch account.ListType() {
+	case Hummingbird:
+		return NewHummingbirdAnimeList(account.Username, account.credential(), account.options()), nil
+	case MyAnimeList:
+		return NewMyAnimeListAnimeList(account.Username, account.credential(), account.options()), nil
+	default:
+		return nil, &ConfigError{Key: "accounts." + name + ".service", Err: fmt.Errorf("Unknown service %q", account.Service)}
+	}
+}
+
+// NewManager returns a manager that syncs the lists of the replica accounts to the list of
+// the primary account with the conflict resolver of the config
+func (c *Config) NewManager() (*AnimelistManager, error) {
+	primary, err := c.NewList(c.Primary)
+	if err != nil {
+		return nil, err
+	}
+	replicas := make([]Animelist, len(c.Replicas))
+	for i, name := range c.Replicas {
+		if replicas[i], err = c.NewList(name); err != nil {
+			return nil, err
+		}
+	}
+
+	manager := NewAnimelistManager(primary, replicas...)
+	manager.SetConflictResolver(conflictResolvers[c.Resolver])
+	return manager, nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testConfig = `{
+	"accounts": {
+		"main": {"service": "hummingbird", "username": "darin_minamoto", "token": "token"},
+		"mal": {
+			"service": "myanimelist",
+			"username": "darin_minamoto",
+			"token_env": "TEST_MAL_PASSWORD",
+			"timeout": "1m",
+			"max_attempts": 2,
+			"rate_limit": {"max_concurrent": 3, "requests_per_second": 1, "burst": 2}
+		},
+		"backup": {"service": "hummingbird", "username": "backup"}
+	},
+	"primary": "main",
+	"replicas": ["mal"],
+	"resolver": "progress"
+}`
+
+// testEnv returns a lookupEnv function that looks up the variables in the map
+func testEnv(env map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+}
+
+func TestParseConfig(t *testing.T) {
+	config, err := ParseConfig([]byte(testConfig), testEnv(nil))
+	if err != nil {
+		t.Fatalf("TestParseConfig failed: %v", err)
+	}
+
+	mal := config.Accounts["mal"]
+	if config.Primary != "main" || len(config.Replicas) != 1 || mal.ListType() != MyAnimeList {
+		t.Fatalf("TestParseConfig failed: unexpected config %+v", config)
+	}
+	options := mal.options()
+	if options.Timeout != time.Minute || options.Retry.MaxAttempts != 2 || options.Throttle.MaxConcurrent() != 3 {
+		t.Errorf("TestParseConfig failed: unexpected options %+v", options)
+	}
+	if options.Credentials != (EnvCredentials{Variable: "TEST_MAL_PASSWORD"}) {
+		t.Errorf("TestParseConfig failed: expected the password from the environment got %+v", options.Credentials)
+	}
+}
+
+func TestParseConfig_Env(t *testing.T) {
+	config, err := ParseConfig([]byte(testConfig), testEnv(map[string]string{
+		"MYHUMMINGLIST_REPLICAS":                  "mal, backup",
+		"MYHUMMINGLIST_RESOLVER":                  "recent",
+		"MYHUMMINGLIST_ACCOUNTS_MAIN_TOKEN":       "other_token",
+		"MYHUMMINGLIST_ACCOUNTS_MAL_BASE_URL":     "http://localhost:8080",
+		"MYHUMMINGLIST_ACCOUNTS_MAL_MAX_ATTEMPTS": "5",
+	}))
+	if err != nil {
+		t.Fatalf("TestParseConfig_Env failed: %v", err)
+	}
+	if len(config.Replicas) != 2 || config.Replicas[1] != "backup" || config.Resolver != "recent" {
+		t.Errorf("TestParseConfig_Env failed: unexpected topology %+v", config)
+	}
+	if main, mal := config.Accounts["main"], config.Accounts["mal"]; main.Token != "other_token" ||
+		mal.BaseURL != "http://localhost:8080" || mal.MaxAttempts != 5 {
+		t.Errorf("TestParseConfig_Env failed: unexpected accounts %+v %+v", main, mal)
+	}
+
+	_, err = ParseConfig([]byte(testConfig), testEnv(map[string]string{"MYHUMMINGLIST_ACCOUNTS_MAL_MAX_ATTEMPTS": "many"}))
+	if configErr, ok := err.(*ConfigError); !ok || configErr.Key != "accounts.mal.max_attempts" {
+		t.Errorf("TestParseConfig_Env failed: expected an error for the max attempts got %v", err)
+	}
+}
+
+var invalidConfigTests = []struct {
+	config string
+	key    string
+}{
+	{`{"accounts": {}}`, "accounts"},
+	{`{"accounts": {"a": {"service": "anidb", "username": "u"}}, "primary": "a"}`, "accounts.a.service"},
+	{`{"accounts": {"a": {"service": "hummingbird"}}, "primary": "a"}`, "accounts.a.username"},
+	{`{"accounts": {"a": {"service": "hummingbird", "username": "u", "password": "p"}}, "primary": "a"}`, "accounts.a.password"},
+	{`{"accounts": {"a": {"service": "myanimelist", "username": "u", "token": "t"}}, "primary": "a"}`, "accounts.a.token"},
+	{`{"accounts": {"a": {"service": "hummingbird", "username": "u", "base_url": "localhost"}}, "primary": "a"}`, "accounts.a.base_url"},
+	{`{"accounts": {"a": {"service": "hummingbird", "username": "u", "timeout": "soon"}}, "primary": "a"}`, "accounts.a.timeout"},
+	{`{"accounts": {"a": {"service": "hummingbird", "username": "u", "rate_limit": {"burst": -1}}}, "primary": "a"}`,
+		"accounts.a.rate_limit.burst"},
+	{`{"accounts": {"a": {"service": "hummingbird", "username": "u", "timeot": "1s"}}, "primary": "a"}`, "accounts.a.timeot"},
+	{`{"accounts": {"a": {"service": "hummingbird", "username": "u"}}}`, "primary"},
+	{`{"accounts": {"a": {"service": "hummingbird", "username": "u"}}, "primary": "b"}`, "primary"},
+	{`{"accounts": {"a": {"service": "hummingbird", "username": "u"}}, "primary": "a", "replicas": ["b"]}`, "replicas[0]"},
+	{`{"accounts": {"a": {"service": "hummingbird", "username": "u"}}, "primary": "a", "replicas": ["a"]}`, "replicas[0]"},
+	{`{"accounts": {"a": {"service": "hummingbird", "username": "u"}}, "primary": "a", "resolver": "newest"}`, "resolver"},
+}
+
+func TestParseConfig_Invalid(t *testing.T) {
+	for _, tt := range invalidConfigTests {
+		_, err := ParseConfig([]byte(tt.config), testEnv(nil))
+		if configErr, ok := err.(*ConfigError); !ok || configErr.Key != tt.key {
+			t.Errorf("TestParseConfig_Invalid failed: expected an error for %s got %v", tt.key, err)
+		}
+	}
+}
+
+func TestLoadConfig_NewManager(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := ioutil.WriteFile(path, []byte(testConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("TestLoadConfig_NewManager failed: %v", err)
+	}
+
+	manager, err := config.NewManager()
+	if err != nil {
+		t.Fatalf("TestLoadConfig_NewManager failed: %v", err)
+	}
+	if manager.primary.Type() != Hummingbird || len(manager.replicas) != 1 || manager.replicas[0].Type() != MyAnimeList {
+		t.Errorf("TestLoadConfig_NewManager failed: unexpected lists %+v", manager.lists())
+	}
+	if manager.resolver == nil {
+		t.Errorf("TestLoadConfig_NewManager failed: expected a conflict resolver")
+	}
+
+	if err := ioutil.WriteFile(path, []byte(`{"accounts": {"a": []}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadConfig(path); err == nil || !strings.HasPrefix(err.Error(), path+": ") {
+		t.Errorf("TestLoadConfig_NewManager failed: expected an error with the path got %v", err)
+	}
+}
+
+func TestRunCLI_Config(t *testing.T) {
+	defer withoutListThrottles()()
+
+	fake := NewFakeHummingbird()
+	fake.AddUser("darin_minamoto", "token")
+	fake.AddUser("backup", "backup_token")
+	fake.SetEntry("darin_minamoto", newSyncTestAnime(1, 101, 3))
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	config := `{
+		"accounts": {
+			"main": {"service": "hummingbird", "username": "darin_minamoto", "token": "token", "base_url": "` + server.URL + `"},
+			"backup": {"service": "hummingbird", "username": "backup", "token_env": "TEST_BACKUP_TOKEN", "base_url": "` + server.URL + `"}
+		},
+		"primary": "main",
+		"replicas": ["backup"]
+	}`
+	if err := ioutil.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("TEST_BACKUP_TOKEN", "backup_token")
+	defer os.Unsetenv("TEST_BACKUP_TOKEN")
+
+	test := &cliTest{t: t, flags: []string{"-state-dir", dir, "-config", path}}
+	test.run(0, "fetch")
+	test.run(0, "-list", "backup", "fetch")
+	if output := test.run(0, "sync", "-push"); !strings.Contains(output, "backup: 1 change(s) pushed") {
+		t.Errorf("TestRunCLI_Config failed: unexpected sync output %q", output)
+	}
+	if library := fake.Library("backup"); len(library) != 1 || library[0].NumEpisodesWatched != 3 {
+		t.Errorf("TestRunCLI_Config failed: expected the backup account to be synced got %+v", library)
+	}
+}
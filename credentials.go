@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// RedactedCredential is shown instead of a credential when it is printed
+const RedactedCredential = "REDACTED"
+
+// Credential is a secret like an auth token or password. It is shown as RedactedCredential
+// when it is formatted with fmt or encoded as JSON, so it can't leak into output by accident
+type Credential string
+
+func (c Credential) String() string {
+	return RedactedCredential
+}
+
+func (c Credential) GoString() string {
+	return RedactedCredential
+}
+
+func (c Credential) MarshalJSON() ([]byte, error) {
+	return json.Marshal(RedactedCredential)
+}
+
+// Secret returns the secret of the credential
+func (c Credential) Secret() string {
+	return string(c)
+}
+
+// CredentialProvider returns the credentials of accounts so that they don't have to be
+// kept in plaintext in configs or passed on the command line
+type CredentialProvider interface {
+	// Credential returns the auth token or password of the user on the service,
+	// "hummingbird" or "myanimelist"
+	Credential(service string, username string) (Credential, error)
+}
+
+// credentialService returns the service name of the list type that credential providers are asked for
+func credentialService(listType int) string {
+	return strings.ToLower(ListTypeName(listType))
+}
+
+// credentialHosts are the hosts of the services that credential helpers are asked for
+var credentialHosts = map[string]string{
+	"hummingbird": "hummingbird.me",
+	"myanimelist": "myanimelist.net",
+}
+
+// EnvCredentials returns the credential in an environment variable
+type EnvCredentials struct {
+	// Variable is the name of the environment variable. If it is empty the name is
+	// MYHUMMINGLIST_<SERVICE>_<USERNAME>_TOKEN, like MYHUMMINGLIST_HUMMINGBIRD_DARIN_TOKEN
+	Variable string
+}
+
+func (p EnvCredentials) Credential(service string, username string) (Credential, error) {
+	variable := p.Variable
+	if variable == "" {
+		variable = configEnvName(service + "_" + username + "_token")
+	}
+	value, ok := os.LookupEnv(variable)
+	if !ok || value == "" {
+		return "", fmt.Errorf("The credential of %s user %s isn't set in $%s", service, username, variable)
+	}
+	return Credential(value), nil
+}
+
+// CommandCredentials returns the credential printed by an external helper program that
+// works like a git credential helper. The helper is run with a "get" argument and is sent
+//
+//	protocol=https
+//	host=hummingbird.me
+//	username=darin
+//
+// on its standard input, and the credential is read from a "password=" line of its output
+type CommandCredentials struct {
+	// Command is the program to run followed by its arguments
+	Command []string
+}
+
+func (p CommandCredentials) Credential(service string, username string) (Credential, error) {
+	if len(p.Command) == 0 {
+		return "", errors.New("The credential helper command is empty")
+	}
+
+	var input bytes.Buffer
+	fmt.Fprintf(&input, "protocol=https\nhost=%s\nusername=%s\n\n", credentialHosts[service], username)
+	var stdout, stderr bytes.Buffer
+	command := exec.Command(p.Command[0], append(p.Command[1:], "get")...)
+	command.Stdin = &input
+	command.Stdout = &stdout
+	command.Stderr = &stderr
+	if err := command.Run(); err != nil {
+		// the output of a failed helper isn't shown because it may have the credential in it
+		return "", fmt.Errorf("Credential helper %s failed: %v", p.Command[0], err)
+	}
+
+	scanner := bufio.NewScanner(&stdout)
+	for scanner.Scan() {
+		if value := strings.TrimPrefix(scanner.Text(), "password="); value != scanner.Text() && value != "" {
+			return Credential(value), nil
+		}
+	}
+	return "", fmt.Errorf("Credential helper %s returned no password for %s user %s", p.Command[0], service, username)
+}
+
+// credentialFileIterations is the number of PBKDF2 iterations that derive the key of a credential file
+const credentialFileIterations = 100000
+
+// credentialFile is the JSON format of an encrypted credential file. The plaintext is
+// a JSON object of the credentials keyed by "<service>:<username>"
+type credentialFile struct {
+	Version    int    `json:"version"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// EncryptedFileCredentials returns credentials from a file encrypted with AES-256-GCM
+// using a key derived from a passphrase
+type EncryptedFileCredentials struct {
+	Path string
+	// Passphrase returns the passphrase of the file
+	Passphrase func() (string, error)
+}
+
+// EnvPassphrase returns a passphrase function for EncryptedFileCredentials that reads the environment variable
+func EnvPassphrase(variable string) func() (string, error) {
+	return func() (string, error) {
+		value, ok := os.LookupEnv(variable)
+		if !ok || value == "" {
+			return "", fmt.Errorf("The credential file passphrase isn't set in $%s", variable)
+		}
+		return value, nil
+	}
+}
+
+func (p EncryptedFileCredentials) Credential(service string, username string) (Credential, error) {
+	credentials, err := p.read()
+	if err != nil {
+		return "", err
+	}
+	credential, ok := credentials[service+":"+username]
+	if !ok {
+		return "", fmt.Errorf("The credential file %s has no credential for %s user %s", p.Path, service, username)
+	}
+	return Credential(credential), nil
+}
+
+// Store adds or replaces a credential in the file, creating the file if it doesn't exist
+func (p EncryptedFileCredentials) Store(service string, username string, credential Credential) error {
+	credentials, err := p.read()
+	if os.IsNotExist(err) {
+		credentials = make(map[string]string)
+	} else if err != nil {
+		return err
+	}
+	credentials[service+":"+username] = credential.Secret()
+
+	plaintext, err := json.Marshal(credentials)
+	if err != nil {
+		return err
+	}
+	passphrase, err := p.Passphrase()
+	if err != nil {
+		return err
+	}
+
+	file := credentialFile{Version: 1, Salt: make([]byte, 16)}
+	if _, err := rand.Read(file.Salt); err != nil {
+		return err
+	}
+	aead, err := credentialCipher(passphrase, file.Salt)
+	if err != nil {
+		return err
+	}
+	file.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(file.Nonce); err != nil {
+		return err
+	}
+	file.Ciphertext = aead.Seal(nil, file.Nonce, plaintext, nil)
+
+	data, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p.Path), 0700); err != nil {
+		return err
+	}
+	// the temporary file that writeFileAtomic renames is only readable by the user
+	return writeFileAtomic(p.Path, data)
+}
+
+// read decrypts the credentials in the file
+func (p EncryptedFileCredentials) read() (map[string]string, error) {
+	data, err := os.ReadFile(p.Path)
+	if err != nil {
+		return nil, err
+	}
+	var file credentialFile
+	if err := json.Unmarshal(data, &file); err != nil || file.Version != 1 {
+		return nil, fmt.Errorf("%s isn't a credential file", p.Path)
+	}
+
+	passphrase, err := p.Passphrase()
+	if err != nil {
+		return nil, err
+	}
+	aead, err := credentialCipher(passphrase, file.Salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(file.Nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("%s isn't a credential file", p.Path)
+	}
+	plaintext, err := aead.Open(nil, file.Nonce, file.Ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Wrong passphrase for the credential file %s", p.Path)
+	}
+
+	var credentials map[string]string
+	if err := json.Unmarshal(plaintext, &credentials); err != nil {
+		return nil, fmt.Errorf("%s isn't a credential file", p.Path)
+	}
+	return credentials, nil
+}
+
+// credentialCipher returns the AES-256-GCM cipher of a passphrase and salt
+func credentialCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(pbkdf2SHA256([]byte(passphrase), salt, credentialFileIterations, 32))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// pbkdf2SHA256 derives a key of keyLength bytes from the password with PBKDF2-HMAC-SHA256 (RFC 8018)
+func pbkdf2SHA256(password []byte, salt []byte, iterations int, keyLength int) []byte {
+	prf := hmac.New(sha256.New, password)
+	var key []byte
+	for block := 1; len(key) < keyLength; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write([]byte{byte(block >> 24), byte(block >> 16), byte(block >> 8), byte(block)})
+		u := prf.Sum(nil)
+		t := append([]byte(nil), u...)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLength]
+}
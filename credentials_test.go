@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// countingCredentials is a credential provider that counts how many times it is asked
+type countingCredentials struct {
+	credential Credential
+	calls      int
+}
+
+func (p *countingCredentials) Credential(service string, username string) (Credential, error) {
+	p.calls++
+	return p.credential, nil
+}
+
+func TestCredential_Redacted(t *testing.T) {
+	credential := Credential("hunter2")
+	account := AccountConfig{Service: "hummingbird", Username: "darin_minamoto", Token: credential}
+	data, err := json.Marshal(account)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, output := range []string{
+		fmt.Sprint(credential),
+		fmt.Sprintf("%v %s %#v", credential, credential, credential),
+		fmt.Sprintf("%+v %#v", account, account),
+		string(data),
+	} {
+		if strings.Contains(output, "hunter2") || !strings.Contains(output, RedactedCredential) {
+			t.Errorf("TestCredential_Redacted failed: expected the credential to be redacted in %q", output)
+		}
+	}
+	if credential.Secret() != "hunter2" {
+		t.Errorf("TestCredential_Redacted failed: expected the secret got %q", credential.Secret())
+	}
+}
+
+func TestEnvCredentials(t *testing.T) {
+	os.Setenv("TEST_HUMMINGBIRD_TOKEN", "token")
+	os.Setenv("MYHUMMINGLIST_MYANIMELIST_DARIN_MINAMOTO_TOKEN", "password")
+	defer os.Unsetenv("TEST_HUMMINGBIRD_TOKEN")
+	defer os.Unsetenv("MYHUMMINGLIST_MYANIMELIST_DARIN_MINAMOTO_TOKEN")
+
+	if credential, err := (EnvCredentials{Variable: "TEST_HUMMINGBIRD_TOKEN"}).Credential("hummingbird", "darin_minamoto"); err != nil ||
+		credential.Secret() != "token" {
+		t.Errorf("TestEnvCredentials failed: %v", err)
+	}
+	if credential, err := (EnvCredentials{}).Credential("myanimelist", "darin_minamoto"); err != nil || credential.Secret() != "password" {
+		t.Errorf("TestEnvCredentials failed: %v", err)
+	}
+	if _, err := (EnvCredentials{}).Credential("hummingbird", "nobody"); err == nil {
+		t.Errorf("TestEnvCredentials failed: expected an error for an unset variable")
+	}
+}
+
+func TestEncryptedFileCredentials(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	passphrase := "correct horse battery staple"
+	provider := EncryptedFileCredentials{Path: path, Passphrase: func() (string, error) { return passphrase, nil }}
+
+	if err := provider.Store("hummingbird", "darin_minamoto", "token"); err != nil {
+		t.Fatalf("TestEncryptedFileCredentials failed: %v", err)
+	}
+	if err := provider.Store("myanimelist", "darin_minamoto", "hunter2"); err != nil {
+		t.Fatalf("TestEncryptedFileCredentials failed: %v", err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("hunter2")) {
+		t.Errorf("TestEncryptedFileCredentials failed: expected the file to be encrypted got %s", data)
+	}
+
+	for service, expected := range map[string]string{"hummingbird": "token", "myanimelist": "hunter2"} {
+		credential, err := provider.Credential(service, "darin_minamoto")
+		if err != nil || credential.Secret() != expected {
+			t.Errorf("TestEncryptedFileCredentials failed: expected the %s credential got %v", service, err)
+		}
+	}
+	if _, err := provider.Credential("hummingbird", "nobody"); err == nil {
+		t.Errorf("TestEncryptedFileCredentials failed: expected an error for a missing credential")
+	}
+
+	passphrase = "wrong"
+	if _, err := provider.Credential("hummingbird", "darin_minamoto"); err == nil || !strings.Contains(err.Error(), "Wrong passphrase") {
+		t.Errorf("TestEncryptedFileCredentials failed: expected a wrong passphrase error got %v", err)
+	}
+}
+
+func TestCommandCredentials(t *testing.T) {
+	// the helper checks its argument and answers with the username it was sent
+	script := `test "$1" = get || exit 1
+while read line && [ -n "$line" ]; do
+	case "$line" in username=*) username="${line#username=}" ;; host=*) host="${line#host=}" ;; esac
+done
+echo "username=$username"
+echo "password=$host-$username"`
+	provider := CommandCredentials{Command: []string{"sh", "-c", script, "helper"}}
+
+	credential, err := provider.Credential("hummingbird", "darin_minamoto")
+	if err != nil || credential.Secret() != "hummingbird.me-darin_minamoto" {
+		t.Errorf("TestCommandCredentials failed: unexpected credential %q %v", credential.Secret(), err)
+	}
+
+	failing := CommandCredentials{Command: []string{"sh", "-c", "echo password=hunter2; exit 1", "helper"}}
+	if _, err := failing.Credential("hummingbird", "darin_minamoto"); err == nil || strings.Contains(err.Error(), "hunter2") {
+		t.Errorf("TestCommandCredentials failed: expected an error without the output got %v", err)
+	}
+	empty := CommandCredentials{Command: []string{"sh", "-c", "echo username=darin_minamoto", "helper"}}
+	if _, err := empty.Credential("hummingbird", "darin_minamoto"); err == nil {
+		t.Errorf("TestCommandCredentials failed: expected an error for a helper without a password")
+	}
+}
+
+func TestPBKDF2SHA256(t *testing.T) {
+	// the PBKDF2-HMAC-SHA256 test vector of RFC 7914
+	key := pbkdf2SHA256([]byte("passwd"), []byte("salt"), 1, 64)
+	expected := "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc" +
+		"49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"
+	if hex.EncodeToString(key) != expected {
+		t.Errorf("TestPBKDF2SHA256 failed: want %s got %x", expected, key)
+	}
+}
+
+func TestHummingbirdAnimeList_CredentialProvider(t *testing.T) {
+	defer withoutListThrottles()()
+
+	fake := NewFakeHummingbird()
+	fake.AddUser("darin_minamoto", "secret_token")
+	fake.SetEntry("darin_minamoto", newSyncTestAnime(1, 101, 3))
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	provider := &countingCredentials{credential: "secret_token"}
+	list := NewHummingbirdAnimeList("darin_minamoto", "", ListOptions{BaseURL: server.URL, Credentials: provider})
+	if err := list.Fetch(); err != nil {
+		t.Fatalf("TestHummingbirdAnimeList_CredentialProvider failed: %v", err)
+	}
+	list.Edit(newSyncTestAnime(1, 101, 4))
+
+	plan, err := list.PlanPush()
+	if err != nil {
+		t.Fatalf("TestHummingbirdAnimeList_CredentialProvider failed: %v", err)
+	}
+	if output := plan.String(); strings.Contains(output, "secret_token") || !strings.Contains(output, "auth_token="+RedactedCredential) {
+		t.Errorf("TestHummingbirdAnimeList_CredentialProvider failed: expected a redacted dry run got %q", output)
+	}
+	if provider.calls != 0 {
+		t.Errorf("TestHummingbirdAnimeList_CredentialProvider failed: expected the credential to not be needed before pushing")
+	}
+
+	if err := list.Push(); err != nil {
+		t.Fatalf("TestHummingbirdAnimeList_CredentialProvider failed: %v", err)
+	}
+	if entry := fake.Library("darin_minamoto")[0]; entry.NumEpisodesWatched != 4 {
+		t.Errorf("TestHummingbirdAnimeList_CredentialProvider failed: expected the change to be pushed got %+v", entry)
+	}
+	list.Edit(newSyncTestAnime(1, 101, 5))
+	if err := list.Push(); err != nil || provider.calls != 1 {
+		t.Errorf("TestHummingbirdAnimeList_CredentialProvider failed: expected the credential to be asked once got %d %v",
+			provider.calls, err)
+	}
+}
+
+func TestRunCLI_StoreCredential(t *testing.T) {
+	defer withoutListThrottles()()
+	os.Setenv("TEST_PASSPHRASE", "passphrase")
+	defer os.Unsetenv("TEST_PASSPHRASE")
+
+	fake := NewFakeMyAnimeList()
+	fake.AddUser("darin_minamoto", "hunter2")
+	fake.AddAnime(newFakeMALTestAnime(101, 0, 0))
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	config := `{
+		"accounts": {"mal": {
+			"service": "myanimelist",
+			"username": "darin_minamoto",
+			"base_url": "` + server.URL + `",
+			"credentials": {"file": "credentials.json", "passphrase_env": "TEST_PASSPHRASE"}
+		}},
+		"primary": "mal"
+	}`
+	if err := ioutil.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	flags := []string{"-state-dir", dir, "-config", path}
+	var stdout, stderr bytes.Buffer
+	if code := runCLI(append(flags, "store-credential"), strings.NewReader("hunter2\n"), &stdout, &stderr); code != 0 {
+		t.Fatalf("TestRunCLI_StoreCredential failed: %s", stderr.String())
+	}
+
+	test := &cliTest{t: t, flags: flags}
+	test.run(0, "add", "101", "-episodes", "2")
+	if output := test.run(0, "push", "-dry-run"); strings.Contains(output, "hunter2") {
+		t.Errorf("TestRunCLI_StoreCredential failed: expected the dry run to not have the password got %q", output)
+	}
+	test.run(0, "push")
+	if library := fake.Library("darin_minamoto"); len(library) != 1 || library[0].MyWatchedEpisodes != 2 {
+		t.Errorf("TestRunCLI_StoreCredential failed: expected the anime to be pushed got %+v", library)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrUnknownStatus is returned for an anime status that isn't one of the statuses of the lists
+var ErrUnknownStatus = errors.New("Unknown anime status")
+
+// ErrUnsupportedList is returned for a list type that isn't Hummingbird or MyAnimeList
+var ErrUnsupportedList = errors.New("Unsupported anime list")
+
+// ErrMissingID is returned for an anime that doesn't have an ID on the list it is sent to
+var ErrMissingID = errors.New("Anime has no ID on the list")
+
+// ErrRemovedRemotely is the error of a change that no longer applies because
+// its anime was removed from the list on the site
+var ErrRemovedRemotely = errors.New("Anime was removed from the list remotely")
+
+// StatusError is an unknown status. It matches ErrUnknownStatus with errors.Is
+type StatusError struct {
+	// Status is the unknown status as it was spelled by the site or the list
+	Status string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("Unknown anime status %s", e.Status)
+}
+
+func (e *StatusError) Is(target error) bool {
+	return target == ErrUnknownStatus
+}
+
+// ListTypeError is an unsupported list type. It matches ErrUnsupportedList with errors.Is
+type ListTypeError struct {
+	ListType int
+}
+
+func (e *ListTypeError) Error() string {
+	return fmt.Sprintf("Unsupported anime list type %d", e.ListType)
+}
+
+func (e *ListTypeError) Is(target error) bool {
+	return target == ErrUnsupportedList
+}
+
+// EntryError is a library entry that Fetch left out of a list because it couldn't be used
+type EntryError struct {
+	ListType int
+	// ID is the ID of the anime on the list, 0 if it couldn't be read
+	ID    int
+	Title string
+	Err   error
+}
+
+func (e *EntryError) Error() string {
+	return fmt.Sprintf("Skipped %s entry %d %q: %v", ListTypeName(e.ListType), e.ID, e.Title, e.Err)
+}
+
+func (e *EntryError) Unwrap() error {
+	return e.Err
+}
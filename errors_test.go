@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// badStatusAnime is a Hummingbird anime with a status that none of the lists know
+var badStatusAnime = HummingbirdAnime{AnimeStatus: "rewatching", Data: HummingbirdAnimeData{Id: 2, MalID: 102, Title: "Sample text"}}
+
+var typedErrorTests = []struct {
+	name     string
+	err      func() error
+	expected error
+}{
+	{"ParseHummingbirdStatus", func() error { _, err := ParseHummingbirdStatus("rewatching"); return err }, ErrUnknownStatus},
+	{"StatusToHummingbirdString", func() error { _, err := StatusToHummingbirdString(42); return err }, ErrUnknownStatus},
+	{"ParseMALStatus", func() error { _, err := ParseMALStatus(42); return err }, ErrUnknownStatus},
+	{"StatusToMALStatus", func() error { _, err := StatusToMALStatus(StatusUnknown); return err }, ErrUnknownStatus},
+	{"Lookup", func() error { _, err := (AnimeID{Hummingbird: 1}).Lookup(42); return err }, ErrUnsupportedList},
+	{"Lookup", func() error { _, err := (AnimeID{Hummingbird: 1}).Lookup(MyAnimeList); return err }, ErrMissingID},
+	{"URL", func() error { _, err := (DeleteChange{newSyncTestAnime(1, 101, 3)}).URL(42); return err }, ErrUnsupportedList},
+	{"URL", func() error { _, err := (AddChange{HummingbirdAnime{}}).URL(Hummingbird); return err }, ErrMissingID},
+	{"FillForm", func() error { return (AddChange{badStatusAnime}).FillForm(MyAnimeList, &url.Values{}) }, ErrUnknownStatus},
+	{"FillForm", func() error { return (AddChange{newSyncTestAnime(1, 101, 3)}).FillForm(42, &url.Values{}) }, ErrUnsupportedList},
+	{"ConvertAnime", func() error { _, err := ConvertAnime(newSyncTestAnime(1, 101, 3), 42); return err }, ErrUnsupportedList},
+}
+
+func TestTypedErrors(t *testing.T) {
+	for _, test := range typedErrorTests {
+		if err := test.err(); !errors.Is(err, test.expected) {
+			t.Errorf("TestTypedErrors failed: %s: want %v got %v", test.name, test.expected, err)
+		}
+	}
+}
+
+func TestHummingbirdAnimeList_FetchQuarantine(t *testing.T) {
+	defer withoutListThrottles()()
+
+	fake := NewFakeHummingbird()
+	fake.AddUser("darin_minamoto", "token")
+	fake.SetEntry("darin_minamoto", newSyncTestAnime(1, 101, 3))
+	fake.SetEntry("darin_minamoto", badStatusAnime)
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	list := NewHummingbirdAnimeList("darin_minamoto", "token", ListOptions{BaseURL: server.URL})
+	if err := list.Fetch(); err != nil {
+		t.Fatalf("TestHummingbirdAnimeList_FetchQuarantine failed: %v", err)
+	}
+	if len(list.Anime()) != 1 || !list.Contains(1) {
+		t.Errorf("TestHummingbirdAnimeList_FetchQuarantine failed: expected only the good entry got %+v", list.Anime())
+	}
+	quarantined := list.Quarantined()
+	if len(quarantined) != 1 || quarantined[0].ID != 2 || !errors.Is(quarantined[0], ErrUnknownStatus) {
+		t.Errorf("TestHummingbirdAnimeList_FetchQuarantine failed: expected the bad entry to be quarantined got %v", quarantined)
+	}
+}
+
+func TestMyAnimeListAnimeList_FetchQuarantine(t *testing.T) {
+	defer withoutListThrottles()()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<myanimelist>
+			<anime><series_animedb_id>101</series_animedb_id><my_status>1</my_status></anime>
+			<anime><series_animedb_id>102</series_animedb_id><my_status>5</my_status></anime>
+			<anime><series_animedb_id>abc</series_animedb_id><my_status>1</my_status></anime>
+			<anime><series_title>No ID</series_title><my_status>1</my_status></anime>
+		</myanimelist>`)
+	}))
+	defer server.Close()
+
+	list := NewMyAnimeListAnimeList("darin_minamoto", "hunter2", ListOptions{BaseURL: server.URL})
+	if err := list.Fetch(); err != nil {
+		t.Fatalf("TestMyAnimeListAnimeList_FetchQuarantine failed: %v", err)
+	}
+	if len(list.Anime()) != 1 || !list.Contains(101) {
+		t.Errorf("TestMyAnimeListAnimeList_FetchQuarantine failed: expected only the good entry got %+v", list.Anime())
+	}
+	quarantined := list.Quarantined()
+	if len(quarantined) != 3 || !errors.Is(quarantined[0], ErrUnknownStatus) || !errors.Is(quarantined[2], ErrMissingID) {
+		t.Errorf("TestMyAnimeListAnimeList_FetchQuarantine failed: expected the bad entries to be quarantined got %v", quarantined)
+	}
+}
+
+func TestHummingbirdAnimeList_PushBadChange(t *testing.T) {
+	defer withoutListThrottles()()
+
+	fake := NewFakeHummingbird()
+	fake.AddUser("darin_minamoto", "token")
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	list := NewHummingbirdAnimeList("darin_minamoto", "token", ListOptions{BaseURL: server.URL})
+	list.Add(newSyncTestAnime(1, 101, 3))
+	list.Add(badStatusAnime)
+
+	if _, err := list.PlanPush(); !errors.Is(err, ErrUnknownStatus) {
+		t.Errorf("TestHummingbirdAnimeList_PushBadChange failed: expected the plan to fail got %v", err)
+	}
+	var pushErr *PushError
+	if err := list.Push(); !errors.As(err, &pushErr) || pushErr.Total != 2 || len(pushErr.Failures) != 1 ||
+		!errors.Is(pushErr.Failures[0].Err, ErrUnknownStatus) {
+		t.Fatalf("TestHummingbirdAnimeList_PushBadChange failed: expected one failed change got %v", err)
+	}
+	if library := fake.Library("darin_minamoto"); len(library) != 1 || library[0].Data.Id != 1 {
+		t.Errorf("TestHummingbirdAnimeList_PushBadChange failed: expected the good change to be pushed got %+v", library)
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FakeHummingbird is an in-memory stand-in for the Hummingbird v1 API endpoints that
+// HummingbirdAnimeList uses. It is a http.Handler, so it can be served by httptest.NewServer
+// and used as the base URL of a list
+type FakeHummingbird struct {
+	fakeServer
+
+	// catalog is the data of the anime that can be added to libraries
+	catalog map[int]HummingbirdAnimeData
+	// libraries are the library entries of each user by anime ID
+	libraries map[string]map[int]HummingbirdAnime
+	// tokens are the users of the auth tokens
+	tokens map[string]string
+}
+
+func NewFakeHummingbird() *FakeHummingbird {
+	return &FakeHummingbird{
+		catalog:   make(map[int]HummingbirdAnimeData),
+		libraries: make(map[string]map[int]HummingbirdAnime),
+		tokens:    make(map[string]string),
+	}
+}
+
+// AddUser adds a user with an empty library that changes it with the auth token
+func (fh *FakeHummingbird) AddUser(username string, authToken string) {
+	fh.mutex.Lock()
+	defer fh.mutex.Unlock()
+	if _, ok := fh.libraries[username]; !ok {
+		fh.libraries[username] = make(map[int]HummingbirdAnime)
+	}
+	fh.tokens[authToken] = username
+}
+
+// AddAnime adds the data of an anime so that library entries added for it have a title and MAL ID
+func (fh *FakeHummingbird) AddAnime(data HummingbirdAnimeData) {
+	fh.mutex.Lock()
+	defer fh.mutex.Unlock()
+	fh.catalog[data.Id] = data
+}
+
+// SetEntry sets a library entry of a user without a request
+func (fh *FakeHummingbird) SetEntry(username string, anime HummingbirdAnime) {
+	fh.mutex.Lock()
+	defer fh.mutex.Unlock()
+	if _, ok := fh.libraries[username]; !ok {
+		fh.libraries[username] = make(map[int]HummingbirdAnime)
+	}
+	fh.libraries[username][anime.Data.Id] = anime
+}
+
+// Library returns the library entries of a user ordered by anime ID
+func (fh *FakeHummingbird) Library(username string) []HummingbirdAnime {
+	fh.mutex.Lock()
+	defer fh.mutex.Unlock()
+	return fh.library(username)
+}
+
+func (fh *FakeHummingbird) library(username string) []HummingbirdAnime {
+	library := fh.libraries[username]
+	ids := make([]int, 0, len(library))
+	for id := range library {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	entries := make([]HummingbirdAnime, len(ids))
+	for i, id := range ids {
+		entries[i] = library[id]
+	}
+	return entries
+}
+
+func (fh *FakeHummingbird) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if fh.intercept(w, r) {
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/api/v1")
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	switch {
+	case r.Method == "GET" && len(parts) == 3 && parts[0] == "users" && parts[2] == "library":
+		fh.serveLibrary(w, parts[1])
+	case r.Method == "POST" && len(parts) == 2 && parts[0] == "libraries":
+		fh.serveUpdate(w, r, parts[1])
+	case r.Method == "POST" && len(parts) == 3 && parts[0] == "libraries" && parts[2] == "remove":
+		fh.serveRemove(w, r, parts[1])
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serveLibrary responds with the library entries of the user
+func (fh *FakeHummingbird) serveLibrary(w http.ResponseWriter, username string) {
+	fh.mutex.Lock()
+	_, ok := fh.libraries[username]
+	entries := fh.library(username)
+	fh.mutex.Unlock()
+
+	if !ok {
+		http.Error(w, `{"error":"Not Found"}`, http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// authenticate returns the user of the auth token in the form of the request
+func (fh *FakeHummingbird) authenticate(w http.ResponseWriter, r *http.Request) (string, bool) {
+	r.ParseForm()
+	username, ok := fh.tokens[r.PostForm.Get("auth_token")]
+	if !ok {
+		http.Error(w, `{"error":"Invalid authentication token"}`, http.StatusUnauthorized)
+	}
+	return username, ok
+}
+
+// serveUpdate adds or changes the library entry of the anime with the fields in the form
+func (fh *FakeHummingbird) serveUpdate(w http.ResponseWriter, r *http.Request, rawID string) {
+	id, err := strconv.Atoi(rawID)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	fh.mutex.Lock()
+	defer fh.mutex.Unlock()
+	username, ok := fh.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	entry, ok := fh.libraries[username][id]
+	if !ok {
+		entry = HummingbirdAnime{AnimeStatus: "currently-watching", Data: fh.catalog[id]}
+		entry.Data.Id = id
+	}
+	if status := r.PostForm.Get("status"); status != "" {
+		if _, err := ParseHummingbirdStatus(status); err != nil {
+			http.Error(w, `{"error":"Invalid status"}`, http.StatusBadRequest)
+			return
+		}
+		entry.AnimeStatus = status
+	}
+	if episodes := r.PostForm.Get("episodes_watched"); episodes != "" {
+		entry.NumEpisodesWatched, _ = strconv.Atoi(episodes)
+	}
+	if rewatchedTimes := r.PostForm.Get("rewatched_times"); rewatchedTimes != "" {
+		entry.NumRewatchedTimes, _ = strconv.Atoi(rewatchedTimes)
+	}
+	if rewatching := r.PostForm.Get("rewatching"); rewatching != "" {
+		entry.IsRewatching = rewatching == "true"
+	}
+	if rating, ok := r.PostForm["sane_rating_update"]; ok {
+		entry.Rating = HummingbirdRating{}
+		if stars, err := strconv.ParseFloat(rating[0], 64); err == nil && stars > 0 {
+			entry.Rating = HummingbirdRatingFromScore(int(math.Round(stars * 2)))
+		}
+	}
+	if notes, ok := r.PostForm["notes"]; ok {
+		entry.EntryNotes = notes[0]
+	}
+	if privacy := r.PostForm.Get("privacy"); privacy != "" {
+		entry.IsPrivate = privacy == "private"
+	}
+	entry.LastUpdated = time.Now().UTC().Truncate(time.Second)
+	fh.libraries[username][id] = entry
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entry)
+}
+
+// serveRemove removes the library entry of the anime
+func (fh *FakeHummingbird) serveRemove(w http.ResponseWriter, r *http.Request, rawID string) {
+	id, err := strconv.Atoi(rawID)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	fh.mutex.Lock()
+	defer fh.mutex.Unlock()
+	username, ok := fh.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	delete(fh.libraries[username], id)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte("true"))
+}
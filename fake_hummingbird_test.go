@@ -0,0 +1,241 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newFakeHummingbirdTest returns a fake Hummingbird with the user darin_minamoto, a server
+// serving it and a list for the user that has fetched the library from the server
+func newFakeHummingbirdTest(t *testing.T, entries ...HummingbirdAnime) (*FakeHummingbird, *httptest.Server, *HummingbirdAnimeList) {
+	fake := NewFakeHummingbird()
+	fake.AddUser("darin_minamoto", "token")
+	for _, entry := range entries {
+		fake.AddAnime(entry.Data)
+		fake.SetEntry("darin_minamoto", entry)
+	}
+	server := httptest.NewServer(fake)
+
+	list := NewHummingbirdAnimeList("darin_minamoto", "token", ListOptions{
+		BaseURL: server.URL,
+		Retry:   &RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond},
+	})
+	if err := list.Fetch(); err != nil {
+		server.Close()
+		t.Fatalf("fetching from the fake Hummingbird failed: %v", err)
+	}
+	return fake, server, list
+}
+
+// checkFakeHummingbirdLibrary checks that the fake's library has the same entries as the list
+func checkFakeHummingbirdLibrary(t *testing.T, testName string, fake *FakeHummingbird, list *HummingbirdAnimeList) {
+	library := fake.Library("darin_minamoto")
+	anime := list.Anime()
+	if len(library) != len(anime) {
+		t.Fatalf("%s failed: expected %d entries in the fake library got %+v", testName, len(anime), library)
+	}
+	for i := range anime {
+		if !SameAnime(library[i], anime[i]) {
+			t.Errorf("%s failed: want %+v got %+v", testName, anime[i], library[i])
+		}
+	}
+}
+
+func TestFakeHummingbird_Fetch(t *testing.T) {
+	defer withoutListThrottles()()
+
+	fake, server, list := newFakeHummingbirdTest(t, newSyncTestAnime(1, 101, 3), newSyncTestAnime(2, 102, 5))
+	defer server.Close()
+
+	checkFakeHummingbirdLibrary(t, "TestFakeHummingbird_Fetch", fake, list)
+
+	unknown := NewHummingbirdAnimeList("nobody", "", ListOptions{BaseURL: server.URL})
+	if err := unknown.Fetch(); err == nil {
+		t.Errorf("TestFakeHummingbird_Fetch failed: expected an error fetching an unknown user")
+	}
+}
+
+func TestFakeHummingbird_PushAndUndo(t *testing.T) {
+	defer withoutListThrottles()()
+
+	fake, server, list := newFakeHummingbirdTest(t, newSyncTestAnime(1, 101, 3), newSyncTestAnime(2, 102, 5))
+	defer server.Close()
+	fake.AddAnime(HummingbirdAnimeData{Id: 3, MalID: 103, Title: "Sample text"})
+
+	list.Add(newSyncTestAnime(3, 103, 1))
+	list.Edit(newSyncTestAnime(1, 101, 4))
+	list.Remove(newSyncTestAnime(2, 102, 5))
+	if err := list.Push(); err != nil {
+		t.Fatalf("TestFakeHummingbird_PushAndUndo failed: %v", err)
+	}
+	checkFakeHummingbirdLibrary(t, "TestFakeHummingbird_PushAndUndo", fake, list)
+
+	if err := list.Undo(); err != nil {
+		t.Fatalf("TestFakeHummingbird_PushAndUndo failed: %v", err)
+	}
+	if len(list.Anime()) != 2 || !list.Contains(2) || list.Contains(3) {
+		t.Errorf("TestFakeHummingbird_PushAndUndo failed: expected the push to be undone got %+v", list.Anime())
+	}
+	checkFakeHummingbirdLibrary(t, "TestFakeHummingbird_PushAndUndo", fake, list)
+}
+
+func TestFakeHummingbird_FetchRebase(t *testing.T) {
+	defer withoutListThrottles()()
+
+	fake, server, list := newFakeHummingbirdTest(t, newSyncTestAnime(1, 101, 3), newSyncTestAnime(2, 102, 3),
+		newSyncTestAnime(3, 103, 3))
+	defer server.Close()
+	fake.AddAnime(HummingbirdAnimeData{Id: 4, MalID: 104, Title: "Sample text"})
+
+	list.Edit(newSyncTestAnime(1, 101, 5))
+	list.Edit(newSyncTestAnime(2, 102, 6))
+	list.Remove(newSyncTestAnime(3, 103, 3))
+	list.Add(newSyncTestAnime(4, 104, 1))
+
+	// another client changes the library before the list is pushed
+	other := NewHummingbirdAnimeList("darin_minamoto", "token", ListOptions{BaseURL: server.URL})
+	if err := other.Fetch(); err != nil {
+		t.Fatalf("TestFakeHummingbird_FetchRebase failed: %v", err)
+	}
+	other.Edit(newSyncTestAnime(1, 101, 4))
+	other.Remove(newSyncTestAnime(2, 102, 3))
+	other.Remove(newSyncTestAnime(3, 103, 3))
+	other.Add(newSyncTestAnime(4, 104, 1))
+	if err := other.Push(); err != nil {
+		t.Fatalf("TestFakeHummingbird_FetchRebase failed: %v", err)
+	}
+
+	if err := list.Fetch(); err != nil {
+		t.Fatalf("TestFakeHummingbird_FetchRebase failed: %v", err)
+	}
+	// only the edit of anime 1 still has to be pushed, on top of the remote edit
+	expectedChanges := []Change{EditChange{OldAnime: fake.Library("darin_minamoto")[0], NewAnime: newSyncTestAnime(1, 101, 5)}}
+	if !reflect.DeepEqual(list.changes, expectedChanges) {
+		t.Errorf("TestFakeHummingbird_FetchRebase failed: want %+v got %+v", expectedChanges, list.changes)
+	}
+	if len(list.History()) != 1 {
+		t.Errorf("TestFakeHummingbird_FetchRebase failed: expected the history to have the rebased change got %+v", list.History())
+	}
+	stale := list.StaleChanges()
+	if len(stale) != 1 || stale[0].ID != 2 || !errors.Is(stale[0].Err, ErrRemovedRemotely) {
+		t.Errorf("TestFakeHummingbird_FetchRebase failed: expected the edit of anime 2 to be stale got %+v", stale)
+	}
+	if len(list.Anime()) != 2 || list.Contains(2) || list.Contains(3) {
+		t.Errorf("TestFakeHummingbird_FetchRebase failed: unexpected anime %+v", list.Anime())
+	}
+	if anime, _ := list.Get(1); anime.EpisodesWatched() != 5 {
+		t.Errorf("TestFakeHummingbird_FetchRebase failed: expected the local edit to be kept got %+v", anime)
+	}
+
+	if err := list.Push(); err != nil {
+		t.Fatalf("TestFakeHummingbird_FetchRebase failed: %v", err)
+	}
+	checkFakeHummingbirdLibrary(t, "TestFakeHummingbird_FetchRebase", fake, list)
+	if err := list.Fetch(); err != nil || len(list.changes) != 0 {
+		t.Errorf("TestFakeHummingbird_FetchRebase failed: expected no changes after fetching again got %+v %v", list.changes, err)
+	}
+}
+
+func TestFakeHummingbird_InvalidAuthToken(t *testing.T) {
+	defer withoutListThrottles()()
+
+	_, server, _ := newFakeHummingbirdTest(t)
+	defer server.Close()
+
+	list := NewHummingbirdAnimeList("darin_minamoto", "wrong", ListOptions{BaseURL: server.URL})
+	list.Add(newSyncTestAnime(1, 101, 3))
+	err := list.Push()
+	if pushErr, ok := err.(*PushError); !ok || !strings.Contains(pushErr.Error(), "401") {
+		t.Errorf("TestFakeHummingbird_InvalidAuthToken failed: expected a 401 push error got %v", err)
+	}
+	if len(list.changes) != 1 {
+		t.Errorf("TestFakeHummingbird_InvalidAuthToken failed: expected the change to stay queued")
+	}
+}
+
+func TestFakeHummingbird_Faults(t *testing.T) {
+	defer withoutListThrottles()()
+
+	fake, server, list := newFakeHummingbirdTest(t, newSyncTestAnime(1, 101, 3), newSyncTestAnime(2, 102, 5))
+	defer server.Close()
+
+	// a 429 for anime 1 is retried after the Retry-After delay
+	fake.InjectFault(FakeFault{
+		Method:     "POST",
+		PathPrefix: "/api/v1/libraries/1",
+		StatusCode: http.StatusTooManyRequests,
+		RetryAfter: "0",
+		Times:      1,
+	})
+	// every request for anime 2 fails
+	fake.InjectFault(FakeFault{PathPrefix: "/api/v1/libraries/2", StatusCode: http.StatusInternalServerError})
+	fake.SetLatency(5 * time.Millisecond)
+
+	list.Edit(newSyncTestAnime(1, 101, 4))
+	list.Edit(newSyncTestAnime(2, 102, 6))
+	err := list.Push()
+	pushErr, ok := err.(*PushError)
+	if !ok || len(pushErr.Failures) != 1 || pushErr.Failures[0].ID != 2 {
+		t.Fatalf("TestFakeHummingbird_Faults failed: expected anime 2 to fail got %v", err)
+	}
+	if entry := fake.Library("darin_minamoto")[0]; entry.NumEpisodesWatched != 4 {
+		t.Errorf("TestFakeHummingbird_Faults failed: expected anime 1 to be pushed got %+v", entry)
+	}
+
+	// anime 1 was sent twice because of the 429 and anime 2 three times because of the retries
+	counts := make(map[string]int)
+	for _, request := range fake.Requests() {
+		counts[request.Path]++
+	}
+	if counts["/api/v1/libraries/1"] != 2 || counts["/api/v1/libraries/2"] != 3 {
+		t.Errorf("TestFakeHummingbird_Faults failed: unexpected requests %v", counts)
+	}
+
+	fake.ClearFaults()
+	if err := list.Push(); err != nil {
+		t.Fatalf("TestFakeHummingbird_Faults failed: %v", err)
+	}
+	checkFakeHummingbirdLibrary(t, "TestFakeHummingbird_Faults", fake, list)
+}
+
+func TestFakeHummingbird_Sync(t *testing.T) {
+	defer withoutListThrottles()()
+
+	fake := NewFakeHummingbird()
+	fake.AddUser("darin_minamoto", "token")
+	fake.AddUser("backup", "backup_token")
+	fake.SetEntry("darin_minamoto", newSyncTestAnime(1, 101, 3))
+	fake.SetEntry("backup", newSyncTestAnime(2, 102, 5))
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	primary := NewHummingbirdAnimeList("darin_minamoto", "token", ListOptions{BaseURL: server.URL})
+	replica := NewHummingbirdAnimeList("backup", "backup_token", ListOptions{BaseURL: server.URL})
+	manager := NewAnimelistManager(primary, replica)
+	if err := manager.Fetch(); err != nil {
+		t.Fatalf("TestFakeHummingbird_Sync failed: %v", err)
+	}
+
+	if conflicts, err := manager.Sync(); err != nil || len(conflicts) != 0 {
+		t.Fatalf("TestFakeHummingbird_Sync failed: %v %+v", err, conflicts)
+	}
+	if err := manager.Push(); err != nil {
+		t.Fatalf("TestFakeHummingbird_Sync failed: %v", err)
+	}
+
+	primaryLibrary, replicaLibrary := fake.Library("darin_minamoto"), fake.Library("backup")
+	if len(primaryLibrary) != 2 || len(replicaLibrary) != 2 {
+		t.Fatalf("TestFakeHummingbird_Sync failed: expected both libraries to have both anime got %+v %+v",
+			primaryLibrary, replicaLibrary)
+	}
+	for i := range primaryLibrary {
+		if !SameAnime(primaryLibrary[i], replicaLibrary[i]) {
+			t.Errorf("TestFakeHummingbird_Sync failed: want %+v got %+v", primaryLibrary[i], replicaLibrary[i])
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FakeMyAnimeList is an in-memory stand-in for the MyAnimeList library feed and the
+// add, update and delete endpoints that MyAnimeListAnimeList uses. It is a http.Handler,
+// so it can be served by httptest.NewServer and used as the base URL of a list
+type FakeMyAnimeList struct {
+	fakeServer
+
+	// catalog is the data of the anime that can be added to libraries
+	catalog map[int]MALAnime
+	// libraries are the library entries of each user by anime ID
+	libraries map[string]map[int]MALAnime
+	// passwords are the passwords of the users
+	passwords map[string]string
+}
+
+// fakeMALLibrary is the XML library feed served by FakeMyAnimeList
+type fakeMALLibrary struct {
+	XMLName xml.Name   `xml:"myanimelist"`
+	Error   string     `xml:"error,omitempty"`
+	Anime   []MALAnime `xml:"anime"`
+}
+
+func NewFakeMyAnimeList() *FakeMyAnimeList {
+	return &FakeMyAnimeList{
+		catalog:   make(map[int]MALAnime),
+		libraries: make(map[string]map[int]MALAnime),
+		passwords: make(map[string]string),
+	}
+}
+
+// AddUser adds a user with an empty library that logs in with the password
+func (fm *FakeMyAnimeList) AddUser(username string, password string) {
+	fm.mutex.Lock()
+	defer fm.mutex.Unlock()
+	if _, ok := fm.libraries[username]; !ok {
+		fm.libraries[username] = make(map[int]MALAnime)
+	}
+	fm.passwords[username] = password
+}
+
+// AddAnime adds the series data of an anime so that library entries added for it have a title
+// and episode count. Only the series fields of the anime are used
+func (fm *FakeMyAnimeList) AddAnime(anime MALAnime) {
+	fm.mutex.Lock()
+	defer fm.mutex.Unlock()
+	fm.catalog[anime.SeriesAnimeDBID] = MALAnime{
+		SeriesAnimeDBID: anime.SeriesAnimeDBID,
+		SeriesTitle:     anime.SeriesTitle,
+		SeriesEpisodes:  anime.SeriesEpisodes,
+	}
+}
+
+// SetEntry sets a library entry of a user without a request
+func (fm *FakeMyAnimeList) SetEntry(username string, anime MALAnime) {
+	fm.mutex.Lock()
+	defer fm.mutex.Unlock()
+	if _, ok := fm.libraries[username]; !ok {
+		fm.libraries[username] = make(map[int]MALAnime)
+	}
+	// MAL doesn't know about Hummingbird IDs
+	anime.HummingbirdID = 0
+	fm.libraries[username][anime.SeriesAnimeDBID] = anime
+}
+
+// Library returns the library entries of a user ordered by anime ID
+func (fm *FakeMyAnimeList) Library(username string) []MALAnime {
+	fm.mutex.Lock()
+	defer fm.mutex.Unlock()
+	return fm.library(username)
+}
+
+func (fm *FakeMyAnimeList) library(username string) []MALAnime {
+	library := fm.libraries[username]
+	ids := make([]int, 0, len(library))
+	for id := range library {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	entries := make([]MALAnime, len(ids))
+	for i, id := range ids {
+		entries[i] = library[id]
+	}
+	return entries
+}
+
+func (fm *FakeMyAnimeList) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if fm.intercept(w, r) {
+		return
+	}
+
+	if r.Method == "GET" && r.URL.Path == "/malappinfo.php" {
+		fm.serveLibrary(w, r.URL.Query().Get("u"))
+		return
+	}
+
+	// the endpoints that change a library are /api/animelist/{add,update,delete}/:id.xml
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if r.Method != "POST" || len(parts) != 4 || parts[0] != "api" || parts[1] != "animelist" ||
+		!strings.HasSuffix(parts[3], ".xml") {
+		http.NotFound(w, r)
+		return
+	}
+	id, err := strconv.Atoi(strings.TrimSuffix(parts[3], ".xml"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	fm.mutex.Lock()
+	defer fm.mutex.Unlock()
+	username, password, ok := r.BasicAuth()
+	if !ok || fm.passwords[username] != password || fm.libraries[username] == nil {
+		w.Header().Set("WWW-Authenticate", `Basic realm="myanimelist.net"`)
+		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		return
+	}
+
+	library := fm.libraries[username]
+	_, inLibrary := library[id]
+	switch parts[2] {
+	case "add":
+		if inLibrary {
+			http.Error(w, "The anime (id: "+strconv.Itoa(id)+") is already in the list.", http.StatusBadRequest)
+			return
+		}
+		entry := fm.catalog[id]
+		entry.SeriesAnimeDBID = id
+		entry.MyStatus = MALStatusWatching
+		if !fm.updateEntry(w, r, &entry) {
+			return
+		}
+		library[id] = entry
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("Created"))
+	case "update":
+		if !inLibrary {
+			http.Error(w, "The anime (id: "+strconv.Itoa(id)+") is not in the list.", http.StatusBadRequest)
+			return
+		}
+		entry := library[id]
+		if !fm.updateEntry(w, r, &entry) {
+			return
+		}
+		library[id] = entry
+		w.Write([]byte("Updated"))
+	case "delete":
+		delete(library, id)
+		w.Write([]byte("Deleted"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serveLibrary responds with the library feed of the user.
+// Like MAL, an unknown user gets an error inside the feed instead of an error status
+func (fm *FakeMyAnimeList) serveLibrary(w http.ResponseWriter, username string) {
+	fm.mutex.Lock()
+	library := fakeMALLibrary{Anime: fm.library(username)}
+	if _, ok := fm.libraries[username]; !ok {
+		library.Error = "Invalid username"
+	}
+	fm.mutex.Unlock()
+
+	data, err := xml.Marshal(library)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/xml; charset=UTF-8")
+	w.Write([]byte(xml.Header))
+	w.Write(data)
+}
+
+// fakeMALDate converts a MMDDYYYY date of an entry to the date of a library feed
+func fakeMALDate(date string) (string, bool) {
+	if date == "00000000" {
+		return "0000-00-00", true
+	}
+	parsed, err := time.Parse("01022006", date)
+	if err != nil {
+		return "", false
+	}
+	return formatMALDate(parsed), true
+}
+
+// updateEntry changes the entry with the fields of the XML entry in the data field of the form.
+// It responds with an error and returns false if the data isn't valid
+func (fm *FakeMyAnimeList) updateEntry(w http.ResponseWriter, r *http.Request, entry *MALAnime) bool {
+	var data MALEntry
+	if err := xml.Unmarshal([]byte(r.PostFormValue("data")), &data); err != nil {
+		http.Error(w, "Invalid XML data", http.StatusBadRequest)
+		return false
+	}
+
+	if data.Status != nil {
+		switch *data.Status {
+		case MALStatusWatching, MALStatusCompleted, MALStatusOnHold, MALStatusDropped, MALStatusPlanToWatch:
+			entry.MyStatus = *data.Status
+		default:
+			http.Error(w, "Invalid status", http.StatusBadRequest)
+			return false
+		}
+	}
+	if data.Episode != nil {
+		entry.MyWatchedEpisodes = *data.Episode
+	}
+	if data.EnableRewatching != nil {
+		entry.MyRewatching = *data.EnableRewatching
+	}
+	if data.TimesRewatched != nil {
+		entry.MyTimesRewatched = *data.TimesRewatched
+	}
+	if data.Score != nil {
+		if *data.Score < 0 || *data.Score > 10 {
+			http.Error(w, "Invalid score", http.StatusBadRequest)
+			return false
+		}
+		entry.MyScore = *data.Score
+	}
+	if data.DateStart != nil {
+		date, ok := fakeMALDate(*data.DateStart)
+		if !ok {
+			http.Error(w, "Invalid start date", http.StatusBadRequest)
+			return false
+		}
+		entry.MyStartDate = date
+	}
+	if data.DateFinish != nil {
+		date, ok := fakeMALDate(*data.DateFinish)
+		if !ok {
+			http.Error(w, "Invalid finish date", http.StatusBadRequest)
+			return false
+		}
+		entry.MyFinishDate = date
+	}
+	if data.RewatchEpisode != nil {
+		entry.MyRewatchingEp = *data.RewatchEpisode
+	}
+	if data.Comments != nil {
+		entry.MyComments = *data.Comments
+	}
+	entry.MyLastUpdated = time.Now().Unix()
+	return true
+}
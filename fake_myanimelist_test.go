@@ -0,0 +1,224 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newFakeMALTestAnime returns a MAL anime that is in the catalog of the fake MAL
+func newFakeMALTestAnime(id int, status int, episodes int) MALAnime {
+	return MALAnime{
+		SeriesAnimeDBID:   id,
+		SeriesTitle:       "Sample text",
+		SeriesEpisodes:    24,
+		MyStatus:          status,
+		MyWatchedEpisodes: episodes,
+	}
+}
+
+func TestFakeMyAnimeList_FetchAndPush(t *testing.T) {
+	defer withoutListThrottles()()
+
+	fake := NewFakeMyAnimeList()
+	fake.AddUser("darin_minamoto", "password")
+	fake.AddAnime(newFakeMALTestAnime(103, 0, 0))
+	fake.SetEntry("darin_minamoto", newFakeMALTestAnime(101, MALStatusWatching, 3))
+	fake.SetEntry("darin_minamoto", newFakeMALTestAnime(102, MALStatusCompleted, 24))
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	list := NewMyAnimeListAnimeList("darin_minamoto", "password", ListOptions{BaseURL: server.URL})
+	if err := list.Fetch(); err != nil {
+		t.Fatalf("TestFakeMyAnimeList_FetchAndPush failed: %v", err)
+	}
+	if len(list.Anime()) != 2 {
+		t.Fatalf("TestFakeMyAnimeList_FetchAndPush failed: expected 2 anime got %+v", list.Anime())
+	}
+
+	list.Add(newFakeMALTestAnime(103, MALStatusPlanToWatch, 0))
+	list.Edit(newFakeMALTestAnime(101, MALStatusOnHold, 4))
+	list.Remove(newFakeMALTestAnime(102, MALStatusCompleted, 24))
+	if err := list.Push(); err != nil {
+		t.Fatalf("TestFakeMyAnimeList_FetchAndPush failed: %v", err)
+	}
+
+	library := fake.Library("darin_minamoto")
+	if len(library) != 2 || library[0].SeriesAnimeDBID != 101 || library[1].SeriesAnimeDBID != 103 {
+		t.Fatalf("TestFakeMyAnimeList_FetchAndPush failed: unexpected library %+v", library)
+	}
+	if library[0].MyStatus != MALStatusOnHold || library[0].MyWatchedEpisodes != 4 {
+		t.Errorf("TestFakeMyAnimeList_FetchAndPush failed: expected anime 101 to be edited got %+v", library[0])
+	}
+	if library[1].MyStatus != MALStatusPlanToWatch || library[1].SeriesTitle != "Sample text" {
+		t.Errorf("TestFakeMyAnimeList_FetchAndPush failed: expected anime 103 to be added got %+v", library[1])
+	}
+
+	// adding an anime that is already in the list fails
+	list.Add(newFakeMALTestAnime(101, MALStatusWatching, 1))
+	if err := list.Push(); err == nil || !strings.Contains(err.Error(), "400") {
+		t.Errorf("TestFakeMyAnimeList_FetchAndPush failed: expected a 400 push error got %v", err)
+	}
+}
+
+func TestFakeMyAnimeList_Errors(t *testing.T) {
+	defer withoutListThrottles()()
+
+	fake := NewFakeMyAnimeList()
+	fake.AddUser("darin_minamoto", "password")
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	list := NewMyAnimeListAnimeList("darin_minamoto", "wrong", ListOptions{BaseURL: server.URL})
+	list.Add(newFakeMALTestAnime(101, MALStatusWatching, 3))
+	if err := list.Push(); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("TestFakeMyAnimeList_Errors failed: expected a 401 push error got %v", err)
+	}
+
+	unknown := NewMyAnimeListAnimeList("nobody", "", ListOptions{BaseURL: server.URL})
+	if err := unknown.Fetch(); err == nil || err.Error() != "Invalid username" {
+		t.Errorf("TestFakeMyAnimeList_Errors failed: expected an Invalid username error got %v", err)
+	}
+}
+
+func TestFakeMyAnimeList_SyncWithHummingbird(t *testing.T) {
+	defer withoutListThrottles()()
+
+	hummingbird := NewFakeHummingbird()
+	hummingbird.AddUser("darin_minamoto", "token")
+	myAnimeList := NewFakeMyAnimeList()
+	myAnimeList.AddUser("darin_minamoto", "password")
+
+	statuses := []string{"currently-watching", "completed", "on-hold", "dropped", "plan-to-watch"}
+	for i, status := range statuses {
+		entry := newSyncTestAnime(i+1, 101+i, i*3)
+		entry.AnimeStatus = status
+		entry.NumRewatchedTimes = i % 2
+		hummingbird.AddAnime(entry.Data)
+		hummingbird.SetEntry("darin_minamoto", entry)
+		myAnimeList.AddAnime(newFakeMALTestAnime(101+i, 0, 0))
+	}
+
+	hummingbirdServer := httptest.NewServer(hummingbird)
+	defer hummingbirdServer.Close()
+	malServer := httptest.NewServer(myAnimeList)
+	defer malServer.Close()
+
+	retry := &RetryPolicy{MaxAttempts: 1}
+	primary := NewHummingbirdAnimeList("darin_minamoto", "token", ListOptions{BaseURL: hummingbirdServer.URL, Retry: retry})
+	replica := NewMyAnimeListAnimeList("darin_minamoto", "password", ListOptions{BaseURL: malServer.URL, Retry: retry})
+	manager := NewAnimelistManager(primary, replica)
+
+	// sync fetches both sites, copies the changes between the lists and pushes them
+	sync := func() {
+		if err := manager.Fetch(); err != nil {
+			t.Fatalf("TestFakeMyAnimeList_SyncWithHummingbird failed: %v", err)
+		}
+
+		if conflicts, err := manager.Sync(); err != nil || len(conflicts) != 0 {
+			t.Fatalf("TestFakeMyAnimeList_SyncWithHummingbird failed: %v %+v", err, conflicts)
+		}
+		if err := manager.Push(); err != nil {
+			t.Fatalf("TestFakeMyAnimeList_SyncWithHummingbird failed: %v", err)
+		}
+	}
+
+	// checkLibraries checks that both sites have the same library
+	checkLibraries := func() {
+		hummingbirdLibrary, malLibrary := hummingbird.Library("darin_minamoto"), myAnimeList.Library("darin_minamoto")
+		if len(hummingbirdLibrary) != len(malLibrary) {
+			t.Fatalf("TestFakeMyAnimeList_SyncWithHummingbird failed: expected the same number of entries got %+v %+v",
+				hummingbirdLibrary, malLibrary)
+		}
+		for i := range hummingbirdLibrary {
+			if hummingbirdLibrary[i].ID().Get(MyAnimeList) != malLibrary[i].ID().Get(MyAnimeList) ||
+				!SameAnime(hummingbirdLibrary[i], malLibrary[i]) {
+				t.Errorf("TestFakeMyAnimeList_SyncWithHummingbird failed: want %+v got %+v", hummingbirdLibrary[i], malLibrary[i])
+			}
+		}
+	}
+
+	sync()
+	checkLibraries()
+
+	// a change made on MAL is synced back to Hummingbird
+	edited := myAnimeList.Library("darin_minamoto")[0]
+	edited.MyStatus = MALStatusCompleted
+	edited.MyWatchedEpisodes = 24
+	edited.MyLastUpdated = time.Now().Unix()
+	myAnimeList.SetEntry("darin_minamoto", edited)
+
+	sync()
+	checkLibraries()
+	if entry := hummingbird.Library("darin_minamoto")[0]; entry.AnimeStatus != "completed" || entry.NumEpisodesWatched != 24 {
+		t.Errorf("TestFakeMyAnimeList_SyncWithHummingbird failed: expected the MAL change to be synced got %+v", entry)
+	}
+}
+
+func TestFakeMyAnimeList_SyncOptionalFields(t *testing.T) {
+	defer withoutListThrottles()()
+
+	hummingbird := NewFakeHummingbird()
+	hummingbird.AddUser("darin_minamoto", "token")
+	myAnimeList := NewFakeMyAnimeList()
+	myAnimeList.AddUser("darin_minamoto", "password")
+	entry := newSyncTestAnime(1, 101, 3)
+	entry.Rating = HummingbirdRatingFromScore(7)
+	entry.EntryNotes = "Rewatching with friends"
+	entry.IsPrivate = true
+	hummingbird.AddAnime(entry.Data)
+	hummingbird.SetEntry("darin_minamoto", entry)
+	myAnimeList.AddAnime(newFakeMALTestAnime(101, 0, 0))
+
+	hummingbirdServer := httptest.NewServer(hummingbird)
+	defer hummingbirdServer.Close()
+	malServer := httptest.NewServer(myAnimeList)
+	defer malServer.Close()
+
+	primary := NewHummingbirdAnimeList("darin_minamoto", "token", ListOptions{BaseURL: hummingbirdServer.URL})
+	replica := NewMyAnimeListAnimeList("darin_minamoto", "password", ListOptions{BaseURL: malServer.URL})
+	manager := NewAnimelistManager(primary, replica)
+	sync := func() {
+		if err := manager.Fetch(); err != nil {
+			t.Fatalf("TestFakeMyAnimeList_SyncOptionalFields failed: %v", err)
+		}
+		if conflicts, err := manager.Sync(); err != nil || len(conflicts) != 0 {
+			t.Fatalf("TestFakeMyAnimeList_SyncOptionalFields failed: %v %+v", err, conflicts)
+		}
+		if err := manager.Push(); err != nil {
+			t.Fatalf("TestFakeMyAnimeList_SyncOptionalFields failed: %v", err)
+		}
+	}
+
+	sync()
+	if entry := myAnimeList.Library("darin_minamoto")[0]; entry.MyScore != 7 || entry.MyComments != "Rewatching with friends" {
+		t.Errorf("TestFakeMyAnimeList_SyncOptionalFields failed: expected the score and notes to be synced got %+v", entry)
+	}
+
+	// the dates that only MAL keeps aren't cleared by syncing changes from Hummingbird
+	edited, _ := replica.Get(101)
+	malEntry := edited.(MALAnime)
+	malEntry.MyScore = 8
+	malEntry.MyStartDate = "2016-05-20"
+	replica.Edit(malEntry)
+	if err := replica.Push(); err != nil {
+		t.Fatalf("TestFakeMyAnimeList_SyncOptionalFields failed: %v", err)
+	}
+	sync()
+	if entry := hummingbird.Library("darin_minamoto")[0]; entry.Rating.Value != "4" || !entry.IsPrivate {
+		t.Errorf("TestFakeMyAnimeList_SyncOptionalFields failed: expected the MAL score to be synced got %+v", entry)
+	}
+
+	anime, _ := primary.Get(1)
+	hummingbirdEntry := anime.(HummingbirdAnime)
+	hummingbirdEntry.NumEpisodesWatched = 4
+	primary.Edit(hummingbirdEntry)
+	if err := primary.Push(); err != nil {
+		t.Fatalf("TestFakeMyAnimeList_SyncOptionalFields failed: %v", err)
+	}
+	sync()
+	if entry := myAnimeList.Library("darin_minamoto")[0]; entry.MyWatchedEpisodes != 4 || entry.MyScore != 8 || entry.MyStartDate != "2016-05-20" {
+		t.Errorf("TestFakeMyAnimeList_SyncOptionalFields failed: expected the start date to be kept got %+v", entry)
+	}
+}
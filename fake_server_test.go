@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FakeFault is a failure that a fake server responds with instead of handling requests
+type FakeFault struct {
+	// Method and PathPrefix choose the requests that fail, every request matches if they are empty
+	Method     string
+	PathPrefix string
+	StatusCode int
+	// RetryAfter is the Retry-After header of the failures if it is set
+	RetryAfter string
+	// Times is the number of requests that fail, every matching request fails if it is 0
+	Times int
+}
+
+// matches returns true if the fault fails the request
+func (fault FakeFault) matches(r *http.Request) bool {
+	return (fault.Method == "" || fault.Method == r.Method) && strings.HasPrefix(r.URL.Path, fault.PathPrefix)
+}
+
+// FakeRequest is a request received by a fake server
+type FakeRequest struct {
+	Method string
+	Path   string
+}
+
+// fakeServer has the behavior shared by the fake anime list sites: latency, injected faults
+// and a log of the requests. mutex also guards the state of the fake embedding it
+type fakeServer struct {
+	mutex    sync.Mutex
+	latency  time.Duration
+	faults   []FakeFault
+	requests []FakeRequest
+}
+
+// SetLatency makes the server wait before responding to every request
+func (fs *fakeServer) SetLatency(latency time.Duration) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	fs.latency = latency
+}
+
+// InjectFault makes the server fail the requests matching the fault.
+// Faults are checked in the order they were injected
+func (fs *fakeServer) InjectFault(fault FakeFault) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	fs.faults = append(fs.faults, fault)
+}
+
+// ClearFaults removes every injected fault
+func (fs *fakeServer) ClearFaults() {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	fs.faults = nil
+}
+
+// Requests returns the requests that the server received, oldest first
+func (fs *fakeServer) Requests() []FakeRequest {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	return append([]FakeRequest(nil), fs.requests...)
+}
+
+// intercept records the request, waits for the latency and responds with an injected
+// fault if one matches. It returns true if the request was handled
+func (fs *fakeServer) intercept(w http.ResponseWriter, r *http.Request) bool {
+	fs.mutex.Lock()
+	fs.requests = append(fs.requests, FakeRequest{Method: r.Method, Path: r.URL.Path})
+	latency := fs.latency
+
+	var fault *FakeFault
+	for i := range fs.faults {
+		if fs.faults[i].matches(r) {
+			matched := fs.faults[i]
+			fault = &matched
+			if fs.faults[i].Times > 0 {
+				fs.faults[i].Times--
+				if fs.faults[i].Times == 0 {
+					fs.faults = append(fs.faults[:i], fs.faults[i+1:]...)
+				}
+			}
+			break
+		}
+	}
+	fs.mutex.Unlock()
+
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-r.Context().Done():
+			return true
+		}
+	}
+
+	if fault == nil {
+		return false
+	}
+	if fault.RetryAfter != "" {
+		w.Header().Set("Retry-After", fault.RetryAfter)
+	}
+	http.Error(w, http.StatusText(fault.StatusCode), fault.StatusCode)
+	return true
+}
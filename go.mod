@@ -0,0 +1,3 @@
+module github.com/DarinM223/myhumminglist
+
+go 1.16
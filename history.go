@@ -0,0 +1,192 @@
+package main
+
+import (
+	"time"
+)
+
+// HistoryEntry is a change made to an anime list
+type HistoryEntry struct {
+	Change Change
+	Time   time.Time
+	// Batch is the number of the push that sent the change, 0 if it hasn't been pushed
+	Batch int
+}
+
+// Pushed returns true if the change has been pushed
+func (entry HistoryEntry) Pushed() bool {
+	return entry.Batch != 0
+}
+
+// History is the list of changes made to an anime list that can be undone and redone.
+// Changes that haven't been pushed are undone one at a time while
+// changes that have been pushed are undone one push at a time
+type History struct {
+	// entries are the changes that are done, oldest first
+	entries []HistoryEntry
+	// undone are the groups of entries that were undone, most recently undone last
+	undone [][]HistoryEntry
+	// batches are the merged changes that were sent by each push
+	batches   map[int][]Change
+	lastBatch int
+}
+
+// Entries returns the changes that are done, oldest first
+func (h *History) Entries() []HistoryEntry {
+	return append([]HistoryEntry(nil), h.entries...)
+}
+
+// record adds a new change to the history.
+// Changes that were undone can no longer be redone after a new change
+func (h *History) record(change Change) {
+	h.entries = append(h.entries, HistoryEntry{Change: change, Time: time.Now()})
+	h.undone = nil
+}
+
+// pushed marks the changes that haven't been pushed and were sent as pushed by a new batch
+// that sent the merged changes. sent tells whether each of the changes that haven't been pushed
+// was sent, oldest first
+func (h *History) pushed(mergedChanges []Change, sent []bool) {
+	batch := h.lastBatch + 1
+	marked := false
+	j := 0
+	for i := range h.entries {
+		if h.entries[i].Pushed() {
+			continue
+		}
+		if j < len(sent) && sent[j] {
+			h.entries[i].Batch = batch
+			marked = true
+		}
+		j++
+	}
+	if !marked {
+		return
+	}
+	h.lastBatch = batch
+
+	if h.batches == nil {
+		h.batches = make(map[int][]Change)
+	}
+	h.batches[h.lastBatch] = mergedChanges
+}
+
+// rebase replaces the changes of the entries that haven't been pushed, oldest first, with
+// the changes they were rebased to. Entries whose rebased change is nil are removed and
+// changes that were undone can no longer be redone
+func (h *History) rebase(changes []Change) {
+	var entries []HistoryEntry
+	i := 0
+	for _, entry := range h.entries {
+		if !entry.Pushed() && i < len(changes) {
+			entry.Change = changes[i]
+			i++
+			if entry.Change == nil {
+				continue
+			}
+		}
+		entries = append(entries, entry)
+	}
+	h.entries = entries
+	h.undone = nil
+}
+
+// lastUnit returns the entries that the next undo would undo, which is the last change if it
+// hasn't been pushed and otherwise the latest push. Retrying a push that partially failed
+// sends older changes than the push before it, so the latest push is found by its batch number
+func (h *History) lastUnit() []HistoryEntry {
+	if len(h.entries) == 0 {
+		return nil
+	}
+
+	last := h.entries[len(h.entries)-1]
+	if !last.Pushed() {
+		return []HistoryEntry{last}
+	}
+
+	latest := 0
+	for _, entry := range h.entries {
+		if entry.Batch > latest {
+			latest = entry.Batch
+		}
+	}
+	var unit []HistoryEntry
+	for _, entry := range h.entries {
+		if entry.Batch == latest {
+			unit = append(unit, entry)
+		}
+	}
+	return unit
+}
+
+// undo moves the last entries from the done entries to the undone entries
+func (h *History) undo(unit []HistoryEntry) {
+	batch := unit[0].Batch
+	if !unit[0].Pushed() {
+		h.entries = h.entries[:len(h.entries)-1]
+	} else {
+		var entries []HistoryEntry
+		for _, entry := range h.entries {
+			if entry.Batch != batch {
+				entries = append(entries, entry)
+			}
+		}
+		h.entries = entries
+	}
+	h.undone = append(h.undone, unit)
+}
+
+// lastUndone returns the entries that the next redo would redo
+func (h *History) lastUndone() []HistoryEntry {
+	if len(h.undone) == 0 {
+		return nil
+	}
+	return h.undone[len(h.undone)-1]
+}
+
+// redo moves the entries of the unit from the last undone entries back to the done entries.
+// The rest of the last undone entries are left to be redone after a partial redo
+func (h *History) redo(unit []HistoryEntry) {
+	last := len(h.undone) - 1
+	var left []HistoryEntry
+	for _, entry := range h.undone[last] {
+		if entry.Batch != unit[0].Batch {
+			left = append(left, entry)
+		}
+	}
+	if len(left) == 0 {
+		h.undone = h.undone[:last]
+	} else {
+		h.undone[last] = left
+	}
+	h.entries = append(h.entries, unit...)
+}
+
+// split moves the entries of the batch whose changes were sent to a new batch of the sent merged
+// changes, leaving the other entries in the batch with the merged changes that failed to send.
+// sent tells whether each of the entries of the batch was sent, oldest first. It returns the
+// entries that were moved
+func (h *History) split(batch int, sentChanges []Change, failedChanges []Change, sent []bool) []HistoryEntry {
+	h.lastBatch++
+	h.batches[batch] = failedChanges
+	h.batches[h.lastBatch] = sentChanges
+
+	var moved []HistoryEntry
+	j := 0
+	move := func(entries []HistoryEntry) {
+		for i := range entries {
+			if entries[i].Batch != batch {
+				continue
+			}
+			if j < len(sent) && sent[j] {
+				entries[i].Batch = h.lastBatch
+				moved = append(moved, entries[i])
+			}
+			j++
+		}
+	}
+	move(h.entries)
+	for _, unit := range h.undone {
+		move(unit)
+	}
+	return moved
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// stubChangeList records the changes applied to it and sent by a changeTracker
+type stubChangeList struct {
+	applied []Change
+	sent    []Change
+	undone  bool
+	sendErr error
+	// failIDs are the Hummingbird IDs of the anime whose requests fail
+	failIDs map[int]bool
+}
+
+func (list *stubChangeList) applyChange(change Change, undo ...bool) {
+	list.applied = append(list.applied, change)
+}
+
+func (list *stubChangeList) sendChanges(changes []Change, undo ...bool) ([]RequestResult, error) {
+	if list.sendErr != nil {
+		return nil, list.sendErr
+	}
+	list.sent = changes
+	list.undone = len(undo) > 0 && undo[0]
+
+	results := make([]RequestResult, len(changes))
+	for i, change := range changes {
+		if list.failIDs[changeAnimeID(change, Hummingbird)] {
+			results[i].Err = errors.New("Status code is 500")
+		}
+	}
+	return results, nil
+}
+
+func TestHummingbirdAnimeList_UndoRedoUnpushed(t *testing.T) {
+	list := NewHummingbirdAnimeList("darin_minamoto", "")
+	list.Add(newSyncTestAnime(1, 101, 3))
+	list.Edit(newSyncTestAnime(1, 101, 4))
+	list.Add(newSyncTestAnime(2, 102, 5))
+
+	if err := list.Undo(); err != nil {
+		t.Fatalf("TestHummingbirdAnimeList_UndoRedoUnpushed failed: %v", err)
+	}
+	if err := list.Undo(); err != nil {
+		t.Fatalf("TestHummingbirdAnimeList_UndoRedoUnpushed failed: %v", err)
+	}
+	if list.Contains(2) || list.anime[1].EpisodesWatched() != 3 || len(list.changes) != 1 {
+		t.Errorf("TestHummingbirdAnimeList_UndoRedoUnpushed failed: expected the last two changes to be undone got %+v", list.anime)
+	}
+
+	if err := list.Redo(); err != nil {
+		t.Fatalf("TestHummingbirdAnimeList_UndoRedoUnpushed failed: %v", err)
+	}
+	if list.anime[1].EpisodesWatched() != 4 || len(list.changes) != 2 {
+		t.Errorf("TestHummingbirdAnimeList_UndoRedoUnpushed failed: expected the edit to be redone got %+v", list.anime)
+	}
+
+	// a new change clears the changes that can be redone
+	list.Remove(newSyncTestAnime(1, 101, 4))
+	if err := list.Redo(); err == nil {
+		t.Errorf("TestHummingbirdAnimeList_UndoRedoUnpushed failed: expected an error redoing after a new change")
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := list.Undo(); err != nil {
+			t.Fatalf("TestHummingbirdAnimeList_UndoRedoUnpushed failed: %v", err)
+		}
+	}
+	if len(list.anime) != 0 || len(list.changes) != 0 || len(list.History()) != 0 {
+		t.Errorf("TestHummingbirdAnimeList_UndoRedoUnpushed failed: expected every change to be undone")
+	}
+	if err := list.Undo(); err == nil {
+		t.Errorf("TestHummingbirdAnimeList_UndoRedoUnpushed failed: expected an error undoing an empty history")
+	}
+}
+
+func TestMyAnimeListAnimeList_UndoRestoresRemovedAnime(t *testing.T) {
+	list := NewMyAnimeListAnimeList("darin_minamoto", "")
+	anime := AnimeToMAL(newSyncTestAnime(1, 101, 3))
+	list.Add(anime)
+	list.Remove(anime)
+
+	if err := list.Undo(); err != nil {
+		t.Fatalf("TestMyAnimeListAnimeList_UndoRestoresRemovedAnime failed: %v", err)
+	}
+	if !reflect.DeepEqual(list.anime[101], anime) {
+		t.Errorf("TestMyAnimeListAnimeList_UndoRestoresRemovedAnime failed: want %+v got %+v", anime, list.anime[101])
+	}
+}
+
+func TestChangeTracker_UndoRedoPushedBatch(t *testing.T) {
+	list := &stubChangeList{}
+	tracker := newChangeTracker(Hummingbird)
+
+	firstBatch := []Change{AddChange{newSyncTestAnime(1, 101, 3)}}
+	tracker.record(firstBatch[0])
+	tracker.push(list, firstBatch)
+
+	secondChanges := []Change{
+		AddChange{newSyncTestAnime(2, 102, 3)},
+		EditChange{OldAnime: newSyncTestAnime(2, 102, 3), NewAnime: newSyncTestAnime(2, 102, 4)},
+	}
+	secondBatch := MergeChanges(secondChanges, Hummingbird)
+	for _, change := range secondChanges {
+		tracker.record(change)
+	}
+	tracker.push(list, secondBatch)
+
+	history := tracker.History()
+	if len(history) != 3 || history[0].Batch != 1 || history[1].Batch != 2 || history[2].Batch != 2 {
+		t.Fatalf("TestChangeTracker_UndoRedoPushedBatch failed: unexpected history %+v", history)
+	}
+	for _, entry := range history {
+		if entry.Time.IsZero() || !entry.Pushed() {
+			t.Errorf("TestChangeTracker_UndoRedoPushedBatch failed: expected a pushed entry with a time got %+v", entry)
+		}
+	}
+
+	// the whole second push is undone at once
+	if err := tracker.undo(list); err != nil {
+		t.Fatalf("TestChangeTracker_UndoRedoPushedBatch failed: %v", err)
+	}
+	if !reflect.DeepEqual(list.sent, secondBatch) || !list.undone {
+		t.Errorf("TestChangeTracker_UndoRedoPushedBatch failed: expected undo requests for %+v got %+v", secondBatch, list.sent)
+	}
+	expectedApplied := []Change{secondChanges[1], secondChanges[0]}
+	if !reflect.DeepEqual(list.applied, expectedApplied) {
+		t.Errorf("TestChangeTracker_UndoRedoPushedBatch failed: want undone %+v got %+v", expectedApplied, list.applied)
+	}
+	if !reflect.DeepEqual(tracker.pastChanges, firstBatch) || len(tracker.History()) != 1 {
+		t.Errorf("TestChangeTracker_UndoRedoPushedBatch failed: expected only the first push to be left got %+v", tracker.pastChanges)
+	}
+
+	// redoing sends the push again
+	list.applied = nil
+	if err := tracker.redo(list); err != nil {
+		t.Fatalf("TestChangeTracker_UndoRedoPushedBatch failed: %v", err)
+	}
+	if !reflect.DeepEqual(list.sent, secondBatch) || list.undone {
+		t.Errorf("TestChangeTracker_UndoRedoPushedBatch failed: expected requests for %+v got %+v", secondBatch, list.sent)
+	}
+	if !reflect.DeepEqual(list.applied, secondChanges) || len(tracker.History()) != 3 || len(tracker.pastChanges) != 2 {
+		t.Errorf("TestChangeTracker_UndoRedoPushedBatch failed: expected the second push to be redone got %+v", list.applied)
+	}
+
+	// nothing changes if the undo requests fail
+	list.sendErr = errors.New("request failed")
+	if err := tracker.undo(list); err != list.sendErr {
+		t.Errorf("TestChangeTracker_UndoRedoPushedBatch failed: want error %v got %v", list.sendErr, err)
+	}
+	if len(tracker.History()) != 3 || len(tracker.pastChanges) != 2 {
+		t.Errorf("TestChangeTracker_UndoRedoPushedBatch failed: expected the history to not change after a failed undo")
+	}
+}
+
+func TestChangeTracker_UndoUnpushedBeforePushed(t *testing.T) {
+	list := &stubChangeList{}
+	tracker := newChangeTracker(Hummingbird)
+
+	pushedChange := AddChange{newSyncTestAnime(1, 101, 3)}
+	tracker.record(pushedChange)
+	tracker.push(list, []Change{pushedChange})
+	list.sent = nil
+	unpushedChange := EditChange{OldAnime: newSyncTestAnime(1, 101, 3), NewAnime: newSyncTestAnime(1, 101, 4)}
+	tracker.record(unpushedChange)
+
+	if err := tracker.undo(list); err != nil {
+		t.Fatalf("TestChangeTracker_UndoUnpushedBeforePushed failed: %v", err)
+	}
+	if list.sent != nil || len(tracker.changes) != 0 || !reflect.DeepEqual(list.applied, []Change{unpushedChange}) {
+		t.Errorf("TestChangeTracker_UndoUnpushedBeforePushed failed: expected the unpushed change to be undone locally")
+	}
+}
+
+func TestChangeTracker_PartialUndoRedo(t *testing.T) {
+	list := &stubChangeList{}
+	tracker := newChangeTracker(Hummingbird)
+
+	firstBatch := []Change{AddChange{newSyncTestAnime(1, 101, 3)}}
+	tracker.record(firstBatch[0])
+	tracker.push(list, firstBatch)
+	secondBatch := []Change{AddChange{newSyncTestAnime(2, 102, 3)}, AddChange{newSyncTestAnime(3, 103, 3)}}
+	for _, change := range secondBatch {
+		tracker.record(change)
+	}
+	tracker.push(list, secondBatch)
+
+	// only the undo of anime 2 is sent, so only it is undone and anime 3 is left to undo again
+	list.failIDs = map[int]bool{3: true}
+	var pushErr *PushError
+	if err := tracker.undo(list); !errors.As(err, &pushErr) || len(pushErr.Failures) != 1 || pushErr.Failures[0].ID != 3 {
+		t.Fatalf("TestChangeTracker_PartialUndoRedo failed: expected the undo of anime 3 to fail got %v", err)
+	}
+	if !reflect.DeepEqual(list.applied, []Change{secondBatch[0]}) {
+		t.Errorf("TestChangeTracker_PartialUndoRedo failed: expected only anime 2 to be undone got %+v", list.applied)
+	}
+	expectedPast := []Change{firstBatch[0], secondBatch[1]}
+	if history := tracker.History(); len(history) != 2 || !reflect.DeepEqual(tracker.pastChanges, expectedPast) {
+		t.Errorf("TestChangeTracker_PartialUndoRedo failed: unexpected history %+v and past changes %+v", history, tracker.pastChanges)
+	}
+
+	// undoing again only sends the undo that failed
+	list.failIDs, list.applied = nil, nil
+	if err := tracker.undo(list); err != nil {
+		t.Fatalf("TestChangeTracker_PartialUndoRedo failed: %v", err)
+	}
+	if !reflect.DeepEqual(list.sent, []Change{secondBatch[1]}) || len(tracker.History()) != 1 {
+		t.Errorf("TestChangeTracker_PartialUndoRedo failed: expected only anime 3 to be undone got %+v", list.sent)
+	}
+
+	// the undone parts are redone one at a time, most recently undone first
+	list.failIDs = map[int]bool{3: true}
+	if err := tracker.redo(list); err == nil {
+		t.Errorf("TestChangeTracker_PartialUndoRedo failed: expected the redo of anime 3 to fail")
+	}
+	list.failIDs = nil
+	for _, expected := range [][]Change{{secondBatch[1]}, {secondBatch[0]}} {
+		if err := tracker.redo(list); err != nil || !reflect.DeepEqual(list.sent, expected) {
+			t.Errorf("TestChangeTracker_PartialUndoRedo failed: want %+v sent got %+v %v", expected, list.sent, err)
+		}
+	}
+	if len(tracker.History()) != 3 || len(tracker.pastChanges) != 3 {
+		t.Errorf("TestChangeTracker_PartialUndoRedo failed: expected everything to be redone got %+v", tracker.History())
+	}
+	if err := tracker.redo(list); err == nil {
+		t.Errorf("TestChangeTracker_PartialUndoRedo failed: expected nothing left to redo")
+	}
+}
+
+func TestChangeTracker_UndoRetriedPush(t *testing.T) {
+	list := &stubChangeList{failIDs: map[int]bool{1: true}}
+	tracker := newChangeTracker(Hummingbird)
+
+	changes := []Change{AddChange{newSyncTestAnime(1, 101, 3)}, AddChange{newSyncTestAnime(2, 102, 3)}}
+	for _, change := range changes {
+		tracker.record(change)
+	}
+	tracker.push(list, MergeChanges(tracker.changes, Hummingbird))
+	list.failIDs = nil
+	if err := tracker.push(list, MergeChanges(tracker.changes, Hummingbird)); err != nil {
+		t.Fatalf("TestChangeTracker_UndoRetriedPush failed: %v", err)
+	}
+
+	// the retried push of anime 1 is the latest push even though anime 1 was changed first
+	if err := tracker.undo(list); err != nil {
+		t.Fatalf("TestChangeTracker_UndoRetriedPush failed: %v", err)
+	}
+	if !reflect.DeepEqual(list.sent, []Change{changes[0]}) || !list.undone {
+		t.Errorf("TestChangeTracker_UndoRetriedPush failed: expected the undo of anime 1 to be sent got %+v", list.sent)
+	}
+	if !reflect.DeepEqual(tracker.pastChanges, []Change{changes[1]}) {
+		t.Errorf("TestChangeTracker_UndoRetriedPush failed: expected only anime 2 to be left got %+v", tracker.pastChanges)
+	}
+	if history := tracker.History(); len(history) != 1 || history[0].Change != changes[1] {
+		t.Errorf("TestChangeTracker_UndoRetriedPush failed: unexpected history %+v", history)
+	}
+}
+
+func TestChangeTracker_UndoMergedEdits(t *testing.T) {
+	list := &stubChangeList{}
+	tracker := newChangeTracker(Hummingbird)
+
+	edits := []Change{
+		EditChange{OldAnime: newSyncTestAnime(1, 101, 3), NewAnime: newSyncTestAnime(1, 101, 4)},
+		EditChange{OldAnime: newSyncTestAnime(1, 101, 4), NewAnime: newSyncTestAnime(1, 101, 5)},
+	}
+	for _, change := range edits {
+		tracker.record(change)
+	}
+	tracker.push(list, MergeChanges(tracker.changes, Hummingbird))
+
+	// the undo request goes back to before the first edit like the undo of the list does
+	if err := tracker.undo(list); err != nil {
+		t.Fatalf("TestChangeTracker_UndoMergedEdits failed: %v", err)
+	}
+	expected := []Change{EditChange{OldAnime: newSyncTestAnime(1, 101, 3), NewAnime: newSyncTestAnime(1, 101, 5)}}
+	if !reflect.DeepEqual(list.sent, expected) || !list.undone {
+		t.Errorf("TestChangeTracker_UndoMergedEdits failed: want undo requests for %+v got %+v", expected, list.sent)
+	}
+	if !reflect.DeepEqual(list.applied, []Change{edits[1], edits[0]}) {
+		t.Errorf("TestChangeTracker_UndoMergedEdits failed: expected both edits to be undone got %+v", list.applied)
+	}
+}
@@ -1,20 +1,24 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"net/http"
 	"net/url"
-	"reflect"
-	"strings"
+	"sort"
+	"strconv"
+	"time"
 )
 
 const (
-	HummingbirdAddURL     = "https://hummingbird.me/api/v1/libraries/%d"
-	HummingbirdEditURL    = "https://hummingbird.me/api/v1/libraries/%d"
-	HummingbirdDeleteURL  = "https://hummingbird.me/api/v1/libraries/%d/remove"
-	HummingbirdLibraryURL = "https://hummingbird.me/api/v1/users/%s/library?include_mal_id=true"
+	HummingbirdBaseURL    = "https://hummingbird.me"
+	HummingbirdAddURL     = HummingbirdBaseURL + "/api/v1/libraries/%d"
+	HummingbirdEditURL    = HummingbirdBaseURL + "/api/v1/libraries/%d"
+	HummingbirdDeleteURL  = HummingbirdBaseURL + "/api/v1/libraries/%d/remove"
+	HummingbirdLibraryURL = HummingbirdBaseURL + "/api/v1/users/%s/library?include_mal_id=true"
 )
 
 // HummingbirdAnime represents the JSON data of a Hummingbird library entry
@@ -23,9 +27,38 @@ type HummingbirdAnime struct {
 	AnimeStatus        string               `json:"status"`
 	NumRewatchedTimes  int                  `json:"rewatched_times"`
 	IsRewatching       bool                 `json:"rewatching"`
+	LastUpdated        time.Time            `json:"updated_at"`
+	LastWatched        time.Time            `json:"last_watched"`
+	Rating             HummingbirdRating    `json:"rating"`
+	EntryNotes         string               `json:"notes"`
+	IsPrivate          bool                 `json:"private"`
 	Data               HummingbirdAnimeData `json:"anime"`
 }
 
+// HummingbirdRating represents the JSON data of the rating of a Hummingbird library entry.
+// Value is the number of stars from 0.5 to 5 in half stars, or empty if the anime isn't rated
+type HummingbirdRating struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// HummingbirdRatingFromScore returns the Hummingbird rating of a score out of 10
+func HummingbirdRatingFromScore(score int) HummingbirdRating {
+	if score <= 0 {
+		return HummingbirdRating{}
+	}
+	return HummingbirdRating{Type: "advanced", Value: strconv.FormatFloat(float64(score)/2, 'f', -1, 64)}
+}
+
+// Score returns the score out of 10 of the rating, 0 if it isn't rated or the value isn't a number
+func (r HummingbirdRating) Score() int {
+	stars, err := strconv.ParseFloat(r.Value, 64)
+	if err != nil || stars <= 0 {
+		return 0
+	}
+	return int(math.Round(stars * 2))
+}
+
 // HummingbirdAnimeData represents the JSON data of a Hummingbird anime
 type HummingbirdAnimeData struct {
 	Id            int    `json:"id"`
@@ -46,20 +79,27 @@ func (ha HummingbirdAnime) Title() string {
 	return ha.Data.Title
 }
 
+// Status returns the status of the anime, StatusUnknown if the Hummingbird status isn't known
 func (ha HummingbirdAnime) Status() int {
-	switch ha.AnimeStatus {
+	status, _ := ParseHummingbirdStatus(ha.AnimeStatus)
+	return status
+}
+
+// ParseHummingbirdStatus returns the status of a Hummingbird status string
+func ParseHummingbirdStatus(status string) (int, error) {
+	switch status {
 	case "currently-watching":
-		return StatusWatching
+		return StatusWatching, nil
 	case "plan-to-watch":
-		return StatusPlanToWatch
+		return StatusPlanToWatch, nil
 	case "completed":
-		return StatusCompleted
+		return StatusCompleted, nil
 	case "on-hold":
-		return StatusOnHold
+		return StatusOnHold, nil
 	case "dropped":
-		return StatusDropped
+		return StatusDropped, nil
 	default:
-		panic("Invalid status")
+		return StatusUnknown, &StatusError{Status: strconv.Quote(status)}
 	}
 }
 
@@ -75,28 +115,80 @@ func (ha HummingbirdAnime) Rewatching() bool {
 	return ha.IsRewatching
 }
 
-func StatusToHummingbirdString(status int) string {
+func (ha HummingbirdAnime) UpdatedAt() time.Time {
+	return ha.LastUpdated
+}
+
+func (ha HummingbirdAnime) Score() int {
+	return ha.Rating.Score()
+}
+
+func (ha HummingbirdAnime) Notes() string {
+	return ha.EntryNotes
+}
+
+func (ha HummingbirdAnime) Private() bool {
+	return ha.IsPrivate
+}
+
+// StartDate returns the zero time because Hummingbird doesn't keep start dates
+func (ha HummingbirdAnime) StartDate() time.Time {
+	return time.Time{}
+}
+
+// FinishDate returns the zero time because Hummingbird doesn't keep finish dates
+func (ha HummingbirdAnime) FinishDate() time.Time {
+	return time.Time{}
+}
+
+// RewatchEpisode returns 0 because Hummingbird doesn't keep the episode of a rewatch
+func (ha HummingbirdAnime) RewatchEpisode() int {
+	return 0
+}
+
+// hummingbirdPrivacy returns the value of the privacy form field of a Hummingbird request
+func hummingbirdPrivacy(private bool) string {
+	if private {
+		return "private"
+	}
+	return "public"
+}
+
+// StatusToHummingbirdString returns the Hummingbird status string of a status
+func StatusToHummingbirdString(status int) (string, error) {
 	switch status {
 	case StatusWatching:
-		return "currently-watching"
+		return "currently-watching", nil
 	case StatusPlanToWatch:
-		return "plan-to-watch"
+		return "plan-to-watch", nil
 	case StatusCompleted:
-		return "completed"
+		return "completed", nil
 	case StatusOnHold:
-		return "on-hold"
+		return "on-hold", nil
 	case StatusDropped:
-		return "dropped"
+		return "dropped", nil
 	default:
-		panic("Invalid status")
+		return "", &StatusError{Status: strconv.Itoa(status)}
 	}
 }
 
+// AnimeToHummingbird converts an anime to a Hummingbird anime.
+// An unknown status is converted to an empty status string
 func AnimeToHummingbird(anime Anime) HummingbirdAnime {
+	if ha, ok := anime.(HummingbirdAnime); ok {
+		// converting would lose the fields that aren't in Anime like LastWatched
+		return ha
+	}
+	status, _ := StatusToHummingbirdString(anime.Status())
 	return HummingbirdAnime{
 		NumEpisodesWatched: anime.EpisodesWatched(),
 		NumRewatchedTimes:  anime.RewatchedTimes(),
-		AnimeStatus:        StatusToHummingbirdString(anime.Status()),
+		IsRewatching:       anime.Rewatching(),
+		AnimeStatus:        status,
+		LastUpdated:        anime.UpdatedAt(),
+		Rating:             HummingbirdRatingFromScore(anime.Score()),
+		EntryNotes:         anime.Notes(),
+		IsPrivate:          anime.Private(),
 		Data: HummingbirdAnimeData{
 			Id:    anime.ID().Get(Hummingbird),
 			MalID: anime.ID().Get(MyAnimeList),
@@ -106,84 +198,152 @@ func AnimeToHummingbird(anime Anime) HummingbirdAnime {
 }
 
 type HummingbirdAnimeList struct {
-	username    string
-	anime       map[int]HummingbirdAnime
-	changes     []Change
-	pastChanges []Change
-	authToken   string
+	changeTracker
+
+	username  string
+	anime     map[int]HummingbirdAnime
+	authToken Credential
+	options   ListOptions
 }
 
-func NewHummingbirdAnimeList(username string, authToken string) *HummingbirdAnimeList {
+func NewHummingbirdAnimeList(username string, authToken Credential, options ...ListOptions) *HummingbirdAnimeList {
 	return &HummingbirdAnimeList{
-		username:    username,
-		authToken:   authToken,
-		anime:       make(map[int]HummingbirdAnime),
-		changes:     []Change{},
-		pastChanges: []Change{},
+		username:      username,
+		authToken:     authToken,
+		anime:         make(map[int]HummingbirdAnime),
+		options:       listOptions(options),
+		changeTracker: newChangeTracker(Hummingbird),
 	}
 }
 
-func (hal HummingbirdAnimeList) Type() int {
+var _ Animelist = (*HummingbirdAnimeList)(nil)
+
+func (hal *HummingbirdAnimeList) Type() int {
 	return Hummingbird
 }
 
-func (hal HummingbirdAnimeList) AuthToken() string {
-	return hal.authToken
+// AuthToken returns the auth token of the list, asking the credential provider of
+// the list options for it if it wasn't passed to NewHummingbirdAnimeList
+func (hal *HummingbirdAnimeList) AuthToken() (Credential, error) {
+	authToken, err := hal.options.credential(hal.authToken, Hummingbird, hal.username)
+	if err != nil {
+		return "", err
+	}
+	hal.authToken = authToken
+	return authToken, nil
 }
 
-// Fetch fetches the animelist from the api and adds the changes to the change lists
+// Fetch fetches the animelist from the api and replays the changes that haven't been
+// pushed on top of it. Changes that no longer apply are returned by StaleChanges.
+// Entries that can't be decoded or have an unknown status are left out of the list
+// and returned by Quarantined
 func (hal *HummingbirdAnimeList) Fetch() error {
-	// TODO(DarinM223): http get request is extremely slow, maybe put inside goroutine?
-	resp, err := http.Get(fmt.Sprintf(HummingbirdLibraryURL, hal.username))
+	animeMap := make(map[int]HummingbirdAnime)
+	var quarantined []*EntryError
+	libraryURL := hal.options.url(fmt.Sprintf(HummingbirdLibraryURL, hal.username), HummingbirdBaseURL)
+	err := hal.options.fetch(Hummingbird, libraryURL, func(resp *http.Response) error {
+		decoder := json.NewDecoder(resp.Body)
+
+		// read the first token (the bracket token)
+		if _, err := decoder.Token(); err != nil {
+			return err
+		}
+
+		for decoder.More() {
+			var entry json.RawMessage
+			if err := decoder.Decode(&entry); err != nil {
+				return err
+			}
+
+			var anime HummingbirdAnime
+			if err := json.Unmarshal(entry, &anime); err != nil {
+				quarantined = append(quarantined, &EntryError{ListType: Hummingbird, Err: err})
+				continue
+			}
+			if err := checkHummingbirdEntry(anime); err != nil {
+				quarantined = append(quarantined, err)
+				continue
+			}
+			animeMap[anime.ID().Get(Hummingbird)] = anime
+		}
+
+		// read the last token (the bracket token)
+		_, err := decoder.Token()
+		return err
+	})
 	if err != nil {
 		return err
 	}
-	if resp.StatusCode != 200 {
-		return errors.New("Status code for response is not 200")
-	}
 
-	decoder := json.NewDecoder(resp.Body)
-	animeMap := make(map[int]HummingbirdAnime)
+	hal.anime = animeMap
+	hal.quarantined = quarantined
+	return hal.rebase(hal)
+}
 
-	// read the first token (the bracket token)
-	if _, err := decoder.Token(); err != nil {
-		return err
+// checkHummingbirdEntry returns an error if a fetched anime can't be kept in the list
+func checkHummingbirdEntry(anime HummingbirdAnime) *EntryError {
+	entryErr := &EntryError{ListType: Hummingbird, ID: anime.Data.Id, Title: anime.Title()}
+	if anime.Data.Id == 0 {
+		entryErr.Err = ErrMissingID
+		return entryErr
 	}
+	if _, err := ParseHummingbirdStatus(anime.AnimeStatus); err != nil {
+		entryErr.Err = err
+		return entryErr
+	}
+	return nil
+}
 
-	for decoder.More() {
-		var anime HummingbirdAnime
-		if err := decoder.Decode(&anime); err != nil {
-			return err
-		}
+// OpenChangeLog opens the change log at the path, adds the changes in it to the list
+// and writes every change made to the list to the log until it is pushed
+func (hal *HummingbirdAnimeList) OpenChangeLog(path string) error {
+	return hal.openChangeLog(path, hal)
+}
 
-		animeMap[anime.ID().Get(Hummingbird)] = anime
+// loadAnime replaces the anime in the list without recording any changes
+func (hal *HummingbirdAnimeList) loadAnime(anime []Anime) {
+	hal.anime = make(map[int]HummingbirdAnime)
+	for _, a := range anime {
+		ha, ok := a.(HummingbirdAnime)
+		if !ok {
+			ha = AnimeToHummingbird(a)
+		}
+		hal.anime[a.ID().Get(Hummingbird)] = ha
 	}
+}
 
-	// read the last token (the bracket token)
-	if _, err = decoder.Token(); err != nil {
-		return err
-	}
+// applyChange changes the anime in the list to what they are after the change,
+// or to what they were before the change if undo is true
+func (hal *HummingbirdAnimeList) applyChange(change Change, undo ...bool) {
+	undoChange := len(undo) > 0 && undo[0]
 
-	newHummingbirdList := &HummingbirdAnimeList{
-		username:    hal.username,
-		authToken:   hal.authToken,
-		anime:       animeMap,
-		changes:     []Change{},
-		pastChanges: []Change{},
+	switch c := change.(type) {
+	case AddChange:
+		if undoChange {
+			delete(hal.anime, c.Anime.ID().Get(Hummingbird))
+		} else {
+			hal.anime[c.Anime.ID().Get(Hummingbird)] = AnimeToHummingbird(c.Anime)
+		}
+	case EditChange:
+		if undoChange {
+			hal.anime[c.OldAnime.ID().Get(Hummingbird)] = AnimeToHummingbird(c.OldAnime)
+		} else {
+			hal.anime[c.NewAnime.ID().Get(Hummingbird)] = AnimeToHummingbird(c.NewAnime)
+		}
+	case DeleteChange:
+		if undoChange {
+			hal.anime[c.Anime.ID().Get(Hummingbird)] = AnimeToHummingbird(c.Anime)
+		} else {
+			delete(hal.anime, c.Anime.ID().Get(Hummingbird))
+		}
 	}
-
-	changes := DiffHummingbirdLists(hal, newHummingbirdList)
-	hal.anime = animeMap
-	hal.changes = append(hal.changes, changes...)
-	return nil
 }
 
 func (hal *HummingbirdAnimeList) Add(anime Anime) {
 	id := anime.ID().Get(Hummingbird)
 	hal.anime[id] = AnimeToHummingbird(anime)
 
-	change := AddChange{Anime: anime}
-	hal.changes = append(hal.changes, change)
+	hal.record(AddChange{Anime: anime})
 }
 
 func (hal *HummingbirdAnimeList) Edit(anime Anime) {
@@ -191,12 +351,10 @@ func (hal *HummingbirdAnimeList) Edit(anime Anime) {
 	oldAnime := hal.anime[animeID]
 	hal.anime[animeID] = AnimeToHummingbird(anime)
 
-	change := EditChange{
+	hal.record(EditChange{
 		OldAnime: oldAnime,
 		NewAnime: anime,
-	}
-
-	hal.changes = append(hal.changes, change)
+	})
 }
 
 func (hal *HummingbirdAnimeList) Get(id int) (Anime, error) {
@@ -206,91 +364,160 @@ func (hal *HummingbirdAnimeList) Get(id int) (Anime, error) {
 	return nil, errors.New(fmt.Sprintf("Anime with ID %d is not in the anime list", id))
 }
 
+// Anime returns the anime in the list ordered by Hummingbird ID
+func (hal *HummingbirdAnimeList) Anime() []Anime {
+	ids := make([]int, 0, len(hal.anime))
+	for id := range hal.anime {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	anime := make([]Anime, len(ids))
+	for i, id := range ids {
+		anime[i] = hal.anime[id]
+	}
+	return anime
+}
+
+func (hal *HummingbirdAnimeList) Contains(id int) bool {
+	_, ok := hal.anime[id]
+	return ok
+}
+
 func (hal *HummingbirdAnimeList) Remove(anime Anime) {
 	delete(hal.anime, anime.ID().Get(Hummingbird))
-	change := DeleteChange{Anime: anime}
-	hal.changes = append(hal.changes, change)
+	hal.record(DeleteChange{Anime: anime})
 }
 
-func (hal *HummingbirdAnimeList) Push() error {
+// PlanPush returns the requests that Push would send without sending them
+func (hal *HummingbirdAnimeList) PlanPush() (*PushPlan, error) {
 	mergedChanges := MergeChanges(hal.changes, Hummingbird)
 
-	changeRequests := make([]*http.Request, len(mergedChanges))
+	plan := &PushPlan{
+		ListType: Hummingbird,
+		Changes:  mergedChanges,
+		Requests: make([]PlannedRequest, len(mergedChanges)),
+	}
 	for i, change := range mergedChanges {
-		request, err := hal.GenerateChange(change)
+		request, err := hal.planChange(change, false)
 		if err != nil {
-			return err
+			return nil, fmt.Errorf("Error planning %s: %w", DescribeChange(change, Hummingbird), err)
 		}
-
-		changeRequests[i] = request
+		plan.Requests[i] = request
 	}
+	return plan, nil
+}
 
-	// sends changes asynchronously
-	resultCh := make(chan error)
-	go SendRequests(changeRequests, resultCh, 3, func(resp *http.Response) error {
-		if resp.StatusCode != 200 {
-			return errors.New("Status code is not 200")
-		}
-		return nil
-	})
-
-	// return error if sending changes failed
-	if err := <-resultCh; err != nil {
-		return err
+// Push sends the changes that haven't been pushed. Changes that fail to send stay queued
+// and are returned in a *PushError
+func (hal *HummingbirdAnimeList) Push() error {
+	if hal.logErr != nil {
+		return fmt.Errorf("Changes could not be written to the change log: %v", hal.logErr)
 	}
 
-	hal.pastChanges = append(hal.pastChanges, mergedChanges...)
-	hal.changes = []Change{}
-	return nil
+	// changes that can't be planned fail on their own instead of failing the push
+	return hal.push(hal, MergeChanges(hal.changes, Hummingbird))
 }
 
+// Undo undoes the last change if it hasn't been pushed, otherwise it undoes the last push
 func (hal *HummingbirdAnimeList) Undo() error {
-	if len(hal.changes) <= 0 {
-		return errors.New("Cannot undo from empty changelist")
-	}
+	return hal.undo(hal)
+}
 
-	change := hal.changes[len(hal.changes)-1]
-	undoRequest, err := hal.GenerateChange(change, true)
-	if err != nil {
-		return err
-	}
+// Redo redoes the last undone change or push
+func (hal *HummingbirdAnimeList) Redo() error {
+	return hal.redo(hal)
+}
 
-	client := &http.Client{}
-	if _, err := client.Do(undoRequest); err != nil {
-		return err
+// sendChanges sends the requests that apply the changes, or undo them if undo is true
+func (hal *HummingbirdAnimeList) sendChanges(changes []Change, undo ...bool) ([]RequestResult, error) {
+	undoChanges := len(undo) > 0 && undo[0]
+
+	newRequest := func(change Change) (*http.Request, error) {
+		plannedRequest, err := hal.planChange(change, undoChanges)
+		if err != nil {
+			return nil, err
+		}
+		return hal.newRequest(plannedRequest)
 	}
 
-	hal.changes = hal.changes[:len(hal.changes)-1]
-	return nil
+	ctx, cancel := context.WithTimeout(context.Background(), hal.options.timeout())
+	defer cancel()
+	results := sendChangeRequests(ctx, changes, newRequest, SendOptions{
+		Client:   hal.options.httpClient(),
+		Retry:    hal.options.retryPolicy(),
+		Throttle: hal.options.throttle(Hummingbird),
+		HandleResponse: func(resp *http.Response) error {
+			if resp.StatusCode != 200 {
+				return fmt.Errorf("Status code is %d", resp.StatusCode)
+			}
+			return nil
+		},
+	})
+	return results, nil
 }
 
 // GenerateChange returns a HTTP request that applies the change
 func (hal *HummingbirdAnimeList) GenerateChange(change Change, undo ...bool) (*http.Request, error) {
 	undoForm := len(undo) > 0 && undo[0]
+	plannedRequest, err := hal.planChange(change, undoForm)
+	if err != nil {
+		return nil, err
+	}
+	return hal.newRequest(plannedRequest)
+}
 
+// planChange returns the request that applies the change without creating it
+func (hal *HummingbirdAnimeList) planChange(change Change, undo bool) (PlannedRequest, error) {
 	form := url.Values{}
-	form.Add("auth_token", hal.AuthToken())
+	// the auth token is filled in when the request is created so it isn't in dry runs
+	form.Add("auth_token", RedactedCredential)
 
-	change.FillForm(Hummingbird, &form, undoForm)
-	return http.NewRequest("POST", change.URL(Hummingbird, undoForm), strings.NewReader(form.Encode()))
+	if err := change.FillForm(Hummingbird, &form, undo); err != nil {
+		return PlannedRequest{}, err
+	}
+	changeURL, err := change.URL(Hummingbird, undo)
+	if err != nil {
+		return PlannedRequest{}, err
+	}
+	return PlannedRequest{
+		Change: change,
+		Undo:   undo,
+		Method: "POST",
+		URL:    hal.options.url(changeURL, HummingbirdBaseURL),
+		Form:   form,
+	}, nil
+}
+
+// newRequest creates the HTTP request for a planned request
+func (hal *HummingbirdAnimeList) newRequest(plannedRequest PlannedRequest) (*http.Request, error) {
+	authToken, err := hal.AuthToken()
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{}
+	for key, values := range plannedRequest.Form {
+		form[key] = values
+	}
+	form.Set("auth_token", authToken.Secret())
+	plannedRequest.Form = form
+
+	request, err := plannedRequest.NewRequest()
+	if err != nil {
+		return nil, err
+	}
+	hal.options.prepare(request)
+	return request, nil
 }
 
 // DiffHummingbirdLists creates a list of changes from diffing two Hummingbird anime lists
 func DiffHummingbirdLists(oldList *HummingbirdAnimeList, newList *HummingbirdAnimeList) []Change {
-	var changes []Change
-	for id, oldAnime := range oldList.anime {
-		if newAnime, ok := newList.anime[id]; ok {
-			if !reflect.DeepEqual(newAnime, oldAnime) {
-				changes = append(changes, EditChange{OldAnime: oldAnime, NewAnime: newAnime})
-			}
-		} else {
-			changes = append(changes, DeleteChange{Anime: oldAnime})
-		}
+	oldAnime, newAnime := make(map[int]Anime), make(map[int]Anime)
+	for id, anime := range oldList.anime {
+		oldAnime[id] = anime
 	}
 	for id, anime := range newList.anime {
-		if _, ok := oldList.anime[id]; !ok {
-			changes = append(changes, AddChange{Anime: anime})
-		}
+		newAnime[id] = anime
 	}
-	return changes
+	return diffAnimeMaps(oldAnime, newAnime)
 }
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"reflect"
+	"sync"
 	"testing"
 )
 
@@ -125,6 +129,30 @@ var diffHummingbirdTests = []struct {
 	},
 }
 
+var hummingbirdRatingTests = []struct {
+	rating HummingbirdRating
+	score  int
+}{
+	{HummingbirdRating{}, 0},
+	{HummingbirdRating{Type: "advanced", Value: "0.5"}, 1},
+	{HummingbirdRating{Type: "advanced", Value: "3.5"}, 7},
+	{HummingbirdRating{Type: "advanced", Value: "5"}, 10},
+}
+
+func TestHummingbirdRating_Score(t *testing.T) {
+	for _, test := range hummingbirdRatingTests {
+		if score := test.rating.Score(); score != test.score {
+			t.Errorf("TestHummingbirdRating_Score failed: want %d got %d", test.score, score)
+		}
+		if rating := HummingbirdRatingFromScore(test.score); rating != test.rating {
+			t.Errorf("TestHummingbirdRating_Score failed: want %+v got %+v", test.rating, rating)
+		}
+	}
+	if score := (HummingbirdRating{Type: "simple", Value: "positive"}).Score(); score != 0 {
+		t.Errorf("TestHummingbirdRating_Score failed: expected a rating that isn't a number to have no score got %d", score)
+	}
+}
+
 func TestDiffHummingbirdLists(t *testing.T) {
 	for _, test := range diffHummingbirdTests {
 		changes := DiffHummingbirdLists(test.oldList, test.newList)
@@ -144,27 +172,62 @@ func TestDiffHummingbirdLists(t *testing.T) {
 	}
 }
 
+const testHummingbirdLibraryJSON = `[
+	{"episodes_watched": 3, "status": "currently-watching", "rewatched_times": 0, "rewatching": false,
+		"anime": {"id": 1, "mal_id": 101, "title": "Cowboy Bebop", "episode_count": 26}},
+	{"episodes_watched": 12, "status": "completed", "rewatched_times": 1, "rewatching": false,
+		"anime": {"id": 2, "mal_id": 102, "title": "Haibane Renmei", "episode_count": 13}}
+]`
+
+// testHummingbirdRequest is a request to change a library entry received by a test server
+type testHummingbirdRequest struct {
+	path      string
+	form      url.Values
+	userAgent string
+}
+
+// newTestHummingbirdServer returns a server that responds to library requests for darin_minamoto
+// with the body and records the requests to change library entries
+func newTestHummingbirdServer(body string) (*httptest.Server, func() []testHummingbirdRequest) {
+	var mutex sync.Mutex
+	var requests []testHummingbirdRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			if r.URL.Path != "/api/v1/users/darin_minamoto/library" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write([]byte(body))
+			return
+		}
+
+		r.ParseForm()
+		mutex.Lock()
+		requests = append(requests, testHummingbirdRequest{path: r.URL.Path, form: r.PostForm, userAgent: r.UserAgent()})
+		mutex.Unlock()
+	}))
+
+	return server, func() []testHummingbirdRequest {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]testHummingbirdRequest(nil), requests...)
+	}
+}
+
 func TestHummingbirdAnimeList_FetchFromEmpty(t *testing.T) {
-	list := NewHummingbirdAnimeList("darin_minamoto", "")
+	server, _ := newTestHummingbirdServer(testHummingbirdLibraryJSON)
+	defer server.Close()
+
+	list := NewHummingbirdAnimeList("darin_minamoto", "", ListOptions{BaseURL: server.URL})
 	if err := list.Fetch(); err != nil {
-		t.Errorf("TestHummingbirdAnimeList_FetchFromEmpty failed: " + err.Error())
+		t.Errorf("TestHummingbirdAnimeList_FetchFromEmpty failed: %v", err)
 	}
-	if len(list.changes) <= 0 {
-		t.Errorf("TestHummingbirdAnimeList_FetchFromEmpty failed: list changes haven't been populated")
+	if len(list.anime) != 2 || list.anime[1].EpisodesWatched() != 3 {
+		t.Errorf("TestHummingbirdAnimeList_FetchFromEmpty failed: anime haven't been populated %+v", list.anime)
 	}
-	if len(list.anime) <= 0 {
-		t.Errorf("TestHummingbirdAnimeList_FetchFromEmpty failed: anime changes haven't been populated")
-	}
-
-	for _, change := range list.changes {
-		switch ch := change.(type) {
-		case AddChange:
-			if ch.Anime.ID().Get(Hummingbird) == 0 {
-				t.Errorf("TestHummingbirdAnimeList_FetchFromEmpty failed: anime id should not be zero")
-			}
-		default:
-			t.Errorf("TestHummingbirdAnimeList_FetchFromEmpty failed: there should only be Add changes")
-		}
+	// the fetched anime are already on the site so they aren't changes to push
+	if len(list.changes) != 0 {
+		t.Errorf("TestHummingbirdAnimeList_FetchFromEmpty failed: want no changes got %+v", list.changes)
 	}
 }
 
@@ -249,3 +312,40 @@ func TestHummingbirdAnimeList_RemoveHummingbirdAnime(t *testing.T) {
 			DeleteChange{Anime: anime})
 	}
 }
+
+func TestHummingbirdAnimeList_FetchEditPush(t *testing.T) {
+	server, requests := newTestHummingbirdServer(testHummingbirdLibraryJSON)
+	defer server.Close()
+
+	list := NewHummingbirdAnimeList("darin_minamoto", "token", ListOptions{
+		BaseURL:   server.URL,
+		Client:    server.Client(),
+		UserAgent: "myhumminglist-test",
+	})
+	if err := list.Fetch(); err != nil {
+		t.Fatalf("TestHummingbirdAnimeList_FetchEditPush failed: %v", err)
+	}
+	anime, _ := list.Get(1)
+	edited := anime.(HummingbirdAnime)
+	edited.NumEpisodesWatched = 4
+	list.Edit(edited)
+
+	if err := list.Push(); err != nil {
+		t.Fatalf("TestHummingbirdAnimeList_FetchEditPush failed: %v", err)
+	}
+
+	received := requests()
+	if len(received) != 1 {
+		t.Fatalf("TestHummingbirdAnimeList_FetchEditPush failed: expected 1 request got %+v", received)
+	}
+	request := received[0]
+	if request.path != "/api/v1/libraries/1" || request.userAgent != "myhumminglist-test" {
+		t.Errorf("TestHummingbirdAnimeList_FetchEditPush failed: unexpected request %+v", request)
+	}
+	if request.form.Get("auth_token") != "token" || request.form.Get("episodes_watched") != "4" {
+		t.Errorf("TestHummingbirdAnimeList_FetchEditPush failed: unexpected form %v", request.form)
+	}
+	if len(list.changes) != 0 || len(list.pastChanges) != 1 {
+		t.Errorf("TestHummingbirdAnimeList_FetchEditPush failed: expected the change to be pushed")
+	}
+}
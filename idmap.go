@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// IDSource is where an ID mapping came from. Mappings from a source can't be
+// replaced by conflicting mappings from a lower source
+type IDSource int
+
+const (
+	// IDSourceSeed is a mapping from an offline dump
+	IDSourceSeed IDSource = iota
+	// IDSourceFetch is a mapping learned from a fetched library
+	IDSourceFetch IDSource = iota
+	// IDSourceManual is a mapping made by hand
+	IDSourceManual IDSource = iota
+)
+
+var idSourceNames = []string{"seed", "fetch", "manual"}
+
+func (s IDSource) String() string {
+	if s < 0 || int(s) >= len(idSourceNames) {
+		return fmt.Sprintf("unknown source %d", int(s))
+	}
+	return idSourceNames[s]
+}
+
+func (s IDSource) MarshalText() ([]byte, error) {
+	if s < 0 || int(s) >= len(idSourceNames) {
+		return nil, fmt.Errorf("Unknown ID source %d", int(s))
+	}
+	return []byte(s.String()), nil
+}
+
+func (s *IDSource) UnmarshalText(text []byte) error {
+	for i, name := range idSourceNames {
+		if string(text) == name {
+			*s = IDSource(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("Unknown ID source %q", text)
+}
+
+// IDMapping is the IDs of an anime on every list
+type IDMapping struct {
+	ID     AnimeID
+	Source IDSource
+}
+
+// idMapLists are the list types that the ID map has the IDs of
+var idMapLists = []int{Hummingbird, MyAnimeList}
+
+// IDMap maps the IDs of anime between the lists for anime whose entries
+// don't have the IDs of the other lists, like Hummingbird entries without a MAL ID
+type IDMap struct {
+	// mappings are keyed by list type and then by the ID on that list
+	mappings map[int]map[int]*IDMapping
+}
+
+func NewIDMap() *IDMap {
+	m := &IDMap{mappings: make(map[int]map[int]*IDMapping)}
+	for _, listType := range idMapLists {
+		m.mappings[listType] = make(map[int]*IDMapping)
+	}
+	return m
+}
+
+// Set maps the IDs to each other and returns true if the mapping was stored. Mappings that
+// conflict with it are removed, unless one of them is from a higher source.
+// A mapping without an ID for every list isn't stored
+func (m *IDMap) Set(id AnimeID, source IDSource) bool {
+	for _, listType := range idMapLists {
+		if id.Get(listType) == 0 {
+			return false
+		}
+	}
+
+	var conflicts []*IDMapping
+	for _, listType := range idMapLists {
+		existing, ok := m.mappings[listType][id.Get(listType)]
+		if !ok {
+			continue
+		}
+
+		if existing.ID == id {
+			if source > existing.Source {
+				existing.Source = source
+			}
+			return true
+		} else if existing.Source > source {
+			return false
+		}
+		conflicts = append(conflicts, existing)
+	}
+
+	for _, conflict := range conflicts {
+		m.remove(conflict)
+	}
+	mapping := &IDMapping{ID: id, Source: source}
+	for _, listType := range idMapLists {
+		m.mappings[listType][id.Get(listType)] = mapping
+	}
+	return true
+}
+
+// Remove removes the mapping of the anime with the ID on the list type and returns true if there was one
+func (m *IDMap) Remove(listType int, id int) bool {
+	mapping, ok := m.mappings[listType][id]
+	if ok {
+		m.remove(mapping)
+	}
+	return ok
+}
+
+func (m *IDMap) remove(mapping *IDMapping) {
+	for _, listType := range idMapLists {
+		delete(m.mappings[listType], mapping.ID.Get(listType))
+	}
+}
+
+// Lookup returns the IDs of the anime with the ID on the list type
+func (m *IDMap) Lookup(listType int, id int) (AnimeID, bool) {
+	mapping, ok := m.mappings[listType][id]
+	if !ok {
+		return AnimeID{}, false
+	}
+	return mapping.ID, true
+}
+
+// Complete returns the ID with the IDs that are missing filled in from the map
+func (m *IDMap) Complete(id AnimeID) AnimeID {
+	for _, listType := range idMapLists {
+		if id.Get(listType) == 0 {
+			continue
+		}
+		if mapped, ok := m.Lookup(listType, id.Get(listType)); ok {
+			return mergeIDs(id, mapped)
+		}
+	}
+	return id
+}
+
+// Learn maps the IDs of the anime that have an ID for every list, like the anime
+// fetched from Hummingbird with a MAL ID, and returns the number of new mappings
+func (m *IDMap) Learn(anime []Anime) int {
+	learned := 0
+	for _, a := range anime {
+		if mapped, ok := m.Lookup(Hummingbird, a.ID().Hummingbird); ok && mapped == a.ID() {
+			continue
+		}
+		if m.Set(a.ID(), IDSourceFetch) {
+			learned++
+		}
+	}
+	return learned
+}
+
+// Mappings returns the mappings ordered by Hummingbird ID
+func (m *IDMap) Mappings() []IDMapping {
+	mappings := make([]IDMapping, 0, len(m.mappings[Hummingbird]))
+	for _, mapping := range m.mappings[Hummingbird] {
+		mappings = append(mappings, *mapping)
+	}
+	sort.Slice(mappings, func(i, j int) bool {
+		return mappings[i].ID.Hummingbird < mappings[j].ID.Hummingbird
+	})
+	return mappings
+}
+
+// idMapFile is the JSON format of an ID map file
+type idMapFile struct {
+	Version  int             `json:"version"`
+	Mappings []idMappingJSON `json:"mappings"`
+}
+
+type idMappingJSON struct {
+	Hummingbird int      `json:"hummingbird"`
+	MyAnimeList int      `json:"myanimelist"`
+	Source      IDSource `json:"source"`
+}
+
+// LoadIDMap reads the ID map file at the path. A file that doesn't exist is an empty map
+func LoadIDMap(path string) (*IDMap, error) {
+	m := NewIDMap()
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return m, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var file idMapFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("Error reading the ID map %s: %v", path, err)
+	}
+	if file.Version != 1 {
+		return nil, fmt.Errorf("Unsupported ID map version %d in %s", file.Version, path)
+	}
+	for _, mapping := range file.Mappings {
+		m.Set(AnimeID{Hummingbird: mapping.Hummingbird, MyAnimeList: mapping.MyAnimeList}, mapping.Source)
+	}
+	return m, nil
+}
+
+// Save writes the ID map to the file at the path
+func (m *IDMap) Save(path string) error {
+	file := idMapFile{Version: 1, Mappings: []idMappingJSON{}}
+	for _, mapping := range m.Mappings() {
+		file.Mappings = append(file.Mappings, idMappingJSON{
+			Hummingbird: mapping.ID.Hummingbird,
+			MyAnimeList: mapping.ID.MyAnimeList,
+			Source:      mapping.Source,
+		})
+	}
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(data, '\n'))
+}
+
+// idSeedPrefixes are the prefixes of the source URLs of the anime in an offline dump for each list.
+// Kitsu is the new name of Hummingbird and kept its IDs
+var idSeedPrefixes = map[int][]string{
+	Hummingbird: {"https://kitsu.io/anime/", "https://kitsu.app/anime/", "https://hummingbird.me/anime/"},
+	MyAnimeList: {"https://myanimelist.net/anime/"},
+}
+
+// Seed maps the IDs of the anime in an offline dump in the format of the
+// anime-offline-database, which lists the URLs of each anime on the sites, like
+//
+//	{"data": [{"title": "Death Note", "sources": ["https://kitsu.io/anime/1376", "https://myanimelist.net/anime/1535"]}]}
+//
+// Seeded mappings don't replace mappings from fetches or made by hand.
+// It returns the number of mappings that were stored
+func (m *IDMap) Seed(r io.Reader) (int, error) {
+	var dump struct {
+		Data []struct {
+			Sources []string `json:"sources"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(r).Decode(&dump); err != nil {
+		return 0, fmt.Errorf("Error reading the offline dump: %v", err)
+	}
+
+	seeded := 0
+	for _, anime := range dump.Data {
+		var id AnimeID
+		for _, source := range anime.Sources {
+			switch listType, sourceID := parseIDSource(source); listType {
+			case Hummingbird:
+				id.Hummingbird = sourceID
+			case MyAnimeList:
+				id.MyAnimeList = sourceID
+			}
+		}
+		if m.Set(id, IDSourceSeed) {
+			seeded++
+		}
+	}
+	return seeded, nil
+}
+
+// parseIDSource returns the list type and ID of the source URL of an anime in an offline dump,
+// or a list type of -1 if the URL isn't of a list
+func parseIDSource(source string) (int, int) {
+	for listType, prefixes := range idSeedPrefixes {
+		for _, prefix := range prefixes {
+			if !strings.HasPrefix(source, prefix) {
+				continue
+			}
+			if id, err := strconv.Atoi(strings.TrimPrefix(source, prefix)); err == nil && id > 0 {
+				return listType, id
+			}
+		}
+	}
+	return -1, 0
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var idMapSetTests = []struct {
+	id       AnimeID
+	source   IDSource
+	stored   bool
+	expected []IDMapping
+}{
+	// a mapping without both IDs isn't stored
+	{AnimeID{Hummingbird: 4}, IDSourceManual, false, nil},
+	// a lower source can't replace a conflicting mapping
+	{AnimeID{1, 201}, IDSourceSeed, false, nil},
+	// the same mapping from a higher source raises its source
+	{AnimeID{2, 102}, IDSourceManual, true, []IDMapping{{AnimeID{1, 101}, IDSourceFetch}, {AnimeID{2, 102}, IDSourceManual}}},
+	// a higher source replaces every mapping it conflicts with
+	{AnimeID{1, 102}, IDSourceManual, true, []IDMapping{{AnimeID{1, 102}, IDSourceManual}}},
+	{AnimeID{3, 103}, IDSourceFetch, true, []IDMapping{{AnimeID{1, 101}, IDSourceFetch}, {AnimeID{2, 102}, IDSourceSeed},
+		{AnimeID{3, 103}, IDSourceFetch}}},
+}
+
+func TestIDMap_Set(t *testing.T) {
+	for _, test := range idMapSetTests {
+		ids := NewIDMap()
+		ids.Set(AnimeID{1, 101}, IDSourceFetch)
+		ids.Set(AnimeID{2, 102}, IDSourceSeed)
+
+		expected := test.expected
+		if expected == nil {
+			expected = []IDMapping{{AnimeID{1, 101}, IDSourceFetch}, {AnimeID{2, 102}, IDSourceSeed}}
+		}
+		if stored := ids.Set(test.id, test.source); stored != test.stored {
+			t.Errorf("TestIDMap_Set failed: %+v: expected stored to be %v", test.id, test.stored)
+		}
+		if mappings := ids.Mappings(); !reflect.DeepEqual(mappings, expected) {
+			t.Errorf("TestIDMap_Set failed: %+v: want %+v got %+v", test.id, expected, mappings)
+		}
+	}
+}
+
+func TestIDMap_CompleteAndLearn(t *testing.T) {
+	ids := NewIDMap()
+	learned := ids.Learn([]Anime{newSyncTestAnime(1, 101, 3), newSyncTestAnime(2, 0, 3), newSyncTestAnime(1, 101, 4)})
+	if learned != 1 {
+		t.Errorf("TestIDMap_CompleteAndLearn failed: expected 1 new mapping got %d", learned)
+	}
+
+	var completeTests = []struct {
+		id       AnimeID
+		expected AnimeID
+	}{
+		{AnimeID{Hummingbird: 1}, AnimeID{1, 101}},
+		{AnimeID{MyAnimeList: 101}, AnimeID{1, 101}},
+		{AnimeID{Hummingbird: 2}, AnimeID{Hummingbird: 2}},
+		// the IDs of the anime win over the map
+		{AnimeID{1, 201}, AnimeID{1, 201}},
+	}
+	for _, test := range completeTests {
+		if id := ids.Complete(test.id); id != test.expected {
+			t.Errorf("TestIDMap_CompleteAndLearn failed: want %+v got %+v", test.expected, id)
+		}
+	}
+
+	if !ids.Remove(MyAnimeList, 101) || ids.Remove(Hummingbird, 1) {
+		t.Errorf("TestIDMap_CompleteAndLearn failed: expected the mapping to be removed once")
+	}
+	if id := ids.Complete(AnimeID{Hummingbird: 1}); id != (AnimeID{Hummingbird: 1}) {
+		t.Errorf("TestIDMap_CompleteAndLearn failed: expected the removed mapping to be gone got %+v", id)
+	}
+}
+
+func TestIDMap_SeedAndSave(t *testing.T) {
+	dump := `{"data": [
+		{"title": "Death Note", "sources": ["https://anidb.net/anime/4563", "https://kitsu.io/anime/1376", "https://myanimelist.net/anime/1535"]},
+		{"title": "Cowboy Bebop", "sources": ["https://kitsu.app/anime/1", "https://myanimelist.net/anime/1"]},
+		{"title": "Only on MAL", "sources": ["https://myanimelist.net/anime/5"]},
+		{"title": "Bad ID", "sources": ["https://kitsu.io/anime/abc", "https://myanimelist.net/anime/6"]}
+	]}`
+	ids := NewIDMap()
+	ids.Set(AnimeID{1, 2}, IDSourceManual)
+	seeded, err := ids.Seed(strings.NewReader(dump))
+	if err != nil || seeded != 1 {
+		t.Fatalf("TestIDMap_SeedAndSave failed: expected 1 seeded mapping got %d %v", seeded, err)
+	}
+	if _, err := ids.Seed(strings.NewReader("[]")); err == nil {
+		t.Errorf("TestIDMap_SeedAndSave failed: expected an error seeding from a bad dump")
+	}
+
+	path := filepath.Join(t.TempDir(), "ids.json")
+	if ids, err := LoadIDMap(path); err != nil || len(ids.Mappings()) != 0 {
+		t.Errorf("TestIDMap_SeedAndSave failed: expected a missing file to be an empty map got %v", err)
+	}
+	if err := ids.Save(path); err != nil {
+		t.Fatalf("TestIDMap_SeedAndSave failed: %v", err)
+	}
+	loaded, err := LoadIDMap(path)
+	if err != nil {
+		t.Fatalf("TestIDMap_SeedAndSave failed: %v", err)
+	}
+	expected := []IDMapping{{AnimeID{1, 2}, IDSourceManual}, {AnimeID{1376, 1535}, IDSourceSeed}}
+	if mappings := loaded.Mappings(); !reflect.DeepEqual(mappings, expected) {
+		t.Errorf("TestIDMap_SeedAndSave failed: want %+v got %+v", expected, mappings)
+	}
+
+	os.WriteFile(path, []byte(`{"version": 2, "mappings": []}`), 0600)
+	if _, err := LoadIDMap(path); err == nil {
+		t.Errorf("TestIDMap_SeedAndSave failed: expected an error loading an unknown version")
+	}
+}
+
+func TestAnimelistManager_SyncWithIDMap(t *testing.T) {
+	primary := NewHummingbirdAnimeList("darin_minamoto", "")
+	replica := NewMyAnimeListAnimeList("darin_minamoto", "")
+	primary.Add(newSyncTestAnime(1, 0, 3))
+	primary.Add(newSyncTestAnime(2, 0, 4))
+	replica.Add(MALAnime{SeriesAnimeDBID: 103, MyStatus: MALStatusWatching, MyWatchedEpisodes: 5})
+
+	ids := NewIDMap()
+	ids.Set(AnimeID{1, 101}, IDSourceManual)
+	ids.Set(AnimeID{3, 103}, IDSourceSeed)
+	manager := NewAnimelistManager(primary, replica)
+	manager.SetIDMap(ids)
+	conflicts, err := manager.Sync()
+	if err != nil || len(conflicts) != 0 {
+		t.Fatalf("TestAnimelistManager_SyncWithIDMap failed: %v %+v", err, conflicts)
+	}
+
+	if anime, err := replica.Get(101); err != nil || anime.EpisodesWatched() != 3 {
+		t.Errorf("TestAnimelistManager_SyncWithIDMap failed: expected the mapped anime to be synced got %+v", replica.Anime())
+	}
+	if anime, err := primary.Get(3); err != nil || anime.EpisodesWatched() != 5 {
+		t.Errorf("TestAnimelistManager_SyncWithIDMap failed: expected the mapped MAL anime to be synced got %+v", primary.Anime())
+	}
+	if replica.Contains(0) || len(replica.Anime()) != 2 {
+		t.Errorf("TestAnimelistManager_SyncWithIDMap failed: expected the unmapped anime to be skipped got %+v", replica.Anime())
+	}
+
+	unmapped := manager.Unmapped()
+	if len(unmapped) != 1 || unmapped[0].List != primary || unmapped[0].ListType != MyAnimeList ||
+		unmapped[0].Anime.ID().Hummingbird != 2 {
+		t.Errorf("TestAnimelistManager_SyncWithIDMap failed: expected anime 2 to be unmapped got %+v", unmapped)
+	}
+
+	// edits through the manager skip the lists that the anime has no ID for
+	manager.Edit(newSyncTestAnime(2, 0, 6))
+	manager.Edit(newSyncTestAnime(1, 0, 7))
+	if anime, _ := replica.Get(101); anime.EpisodesWatched() != 7 || replica.Contains(0) {
+		t.Errorf("TestAnimelistManager_SyncWithIDMap failed: unexpected replica anime %+v", replica.Anime())
+	}
+}
+
+func TestRunCLI_IDs(t *testing.T) {
+	test, cleanup := newCLITest(t)
+	defer cleanup()
+	test.hummingbird.SetEntry("darin_minamoto", newSyncTestAnime(1, 101, 3))
+	test.hummingbird.SetEntry("darin_minamoto", newSyncTestAnime(2, 0, 4))
+	test.myAnimeList.AddAnime(newFakeMALTestAnime(101, 0, 0))
+	test.myAnimeList.AddAnime(newFakeMALTestAnime(102, 0, 0))
+
+	test.run(0, "-list", "hummingbird", "fetch")
+	test.run(0, "-list", "myanimelist", "fetch")
+	if output := test.run(0, "ids"); !strings.Contains(output, "1            101          fetch") {
+		t.Errorf("TestRunCLI_IDs failed: expected the fetched mapping to be learned got %q", output)
+	}
+	if output := test.run(0, "ids", "review"); !strings.Contains(output, "hummingbird  2   MyAnimeList  Sample text") {
+		t.Errorf("TestRunCLI_IDs failed: expected anime 2 to be unmapped got %q", output)
+	}
+
+	test.run(0, "sync", "-push")
+	if library := test.myAnimeList.Library("darin_minamoto"); len(library) != 1 {
+		t.Errorf("TestRunCLI_IDs failed: expected only the mapped anime to be synced got %+v", library)
+	}
+
+	test.run(0, "ids", "set", "2", "102")
+	test.run(0, "sync", "-push")
+	if library := test.myAnimeList.Library("darin_minamoto"); len(library) != 2 || library[1].MyWatchedEpisodes != 4 {
+		t.Errorf("TestRunCLI_IDs failed: expected the anime mapped by hand to be synced got %+v", library)
+	}
+	if output := test.run(0, "ids", "review"); strings.Contains(output, "Sample text") {
+		t.Errorf("TestRunCLI_IDs failed: expected no unmapped anime got %q", output)
+	}
+
+	test.run(0, "ids", "remove", "2")
+	test.run(1, "ids", "remove", "2")
+	test.run(1, "ids", "set", "2")
+	test.run(1, "ids", "unknown")
+}
+
+func TestAnimelistManager_UnmappedPairedByCounterpart(t *testing.T) {
+	primary := NewHummingbirdAnimeList("darin_minamoto", "")
+	replica := NewMyAnimeListAnimeList("darin_minamoto", "")
+	primary.Add(newSyncTestAnime(1, 101, 3))
+	// MAL entries never have a Hummingbird ID, but anime 101 is paired by the MAL ID of Hummingbird anime 1
+	replica.Add(MALAnime{SeriesAnimeDBID: 101, MyStatus: MALStatusWatching})
+	replica.Add(MALAnime{SeriesAnimeDBID: 102, MyStatus: MALStatusWatching})
+
+	manager := NewAnimelistManager(primary, replica)
+	manager.SetIDMap(NewIDMap())
+	unmapped := manager.Unmapped()
+	if len(unmapped) != 1 || unmapped[0].List != replica || unmapped[0].Anime.ID().MyAnimeList != 102 {
+		t.Errorf("TestAnimelistManager_UnmappedPairedByCounterpart failed: expected only anime 102 to be unmapped got %+v", unmapped)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ListOptions configures how an anime list talks to its site
+type ListOptions struct {
+	// BaseURL replaces the scheme and host of the site's URLs, like "http://localhost:8080"
+	BaseURL string
+	// Client sends the requests of the list, http.DefaultClient is used if it is nil
+	Client *http.Client
+	// Transport sends the requests of the list if Client is nil
+	Transport http.RoundTripper
+	// UserAgent is the User-Agent header of the requests of the list if it is set
+	UserAgent string
+	// Retry is the retry policy of the requests of the list, DefaultRetryPolicy is used if it is nil
+	Retry *RetryPolicy
+	// Throttle limits the requests of the list, the shared throttle of the list type is used if it is nil
+	Throttle *Throttle
+	// Timeout is how long a fetch, push or undo waits for its requests, DefaultRequestTimeout is used if it is 0
+	Timeout time.Duration
+	// Credentials provides the auth token or password of the list if it isn't passed to the constructor.
+	// It is only asked when the first request that needs the credential is created
+	Credentials CredentialProvider
+}
+
+// listOptions returns the options passed to a list constructor
+func listOptions(options []ListOptions) ListOptions {
+	if len(options) > 0 {
+		return options[0]
+	}
+	return ListOptions{}
+}
+
+// httpClient returns the client that sends the requests of the list
+func (o ListOptions) httpClient() *http.Client {
+	if o.Client != nil {
+		return o.Client
+	}
+	if o.Transport != nil {
+		return &http.Client{Transport: o.Transport}
+	}
+	return http.DefaultClient
+}
+
+// retryPolicy returns the retry policy of the requests of the list
+func (o ListOptions) retryPolicy() *RetryPolicy {
+	if o.Retry != nil {
+		return o.Retry
+	}
+	return &DefaultRetryPolicy
+}
+
+// throttle returns the throttle that limits the requests of the list
+func (o ListOptions) throttle(listType int) *Throttle {
+	if o.Throttle != nil {
+		return o.Throttle
+	}
+	return ListThrottle(listType)
+}
+
+// timeout returns how long the list waits for the requests of a fetch, push or undo
+func (o ListOptions) timeout() time.Duration {
+	if o.Timeout > 0 {
+		return o.Timeout
+	}
+	return DefaultRequestTimeout
+}
+
+// credential returns the credential if it is set or asks the credential provider of the options for it
+func (o ListOptions) credential(credential Credential, listType int, username string) (Credential, error) {
+	if credential != "" || o.Credentials == nil {
+		return credential, nil
+	}
+	return o.Credentials.Credential(credentialService(listType), username)
+}
+
+// url replaces the default base URL of the site in the URL with the base URL of the options
+func (o ListOptions) url(rawURL string, defaultBaseURL string) string {
+	if o.BaseURL == "" || !strings.HasPrefix(rawURL, defaultBaseURL) {
+		return rawURL
+	}
+	return strings.TrimSuffix(o.BaseURL, "/") + strings.TrimPrefix(rawURL, defaultBaseURL)
+}
+
+// prepare sets the headers of a request that are set by the options
+func (o ListOptions) prepare(request *http.Request) {
+	if o.UserAgent != "" {
+		request.Header.Set("User-Agent", o.UserAgent)
+	}
+}
+
+// fetch sends a GET request for the URL of a list's library and decodes the response.
+// The request is throttled and retried like the requests that push changes
+func (o ListOptions) fetch(listType int, libraryURL string, decode func(*http.Response) error) error {
+	request, err := http.NewRequest("GET", libraryURL, nil)
+	if err != nil {
+		return err
+	}
+	o.prepare(request)
+
+	ctx, cancel := context.WithTimeout(context.Background(), o.timeout())
+	defer cancel()
+	results := SendRequests(ctx, []*http.Request{request}, SendOptions{
+		Client:   o.httpClient(),
+		Retry:    o.retryPolicy(),
+		Throttle: o.throttle(listType),
+		HandleResponse: func(resp *http.Response) error {
+			if resp.StatusCode != 200 {
+				return fmt.Errorf("Status code for response is %d", resp.StatusCode)
+			}
+			return decode(resp)
+		},
+	})
+	return results[0].Err
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+var listOptionsURLTests = []struct {
+	baseURL  string
+	rawURL   string
+	expected string
+}{
+	{"", "https://hummingbird.me/api/v1/libraries/1", "https://hummingbird.me/api/v1/libraries/1"},
+	{"http://127.0.0.1:8080", "https://hummingbird.me/api/v1/libraries/1", "http://127.0.0.1:8080/api/v1/libraries/1"},
+	{"http://127.0.0.1:8080/", "https://hummingbird.me/api/v1/libraries/1", "http://127.0.0.1:8080/api/v1/libraries/1"},
+	{"http://127.0.0.1:8080", "https://myanimelist.net/api/animelist/add/1.xml", "https://myanimelist.net/api/animelist/add/1.xml"},
+}
+
+func TestListOptions_URL(t *testing.T) {
+	for _, test := range listOptionsURLTests {
+		options := ListOptions{BaseURL: test.baseURL}
+		if url := options.url(test.rawURL, HummingbirdBaseURL); url != test.expected {
+			t.Errorf("TestListOptions_URL failed: want %s got %s", test.expected, url)
+		}
+	}
+}
+
+// recordingTransport responds to every request with 200 and records the requests
+type recordingTransport struct {
+	mutex    sync.Mutex
+	requests []*http.Request
+}
+
+func (rt *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt.mutex.Lock()
+	rt.requests = append(rt.requests, req)
+	rt.mutex.Unlock()
+
+	return &http.Response{
+		StatusCode: 200,
+		Header:     http.Header{},
+		Body:       ioutil.NopCloser(strings.NewReader("Created")),
+		Request:    req,
+	}, nil
+}
+
+func TestListOptions_Transport(t *testing.T) {
+	transport := &recordingTransport{}
+	list := NewMyAnimeListAnimeList("darin_minamoto", "password", ListOptions{
+		BaseURL:   "http://mal.test",
+		Transport: transport,
+		UserAgent: "myhumminglist-test",
+	})
+	list.Add(AnimeToMAL(newSyncTestAnime(1, 101, 3)))
+
+	if err := list.Push(); err != nil {
+		t.Fatalf("TestListOptions_Transport failed: %v", err)
+	}
+	if len(transport.requests) != 1 {
+		t.Fatalf("TestListOptions_Transport failed: expected 1 request got %d", len(transport.requests))
+	}
+
+	request := transport.requests[0]
+	username, password, ok := request.BasicAuth()
+	if request.URL.String() != "http://mal.test/api/animelist/add/101.xml" || !ok ||
+		username != "darin_minamoto" || password != "password" {
+		t.Errorf("TestListOptions_Transport failed: unexpected request %s", request.URL)
+	}
+	if request.UserAgent() != "myhumminglist-test" {
+		t.Errorf("TestListOptions_Transport failed: want user agent myhumminglist-test got %s", request.UserAgent())
+	}
+}
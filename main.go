@@ -1,7 +1,7 @@
 package main
 
-import "fmt"
+import "os"
 
 func main() {
-	fmt.Println("Hello world!")
+	os.Exit(runCLI(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
 }
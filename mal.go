@@ -1,9 +1,591 @@
 package main
 
 import (
-	_ "encoding/xml"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
 )
 
-// MALAnime represents the JSON data of a MAL anime
+const (
+	MyAnimeListBaseURL    = "https://myanimelist.net"
+	MyAnimeListAddURL     = MyAnimeListBaseURL + "/api/animelist/add/%d.xml"
+	MyAnimeListEditURL    = MyAnimeListBaseURL + "/api/animelist/update/%d.xml"
+	MyAnimeListDeleteURL  = MyAnimeListBaseURL + "/api/animelist/delete/%d.xml"
+	MyAnimeListLibraryURL = MyAnimeListBaseURL + "/malappinfo.php?u=%s&status=all&type=anime"
+)
+
+const (
+	MALStatusWatching    = 1
+	MALStatusCompleted   = 2
+	MALStatusOnHold      = 3
+	MALStatusDropped     = 4
+	MALStatusPlanToWatch = 6
+)
+
+// MALAnime represents the XML data of a MAL anime list entry
 type MALAnime struct {
+	SeriesAnimeDBID   int    `xml:"series_animedb_id" json:"series_animedb_id"`
+	SeriesTitle       string `xml:"series_title" json:"series_title"`
+	SeriesEpisodes    int    `xml:"series_episodes" json:"series_episodes"`
+	MyWatchedEpisodes int    `xml:"my_watched_episodes" json:"my_watched_episodes"`
+	MyStartDate       string `xml:"my_start_date" json:"my_start_date"`
+	MyFinishDate      string `xml:"my_finish_date" json:"my_finish_date"`
+	MyScore           int    `xml:"my_score" json:"my_score"`
+	MyStatus          int    `xml:"my_status" json:"my_status"`
+	MyRewatching      int    `xml:"my_rewatching" json:"my_rewatching"`
+	MyRewatchingEp    int    `xml:"my_rewatching_ep" json:"my_rewatching_ep"`
+	MyTimesRewatched  int    `xml:"my_times_rewatched" json:"my_times_rewatched"`
+	MyLastUpdated     int64  `xml:"my_last_updated" json:"my_last_updated"`
+	MyComments        string `xml:"my_comments" json:"my_comments"`
+
+	// HummingbirdID is not part of the MAL data but is kept so that
+	// converting a Hummingbird anime to a MAL anime doesn't lose its ID
+	HummingbirdID int `xml:"-" json:"hummingbird_id"`
+}
+
+func (ma MALAnime) ID() AnimeID {
+	return AnimeID{
+		Hummingbird: ma.HummingbirdID,
+		MyAnimeList: ma.SeriesAnimeDBID,
+	}
+}
+
+func (ma MALAnime) Title() string {
+	return ma.SeriesTitle
+}
+
+// Status returns the status of the anime, StatusUnknown if the MAL status isn't known
+func (ma MALAnime) Status() int {
+	status, _ := ParseMALStatus(ma.MyStatus)
+	return status
+}
+
+// ParseMALStatus returns the status of a MAL status
+func ParseMALStatus(status int) (int, error) {
+	switch status {
+	case MALStatusWatching:
+		return StatusWatching, nil
+	case MALStatusCompleted:
+		return StatusCompleted, nil
+	case MALStatusOnHold:
+		return StatusOnHold, nil
+	case MALStatusDropped:
+		return StatusDropped, nil
+	case MALStatusPlanToWatch:
+		return StatusPlanToWatch, nil
+	default:
+		return StatusUnknown, &StatusError{Status: strconv.Itoa(status)}
+	}
+}
+
+func (ma MALAnime) EpisodesWatched() int {
+	return ma.MyWatchedEpisodes
+}
+
+func (ma MALAnime) RewatchedTimes() int {
+	return ma.MyTimesRewatched
+}
+
+func (ma MALAnime) Rewatching() bool {
+	return ma.MyRewatching != 0
+}
+
+func (ma MALAnime) UpdatedAt() time.Time {
+	if ma.MyLastUpdated == 0 {
+		return time.Time{}
+	}
+	return time.Unix(ma.MyLastUpdated, 0).UTC()
+}
+
+func (ma MALAnime) Score() int {
+	return ma.MyScore
+}
+
+func (ma MALAnime) Notes() string {
+	return ma.MyComments
+}
+
+// Private returns false because MAL doesn't keep private entries
+func (ma MALAnime) Private() bool {
+	return false
+}
+
+func (ma MALAnime) StartDate() time.Time {
+	return parseMALDate(ma.MyStartDate)
+}
+
+func (ma MALAnime) FinishDate() time.Time {
+	return parseMALDate(ma.MyFinishDate)
+}
+
+func (ma MALAnime) RewatchEpisode() int {
+	return ma.MyRewatchingEp
+}
+
+// malDateLayout is the layout of the dates in MAL anime lists
+const malDateLayout = "2006-01-02"
+
+// parseMALDate parses a date of a MAL anime list, returning the zero time
+// if the date isn't known, which MAL writes as 0000-00-00
+func parseMALDate(date string) time.Time {
+	t, err := time.Parse(malDateLayout, date)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// formatMALDate formats a date for a MAL anime list, or returns an empty string if it isn't known
+func formatMALDate(date time.Time) string {
+	if date.IsZero() {
+		return ""
+	}
+	return date.UTC().Format(malDateLayout)
+}
+
+// formatMALEntryDate formats a date for an entry sent to the MAL api
+func formatMALEntryDate(date time.Time) string {
+	if date.IsZero() {
+		return "00000000"
+	}
+	return date.UTC().Format("01022006")
+}
+
+// StatusToMALStatus returns the MAL status of a status
+func StatusToMALStatus(status int) (int, error) {
+	switch status {
+	case StatusWatching:
+		return MALStatusWatching, nil
+	case StatusCompleted:
+		return MALStatusCompleted, nil
+	case StatusOnHold:
+		return MALStatusOnHold, nil
+	case StatusDropped:
+		return MALStatusDropped, nil
+	case StatusPlanToWatch:
+		return MALStatusPlanToWatch, nil
+	default:
+		return 0, &StatusError{Status: strconv.Itoa(status)}
+	}
+}
+
+// AnimeToMAL converts an anime to a MAL anime. An unknown status is converted to a MAL status of 0
+func AnimeToMAL(anime Anime) MALAnime {
+	if ma, ok := anime.(MALAnime); ok {
+		// converting would lose the fields that aren't in Anime like SeriesEpisodes
+		return ma
+	}
+	rewatching := 0
+	if anime.Rewatching() {
+		rewatching = 1
+	}
+	status, _ := StatusToMALStatus(anime.Status())
+	var lastUpdated int64
+	if !anime.UpdatedAt().IsZero() {
+		lastUpdated = anime.UpdatedAt().Unix()
+	}
+
+	return MALAnime{
+		SeriesAnimeDBID:   anime.ID().Get(MyAnimeList),
+		SeriesTitle:       anime.Title(),
+		MyWatchedEpisodes: anime.EpisodesWatched(),
+		MyStatus:          status,
+		MyRewatching:      rewatching,
+		MyTimesRewatched:  anime.RewatchedTimes(),
+		MyLastUpdated:     lastUpdated,
+		MyScore:           anime.Score(),
+		MyStartDate:       formatMALDate(anime.StartDate()),
+		MyFinishDate:      formatMALDate(anime.FinishDate()),
+		MyRewatchingEp:    anime.RewatchEpisode(),
+		MyComments:        anime.Notes(),
+		HummingbirdID:     anime.ID().Get(Hummingbird),
+	}
+}
+
+// MALEntry represents the XML data sent to the MAL api when adding or updating an anime.
+// Fields that are nil are left out so that updates only send the changed values.
+// Dates are formatted as MMDDYYYY with 00000000 for a date that isn't known
+type MALEntry struct {
+	XMLName          xml.Name `xml:"entry"`
+	Episode          *int     `xml:"episode,omitempty"`
+	Status           *int     `xml:"status,omitempty"`
+	EnableRewatching *int     `xml:"enable_rewatching,omitempty"`
+	TimesRewatched   *int     `xml:"times_rewatched,omitempty"`
+	Score            *int     `xml:"score,omitempty"`
+	DateStart        *string  `xml:"date_start,omitempty"`
+	DateFinish       *string  `xml:"date_finish,omitempty"`
+	RewatchEpisode   *int     `xml:"rewatch_episode,omitempty"`
+	Comments         *string  `xml:"comments,omitempty"`
+}
+
+// AnimeToMALEntry returns a MAL entry containing every field of the anime.
+// The optional fields are left out if they aren't set
+func AnimeToMALEntry(anime Anime) MALEntry {
+	mal := AnimeToMAL(anime)
+	entry := MALEntry{
+		Episode:          &mal.MyWatchedEpisodes,
+		Status:           &mal.MyStatus,
+		EnableRewatching: &mal.MyRewatching,
+		TimesRewatched:   &mal.MyTimesRewatched,
+	}
+	if mal.MyScore != 0 {
+		entry.Score = &mal.MyScore
+	}
+	if !anime.StartDate().IsZero() {
+		date := formatMALEntryDate(anime.StartDate())
+		entry.DateStart = &date
+	}
+	if !anime.FinishDate().IsZero() {
+		date := formatMALEntryDate(anime.FinishDate())
+		entry.DateFinish = &date
+	}
+	if mal.MyRewatchingEp != 0 {
+		entry.RewatchEpisode = &mal.MyRewatchingEp
+	}
+	if mal.MyComments != "" {
+		entry.Comments = &mal.MyComments
+	}
+	return entry
+}
+
+// Encode returns the entry as the XML document expected by the MAL api
+func (entry MALEntry) Encode() (string, error) {
+	data, err := xml.Marshal(entry)
+	if err != nil {
+		return "", err
+	}
+	return xml.Header + string(data), nil
+}
+
+func (entry MALEntry) String() string {
+	data, err := entry.Encode()
+	if err != nil {
+		return fmt.Sprintf("invalid MAL entry: %v", err)
+	}
+	return data
+}
+
+// malLibrary represents the XML data of a MAL anime list
+type malLibrary struct {
+	Error string `xml:"error"`
+	// Anime are decoded one at a time so that a bad entry doesn't fail the whole library
+	Anime []malEntry `xml:"anime"`
+}
+
+// malEntry is the undecoded XML of an anime in a MAL anime list
+type malEntry struct {
+	Inner []byte `xml:",innerxml"`
+}
+
+// decode decodes the anime of the entry
+func (e malEntry) decode() (MALAnime, error) {
+	var anime MALAnime
+	data := append(append([]byte("<anime>"), e.Inner...), "</anime>"...)
+	err := xml.Unmarshal(data, &anime)
+	return anime, err
+}
+
+type MyAnimeListAnimeList struct {
+	changeTracker
+
+	username string
+	password Credential
+	anime    map[int]MALAnime
+	options  ListOptions
+}
+
+func NewMyAnimeListAnimeList(username string, password Credential, options ...ListOptions) *MyAnimeListAnimeList {
+	return &MyAnimeListAnimeList{
+		username:      username,
+		password:      password,
+		anime:         make(map[int]MALAnime),
+		options:       listOptions(options),
+		changeTracker: newChangeTracker(MyAnimeList),
+	}
+}
+
+var _ Animelist = (*MyAnimeListAnimeList)(nil)
+
+func (mal *MyAnimeListAnimeList) Type() int {
+	return MyAnimeList
+}
+
+// Fetch fetches the animelist from the api and replays the changes that haven't been
+// pushed on top of it. Changes that no longer apply are returned by StaleChanges.
+// Entries that can't be decoded or have an unknown status are left out of the list
+// and returned by Quarantined
+func (mal *MyAnimeListAnimeList) Fetch() error {
+	var library malLibrary
+	libraryURL := mal.options.url(fmt.Sprintf(MyAnimeListLibraryURL, url.QueryEscape(mal.username)), MyAnimeListBaseURL)
+	err := mal.options.fetch(MyAnimeList, libraryURL, func(resp *http.Response) error {
+		return xml.NewDecoder(resp.Body).Decode(&library)
+	})
+	if err != nil {
+		return err
+	}
+	if library.Error != "" {
+		return errors.New(library.Error)
+	}
+
+	animeMap := make(map[int]MALAnime)
+	var quarantined []*EntryError
+	for _, entry := range library.Anime {
+		anime, err := entry.decode()
+		if err != nil {
+			quarantined = append(quarantined, &EntryError{ListType: MyAnimeList, Err: err})
+			continue
+		}
+		if err := checkMALEntry(anime); err != nil {
+			quarantined = append(quarantined, err)
+			continue
+		}
+		animeMap[anime.ID().Get(MyAnimeList)] = anime
+	}
+
+	mal.anime = animeMap
+	mal.quarantined = quarantined
+	return mal.rebase(mal)
+}
+
+// checkMALEntry returns an error if a fetched anime can't be kept in the list
+func checkMALEntry(anime MALAnime) *EntryError {
+	entryErr := &EntryError{ListType: MyAnimeList, ID: anime.SeriesAnimeDBID, Title: anime.SeriesTitle}
+	if anime.SeriesAnimeDBID == 0 {
+		entryErr.Err = ErrMissingID
+		return entryErr
+	}
+	if _, err := ParseMALStatus(anime.MyStatus); err != nil {
+		entryErr.Err = err
+		return entryErr
+	}
+	return nil
+}
+
+// OpenChangeLog opens the change log at the path, adds the changes in it to the list
+// and writes every change made to the list to the log until it is pushed
+func (mal *MyAnimeListAnimeList) OpenChangeLog(path string) error {
+	return mal.openChangeLog(path, mal)
+}
+
+// loadAnime replaces the anime in the list without recording any changes
+func (mal *MyAnimeListAnimeList) loadAnime(anime []Anime) {
+	mal.anime = make(map[int]MALAnime)
+	for _, a := range anime {
+		ma, ok := a.(MALAnime)
+		if !ok {
+			ma = AnimeToMAL(a)
+		}
+		mal.anime[a.ID().Get(MyAnimeList)] = ma
+	}
+}
+
+// applyChange changes the anime in the list to what they are after the change,
+// or to what they were before the change if undo is true
+func (mal *MyAnimeListAnimeList) applyChange(change Change, undo ...bool) {
+	undoChange := len(undo) > 0 && undo[0]
+
+	switch c := change.(type) {
+	case AddChange:
+		if undoChange {
+			delete(mal.anime, c.Anime.ID().Get(MyAnimeList))
+		} else {
+			mal.anime[c.Anime.ID().Get(MyAnimeList)] = AnimeToMAL(c.Anime)
+		}
+	case EditChange:
+		if undoChange {
+			mal.anime[c.OldAnime.ID().Get(MyAnimeList)] = AnimeToMAL(c.OldAnime)
+		} else {
+			mal.anime[c.NewAnime.ID().Get(MyAnimeList)] = AnimeToMAL(c.NewAnime)
+		}
+	case DeleteChange:
+		if undoChange {
+			mal.anime[c.Anime.ID().Get(MyAnimeList)] = AnimeToMAL(c.Anime)
+		} else {
+			delete(mal.anime, c.Anime.ID().Get(MyAnimeList))
+		}
+	}
+}
+
+func (mal *MyAnimeListAnimeList) Add(anime Anime) {
+	id := anime.ID().Get(MyAnimeList)
+	mal.anime[id] = AnimeToMAL(anime)
+
+	mal.record(AddChange{Anime: anime})
+}
+
+func (mal *MyAnimeListAnimeList) Edit(anime Anime) {
+	animeID := anime.ID().Get(MyAnimeList)
+	oldAnime := mal.anime[animeID]
+	mal.anime[animeID] = AnimeToMAL(anime)
+
+	mal.record(EditChange{
+		OldAnime: oldAnime,
+		NewAnime: anime,
+	})
+}
+
+func (mal *MyAnimeListAnimeList) Get(id int) (Anime, error) {
+	if anime, ok := mal.anime[id]; ok {
+		return anime, nil
+	}
+	return nil, errors.New(fmt.Sprintf("Anime with ID %d is not in the anime list", id))
+}
+
+// Anime returns the anime in the list ordered by MyAnimeList ID
+func (mal *MyAnimeListAnimeList) Anime() []Anime {
+	ids := make([]int, 0, len(mal.anime))
+	for id := range mal.anime {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	anime := make([]Anime, len(ids))
+	for i, id := range ids {
+		anime[i] = mal.anime[id]
+	}
+	return anime
+}
+
+func (mal *MyAnimeListAnimeList) Contains(id int) bool {
+	_, ok := mal.anime[id]
+	return ok
+}
+
+func (mal *MyAnimeListAnimeList) Remove(anime Anime) {
+	delete(mal.anime, anime.ID().Get(MyAnimeList))
+	mal.record(DeleteChange{Anime: anime})
+}
+
+// PlanPush returns the requests that Push would send without sending them
+func (mal *MyAnimeListAnimeList) PlanPush() (*PushPlan, error) {
+	mergedChanges := MergeChanges(mal.changes, MyAnimeList)
+
+	plan := &PushPlan{
+		ListType: MyAnimeList,
+		Changes:  mergedChanges,
+		Requests: make([]PlannedRequest, len(mergedChanges)),
+	}
+	for i, change := range mergedChanges {
+		request, err := mal.planChange(change, false)
+		if err != nil {
+			return nil, fmt.Errorf("Error planning %s: %w", DescribeChange(change, MyAnimeList), err)
+		}
+		plan.Requests[i] = request
+	}
+	return plan, nil
+}
+
+// Push sends the changes that haven't been pushed. Changes that fail to send stay queued
+// and are returned in a *PushError
+func (mal *MyAnimeListAnimeList) Push() error {
+	if mal.logErr != nil {
+		return fmt.Errorf("Changes could not be written to the change log: %v", mal.logErr)
+	}
+
+	// changes that can't be planned fail on their own instead of failing the push
+	return mal.push(mal, MergeChanges(mal.changes, MyAnimeList))
+}
+
+// Undo undoes the last change if it hasn't been pushed, otherwise it undoes the last push
+func (mal *MyAnimeListAnimeList) Undo() error {
+	return mal.undo(mal)
+}
+
+// Redo redoes the last undone change or push
+func (mal *MyAnimeListAnimeList) Redo() error {
+	return mal.redo(mal)
+}
+
+// sendChanges sends the requests that apply the changes, or undo them if undo is true
+func (mal *MyAnimeListAnimeList) sendChanges(changes []Change, undo ...bool) ([]RequestResult, error) {
+	undoChanges := len(undo) > 0 && undo[0]
+
+	newRequest := func(change Change) (*http.Request, error) {
+		plannedRequest, err := mal.planChange(change, undoChanges)
+		if err != nil {
+			return nil, err
+		}
+		return mal.newRequest(plannedRequest)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), mal.options.timeout())
+	defer cancel()
+	results := sendChangeRequests(ctx, changes, newRequest, SendOptions{
+		Client:   mal.options.httpClient(),
+		Retry:    mal.options.retryPolicy(),
+		Throttle: mal.options.throttle(MyAnimeList),
+		HandleResponse: func(resp *http.Response) error {
+			if resp.StatusCode != 200 && resp.StatusCode != 201 {
+				return fmt.Errorf("Status code is %d", resp.StatusCode)
+			}
+			return nil
+		},
+	})
+	return results, nil
+}
+
+// GenerateChange returns a HTTP request that applies the change
+func (mal *MyAnimeListAnimeList) GenerateChange(change Change, undo ...bool) (*http.Request, error) {
+	undoForm := len(undo) > 0 && undo[0]
+	plannedRequest, err := mal.planChange(change, undoForm)
+	if err != nil {
+		return nil, err
+	}
+	return mal.newRequest(plannedRequest)
+}
+
+// planChange returns the request that applies the change without creating it
+func (mal *MyAnimeListAnimeList) planChange(change Change, undo bool) (PlannedRequest, error) {
+	form := url.Values{}
+	if err := change.FillForm(MyAnimeList, &form, undo); err != nil {
+		return PlannedRequest{}, err
+	}
+	changeURL, err := change.URL(MyAnimeList, undo)
+	if err != nil {
+		return PlannedRequest{}, err
+	}
+	return PlannedRequest{
+		Change: change,
+		Undo:   undo,
+		Method: "POST",
+		URL:    mal.options.url(changeURL, MyAnimeListBaseURL),
+		Form:   form,
+	}, nil
+}
+
+// newRequest creates the HTTP request for a planned request
+func (mal *MyAnimeListAnimeList) newRequest(plannedRequest PlannedRequest) (*http.Request, error) {
+	password, err := mal.options.credential(mal.password, MyAnimeList, mal.username)
+	if err != nil {
+		return nil, err
+	}
+	mal.password = password
+
+	request, err := plannedRequest.NewRequest()
+	if err != nil {
+		return nil, err
+	}
+
+	// MAL uses basic authentication instead of an auth token in the form
+	request.SetBasicAuth(mal.username, password.Secret())
+	mal.options.prepare(request)
+	return request, nil
+}
+
+// DiffMyAnimeListLists creates a list of changes from diffing two MyAnimeList anime lists
+func DiffMyAnimeListLists(oldList *MyAnimeListAnimeList, newList *MyAnimeListAnimeList) []Change {
+	oldAnime, newAnime := make(map[int]Anime), make(map[int]Anime)
+	for id, anime := range oldList.anime {
+		oldAnime[id] = anime
+	}
+	for id, anime := range newList.anime {
+		newAnime[id] = anime
+	}
+	return diffAnimeMaps(oldAnime, newAnime)
 }
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+const testMALAnimeXML = `
+<anime>
+	<series_animedb_id>1535</series_animedb_id>
+	<series_title>Death Note</series_title>
+	<series_episodes>37</series_episodes>
+	<my_watched_episodes>12</my_watched_episodes>
+	<my_status>1</my_status>
+	<my_rewatching>1</my_rewatching>
+	<my_rewatching_ep>3</my_rewatching_ep>
+	<my_times_rewatched>2</my_times_rewatched>
+</anime>`
+
+const testMALLibraryXML = `<?xml version="1.0" encoding="UTF-8"?>
+<myanimelist>
+	<myinfo>
+		<user_id>1</user_id>
+		<user_name>darin_minamoto</user_name>
+	</myinfo>
+	<anime>
+		<series_animedb_id>1535</series_animedb_id>
+		<series_title>Death Note</series_title>
+		<series_episodes>37</series_episodes>
+		<my_watched_episodes>37</my_watched_episodes>
+		<my_status>2</my_status>
+		<my_rewatching>0</my_rewatching>
+		<my_rewatching_ep>0</my_rewatching_ep>
+	</anime>
+	<anime>
+		<series_animedb_id>5114</series_animedb_id>
+		<series_title>Fullmetal Alchemist: Brotherhood</series_title>
+		<series_episodes>64</series_episodes>
+		<my_watched_episodes>20</my_watched_episodes>
+		<my_status>1</my_status>
+		<my_rewatching>0</my_rewatching>
+		<my_rewatching_ep>0</my_rewatching_ep>
+	</anime>
+</myanimelist>`
+
+var malStatusTests = []struct {
+	malStatus int
+	status    int
+}{
+	{MALStatusWatching, StatusWatching},
+	{MALStatusCompleted, StatusCompleted},
+	{MALStatusOnHold, StatusOnHold},
+	{MALStatusDropped, StatusDropped},
+	{MALStatusPlanToWatch, StatusPlanToWatch},
+}
+
+func TestMALAnime_DecodeXML(t *testing.T) {
+	var anime MALAnime
+	if err := xml.Unmarshal([]byte(testMALAnimeXML), &anime); err != nil {
+		t.Fatalf("TestMALAnime_DecodeXML failed: %v", err)
+	}
+
+	expected := MALAnime{
+		SeriesAnimeDBID:   1535,
+		SeriesTitle:       "Death Note",
+		SeriesEpisodes:    37,
+		MyWatchedEpisodes: 12,
+		MyStatus:          MALStatusWatching,
+		MyRewatching:      1,
+		MyRewatchingEp:    3,
+		MyTimesRewatched:  2,
+	}
+	if !reflect.DeepEqual(anime, expected) {
+		t.Errorf("TestMALAnime_DecodeXML failed: want %+v got %+v", expected, anime)
+	}
+
+	if anime.ID() != (AnimeID{MyAnimeList: 1535}) {
+		t.Errorf("TestMALAnime_DecodeXML failed: unexpected ID %+v", anime.ID())
+	}
+	if anime.Title() != "Death Note" || anime.EpisodesWatched() != 12 ||
+		anime.RewatchedTimes() != 2 || !anime.Rewatching() || anime.Status() != StatusWatching {
+		t.Errorf("TestMALAnime_DecodeXML failed: Anime methods don't match %+v", anime)
+	}
+}
+
+func TestMALAnime_Status(t *testing.T) {
+	for _, test := range malStatusTests {
+		anime := MALAnime{MyStatus: test.malStatus}
+		if anime.Status() != test.status {
+			t.Errorf("TestMALAnime_Status failed: want %d got %d", test.status, anime.Status())
+		}
+		if malStatus, err := StatusToMALStatus(test.status); err != nil || malStatus != test.malStatus {
+			t.Errorf("TestMALAnime_Status failed: want %d got %d %v", test.malStatus, malStatus, err)
+		}
+	}
+}
+
+func TestAnimeToMAL(t *testing.T) {
+	anime := HummingbirdAnime{
+		NumEpisodesWatched: 12,
+		AnimeStatus:        "completed",
+		NumRewatchedTimes:  1,
+		IsRewatching:       true,
+		Data: HummingbirdAnimeData{
+			Id:    50,
+			MalID: 20,
+			Title: "Sample text",
+		},
+	}
+
+	expected := MALAnime{
+		SeriesAnimeDBID:   20,
+		SeriesTitle:       "Sample text",
+		MyWatchedEpisodes: 12,
+		MyStatus:          MALStatusCompleted,
+		MyRewatching:      1,
+		MyTimesRewatched:  1,
+		HummingbirdID:     50,
+	}
+	if result := AnimeToMAL(anime); !reflect.DeepEqual(result, expected) {
+		t.Errorf("TestAnimeToMAL failed: want %+v got %+v", expected, result)
+	}
+}
+
+func newTestMALServer(body string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/malappinfo.php" || r.URL.Query().Get("u") != "darin_minamoto" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "text/xml")
+		fmt.Fprint(w, body)
+	}))
+}
+
+func TestMyAnimeListAnimeList_FetchFromEmpty(t *testing.T) {
+	server := newTestMALServer(testMALLibraryXML)
+	defer server.Close()
+
+	list := NewMyAnimeListAnimeList("darin_minamoto", "", ListOptions{BaseURL: server.URL})
+	if err := list.Fetch(); err != nil {
+		t.Fatalf("TestMyAnimeListAnimeList_FetchFromEmpty failed: %v", err)
+	}
+	if len(list.anime) != 2 {
+		t.Errorf("TestMyAnimeListAnimeList_FetchFromEmpty failed: want 2 anime got %d", len(list.anime))
+	}
+	if list.anime[5114].EpisodesWatched() != 20 || list.anime[5114].Status() != StatusWatching {
+		t.Errorf("TestMyAnimeListAnimeList_FetchFromEmpty failed: unexpected anime %+v", list.anime[5114])
+	}
+	// the fetched anime are already on the site so they aren't changes to push
+	if len(list.changes) != 0 {
+		t.Errorf("TestMyAnimeListAnimeList_FetchFromEmpty failed: want no changes got %d", len(list.changes))
+	}
+}
+
+func TestMyAnimeListAnimeList_FetchError(t *testing.T) {
+	server := newTestMALServer(`<?xml version="1.0" encoding="UTF-8"?><myanimelist><error>Invalid username</error></myanimelist>`)
+	defer server.Close()
+
+	list := NewMyAnimeListAnimeList("darin_minamoto", "", ListOptions{BaseURL: server.URL})
+	if err := list.Fetch(); err == nil || err.Error() != "Invalid username" {
+		t.Errorf("TestMyAnimeListAnimeList_FetchError failed: expected Invalid username error got %v", err)
+	}
+}
+
+func TestMyAnimeListAnimeList_AddEditRemove(t *testing.T) {
+	list := NewMyAnimeListAnimeList("darin_minamoto", "")
+	oldAnime := HummingbirdAnime{
+		NumEpisodesWatched: 11,
+		NumRewatchedTimes:  2,
+		AnimeStatus:        "currently-watching",
+		Data: HummingbirdAnimeData{
+			Id:    50,
+			MalID: 20,
+			Title: "Sample text",
+		},
+	}
+	newAnime := MALAnime{
+		SeriesAnimeDBID:   20,
+		SeriesTitle:       "Sample text",
+		MyWatchedEpisodes: 12,
+		MyStatus:          MALStatusCompleted,
+		MyTimesRewatched:  3,
+	}
+
+	list.Add(oldAnime)
+	if !reflect.DeepEqual(list.anime[20], AnimeToMAL(oldAnime)) {
+		t.Errorf("TestMyAnimeListAnimeList_AddEditRemove failed: expected: %+v, got %+v", AnimeToMAL(oldAnime), list.anime[20])
+	}
+	if !reflect.DeepEqual(list.changes[len(list.changes)-1], AddChange{oldAnime}) {
+		t.Errorf("TestMyAnimeListAnimeList_AddEditRemove failed: expected change %+v", AddChange{oldAnime})
+	}
+
+	list.Edit(newAnime)
+	if !reflect.DeepEqual(list.anime[20], newAnime) {
+		t.Errorf("TestMyAnimeListAnimeList_AddEditRemove failed: expected: %+v, got %+v", newAnime, list.anime[20])
+	}
+	expectedEdit := EditChange{OldAnime: AnimeToMAL(oldAnime), NewAnime: newAnime}
+	if !reflect.DeepEqual(list.changes[len(list.changes)-1], expectedEdit) {
+		t.Errorf("TestMyAnimeListAnimeList_AddEditRemove failed: expected change %+v", expectedEdit)
+	}
+
+	list.Remove(newAnime)
+	if _, ok := list.anime[20]; ok {
+		t.Errorf("TestMyAnimeListAnimeList_AddEditRemove failed: expected anime to not exist after removed")
+	}
+	if !reflect.DeepEqual(list.changes[len(list.changes)-1], DeleteChange{Anime: newAnime}) {
+		t.Errorf("TestMyAnimeListAnimeList_AddEditRemove failed: expected change %+v", DeleteChange{Anime: newAnime})
+	}
+}
+
+func TestDiffMyAnimeListLists(t *testing.T) {
+	oldList := NewMyAnimeListAnimeList("darin_minamoto", "")
+	newList := NewMyAnimeListAnimeList("darin_minamoto", "")
+	kept := MALAnime{SeriesAnimeDBID: 1, MyStatus: MALStatusCompleted}
+	edited := MALAnime{SeriesAnimeDBID: 2, MyStatus: MALStatusWatching, MyWatchedEpisodes: 3}
+	removed := MALAnime{SeriesAnimeDBID: 3, MyStatus: MALStatusDropped}
+	added := MALAnime{SeriesAnimeDBID: 4, MyStatus: MALStatusPlanToWatch}
+	oldList.anime = map[int]MALAnime{1: kept, 2: edited, 3: removed}
+	editedAfter := edited
+	editedAfter.MyWatchedEpisodes = 4
+	newList.anime = map[int]MALAnime{1: kept, 2: editedAfter, 4: added}
+
+	changes := DiffMyAnimeListLists(oldList, newList)
+	expectedChanges := []Change{
+		EditChange{OldAnime: edited, NewAnime: editedAfter},
+		DeleteChange{Anime: removed},
+		AddChange{Anime: added},
+	}
+	if len(changes) != len(expectedChanges) {
+		t.Fatalf("TestDiffMyAnimeListLists failed: want %+v got %+v", expectedChanges, changes)
+	}
+	for _, expectedChange := range expectedChanges {
+		found := false
+		for _, change := range changes {
+			if reflect.DeepEqual(change, expectedChange) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("TestDiffMyAnimeListLists failed: expected %+v in %+v", expectedChange, changes)
+		}
+	}
+}
+
+func TestMyAnimeListAnimeList_GenerateChange(t *testing.T) {
+	list := NewMyAnimeListAnimeList("darin_minamoto", "hunter2")
+	request, err := list.GenerateChange(AddChange{MALAnime{SeriesAnimeDBID: 20, MyStatus: MALStatusWatching}})
+	if err != nil {
+		t.Fatalf("TestMyAnimeListAnimeList_GenerateChange failed: %v", err)
+	}
+
+	if request.URL.String() != fmt.Sprintf(MyAnimeListAddURL, 20) {
+		t.Errorf("TestMyAnimeListAnimeList_GenerateChange failed: unexpected URL %s", request.URL)
+	}
+	if username, password, ok := request.BasicAuth(); !ok || username != "darin_minamoto" || password != "hunter2" {
+		t.Errorf("TestMyAnimeListAnimeList_GenerateChange failed: expected basic auth to be set")
+	}
+	if err := request.ParseForm(); err != nil {
+		t.Fatalf("TestMyAnimeListAnimeList_GenerateChange failed: %v", err)
+	}
+	if request.PostForm.Get("data") != AnimeToMALEntry(MALAnime{SeriesAnimeDBID: 20, MyStatus: MALStatusWatching}).String() {
+		t.Errorf("TestMyAnimeListAnimeList_GenerateChange failed: unexpected data %s", request.PostForm.Get("data"))
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// PlannedRequest is a HTTP request that applies a change to an anime list site.
+// Credentials in the form are RedactedCredential, the list fills them in when it creates the request
+type PlannedRequest struct {
+	Change Change
+	Undo   bool
+	Method string
+	URL    string
+	Form   url.Values
+}
+
+// NewRequest creates the HTTP request described by the plan
+func (pr PlannedRequest) NewRequest() (*http.Request, error) {
+	request, err := http.NewRequest(pr.Method, pr.URL, strings.NewReader(pr.Form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return request, nil
+}
+
+func (pr PlannedRequest) String() string {
+	requestURL := pr.URL
+	if parsed, err := url.Parse(pr.URL); err == nil {
+		requestURL = parsed.Redacted()
+	}
+	return fmt.Sprintf("%s %s %s", pr.Method, requestURL, pr.Form.Encode())
+}
+
+// PushPlan describes what pushing an anime list would send without sending anything
+type PushPlan struct {
+	ListType int
+	// Changes are the merged changes that would be pushed
+	Changes []Change
+	// Requests are the requests for each of the merged changes
+	Requests []PlannedRequest
+}
+
+func (plan *PushPlan) String() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s: %d change(s) to push\n", ListTypeName(plan.ListType), len(plan.Changes))
+	for _, request := range plan.Requests {
+		fmt.Fprintf(&buf, "  %s\n    %s\n", DescribeChange(request.Change, plan.ListType), request)
+	}
+	return buf.String()
+}
+
+// ListChange is a change that would be made to an anime list
+type ListChange struct {
+	List   Animelist
+	Change Change
+}
+
+// SyncPlan describes what syncing the lists of a manager would change without changing anything
+type SyncPlan struct {
+	Changes   []ListChange
+	Conflicts []Conflict
+}
+
+// Lists returns the lists that the sync would change
+func (plan *SyncPlan) Lists() []Animelist {
+	var lists []Animelist
+	seen := make(map[Animelist]bool)
+	for _, change := range plan.Changes {
+		if !seen[change.List] {
+			seen[change.List] = true
+			lists = append(lists, change.List)
+		}
+	}
+	return lists
+}
+
+func (plan *SyncPlan) String() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%d change(s), %d conflict(s)\n", len(plan.Changes), len(plan.Conflicts))
+	for _, change := range plan.Changes {
+		listType := change.List.Type()
+		fmt.Fprintf(&buf, "  %s: %s\n", ListTypeName(listType), DescribeChange(change.Change, listType))
+	}
+	for _, conflict := range plan.Conflicts {
+		fmt.Fprintf(&buf, "  conflict with %s: %d\n", ListTypeName(conflict.Replica.Type()), conflict.ID)
+	}
+	return buf.String()
+}
+
+// DescribeChange returns a human readable description of a change
+func DescribeChange(change Change, listType int) string {
+	switch c := change.(type) {
+	case AddChange:
+		return fmt.Sprintf("add %d %q", c.Anime.ID().Get(listType), c.Anime.Title())
+	case EditChange:
+		return fmt.Sprintf("edit %d %q", c.NewAnime.ID().Get(listType), c.NewAnime.Title())
+	case DeleteChange:
+		return fmt.Sprintf("remove %d %q", c.Anime.ID().Get(listType), c.Anime.Title())
+	default:
+		return fmt.Sprintf("%+v", change)
+	}
+}
+
+// PlanSync returns the changes that Sync would make to each list and the conflicts it would return.
+// Replicas are planned against the current primary list, so changes that syncing one
+// replica makes to the primary list are not seen by the other replicas
+func (m *AnimelistManager) PlanSync() (*SyncPlan, error) {
+	plan := &SyncPlan{}
+	for i := range m.replicas {
+		actions, conflicts, _, err := m.planSync(i)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, action := range actions {
+			plan.Changes = append(plan.Changes, ListChange{List: action.list, Change: action.change()})
+		}
+		plan.Conflicts = append(plan.Conflicts, conflicts...)
+	}
+	return plan, nil
+}
+
+// PlanPush returns what pushing each of the lists would send
+func (m *AnimelistManager) PlanPush() ([]*PushPlan, error) {
+	var plans []*PushPlan
+	for _, list := range m.lists() {
+		plan, err := list.PlanPush()
+		if err != nil {
+			return nil, err
+		}
+		plans = append(plans, plan)
+	}
+	return plans, nil
+}
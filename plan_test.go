@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHummingbirdAnimeList_PlanPush(t *testing.T) {
+	list := NewHummingbirdAnimeList("darin_minamoto", "token")
+	list.Add(newSyncTestAnime(1, 101, 3))
+	list.Edit(newSyncTestAnime(1, 101, 4))
+	list.Remove(newSyncTestAnime(2, 102, 5))
+
+	plan, err := list.PlanPush()
+	if err != nil {
+		t.Fatalf("TestHummingbirdAnimeList_PlanPush failed: %v", err)
+	}
+
+	expectedRequests := []PlannedRequest{
+		{
+			Change: AddChange{newSyncTestAnime(1, 101, 4)},
+			Method: "POST",
+			URL:    fmt.Sprintf(HummingbirdAddURL, 1),
+			Form: url.Values{
+				"auth_token":       []string{RedactedCredential},
+				"status":           []string{"currently-watching"},
+				"rewatching":       []string{"false"},
+				"rewatched_times":  []string{"0"},
+				"episodes_watched": []string{"4"},
+			},
+		},
+		{
+			Change: DeleteChange{newSyncTestAnime(2, 102, 5)},
+			Method: "POST",
+			URL:    fmt.Sprintf(HummingbirdDeleteURL, 2),
+			Form:   url.Values{"auth_token": []string{RedactedCredential}},
+		},
+	}
+	if !reflect.DeepEqual(plan.Requests, expectedRequests) {
+		t.Errorf("TestHummingbirdAnimeList_PlanPush failed: want %+v got %+v", expectedRequests, plan.Requests)
+	}
+	if len(plan.Changes) != 2 || len(list.changes) != 3 {
+		t.Errorf("TestHummingbirdAnimeList_PlanPush failed: expected 2 planned changes and the changes to be kept")
+	}
+
+	output := plan.String()
+	for _, expected := range []string{
+		"Hummingbird: 2 change(s) to push",
+		`add 1 "Sample text"`,
+		"POST " + fmt.Sprintf(HummingbirdDeleteURL, 2),
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("TestHummingbirdAnimeList_PlanPush failed: expected %q in output %q", expected, output)
+		}
+	}
+}
+
+func TestMyAnimeListAnimeList_PlanPush(t *testing.T) {
+	list := NewMyAnimeListAnimeList("darin_minamoto", "hunter2")
+	list.Add(newSyncTestAnime(1, 101, 3))
+
+	plan, err := list.PlanPush()
+	if err != nil {
+		t.Fatalf("TestMyAnimeListAnimeList_PlanPush failed: %v", err)
+	}
+	if len(plan.Requests) != 1 || plan.Requests[0].URL != fmt.Sprintf(MyAnimeListAddURL, 101) {
+		t.Fatalf("TestMyAnimeListAnimeList_PlanPush failed: unexpected requests %+v", plan.Requests)
+	}
+	if plan.Requests[0].Form.Get("data") != AnimeToMALEntry(newSyncTestAnime(1, 101, 3)).String() {
+		t.Errorf("TestMyAnimeListAnimeList_PlanPush failed: unexpected form %v", plan.Requests[0].Form)
+	}
+}
+
+func TestAnimelistManager_PlanSync(t *testing.T) {
+	manager, primary, replica := newSyncTestManager(t,
+		newSyncTestAnime(1, 101, 3),
+		newSyncTestAnime(2, 102, 4),
+	)
+	primary.Edit(newSyncTestAnime(1, 101, 10))
+	primary.Edit(newSyncTestAnime(2, 102, 7))
+	replica.Edit(AnimeToMAL(newSyncTestAnime(2, 102, 8)))
+	replica.Add(AnimeToMAL(newSyncTestAnime(3, 103, 1)))
+
+	primaryChanges, replicaChanges := len(primary.changes), len(replica.changes)
+	plan, err := manager.PlanSync()
+	if err != nil {
+		t.Fatalf("TestAnimelistManager_PlanSync failed: %v", err)
+	}
+	if len(primary.changes) != primaryChanges || len(replica.changes) != replicaChanges || primary.Contains(3) {
+		t.Errorf("TestAnimelistManager_PlanSync failed: expected planning to not change the lists")
+	}
+
+	if len(plan.Changes) != 2 || len(plan.Conflicts) != 1 || plan.Conflicts[0].ID != 102 {
+		t.Fatalf("TestAnimelistManager_PlanSync failed: unexpected plan %+v", plan)
+	}
+	if plan.Changes[0].List != replica || !reflect.DeepEqual(plan.Changes[0].Change, EditChange{
+		OldAnime: replica.anime[101],
+		NewAnime: AnimeToMAL(newSyncTestAnime(1, 101, 10)),
+	}) {
+		t.Errorf("TestAnimelistManager_PlanSync failed: unexpected replica change %+v", plan.Changes[0])
+	}
+	if plan.Changes[1].List != primary || !reflect.DeepEqual(plan.Changes[1].Change, AddChange{
+		AnimeToHummingbird(newSyncTestAnime(3, 103, 1)),
+	}) {
+		t.Errorf("TestAnimelistManager_PlanSync failed: unexpected primary change %+v", plan.Changes[1])
+	}
+	if lists := plan.Lists(); len(lists) != 2 {
+		t.Errorf("TestAnimelistManager_PlanSync failed: want 2 lists got %d", len(lists))
+	}
+
+	output := plan.String()
+	for _, expected := range []string{"2 change(s), 1 conflict(s)", `MyAnimeList: edit 101 "Sample text"`, "conflict with MyAnimeList: 102"} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("TestAnimelistManager_PlanSync failed: expected %q in output %q", expected, output)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+)
+
+// ErrUnresolved is returned by a ConflictResolver that can't resolve a conflict.
+// The conflict is then returned from Sync instead of being applied
+var ErrUnresolved = errors.New("Conflict could not be resolved")
+
+// ConflictResolver decides what a conflicting anime should be on both lists
+type ConflictResolver interface {
+	// Resolve returns the anime that both lists should have.
+	// Returning a nil anime removes the anime from both lists
+	Resolve(conflict Conflict) (Anime, error)
+}
+
+// ConflictResolverFunc allows a function to be used as a ConflictResolver
+type ConflictResolverFunc func(conflict Conflict) (Anime, error)
+
+func (f ConflictResolverFunc) Resolve(conflict Conflict) (Anime, error) {
+	return f(conflict)
+}
+
+// PrimaryWins resolves conflicts by keeping the anime in the primary list
+var PrimaryWins = ConflictResolverFunc(func(conflict Conflict) (Anime, error) {
+	return conflict.PrimaryAnime, nil
+})
+
+// MostProgressWins resolves conflicts by keeping the anime that has been rewatched
+// the most times, then the one with the most episodes watched.
+// Removed anime have no progress and ties are won by the primary list
+var MostProgressWins = ConflictResolverFunc(func(conflict Conflict) (Anime, error) {
+	primary, replica := conflict.PrimaryAnime, conflict.ReplicaAnime
+	switch {
+	case replica == nil:
+		return primary, nil
+	case primary == nil:
+		return replica, nil
+	case replica.RewatchedTimes() != primary.RewatchedTimes():
+		if replica.RewatchedTimes() > primary.RewatchedTimes() {
+			return replica, nil
+		}
+		return primary, nil
+	case replica.EpisodesWatched() > primary.EpisodesWatched():
+		return replica, nil
+	default:
+		return primary, nil
+	}
+})
+
+// MostRecentlyUpdatedWins resolves conflicts by keeping the anime that was updated last.
+// Removed anime lose to anime that are still in a list and ties are won by the primary list.
+// Conflicts where the update time of an anime isn't known are left unresolved
+var MostRecentlyUpdatedWins = ConflictResolverFunc(func(conflict Conflict) (Anime, error) {
+	primary, replica := conflict.PrimaryAnime, conflict.ReplicaAnime
+	switch {
+	case replica == nil:
+		return primary, nil
+	case primary == nil:
+		return replica, nil
+	case primary.UpdatedAt().IsZero() || replica.UpdatedAt().IsZero():
+		return nil, ErrUnresolved
+	case replica.UpdatedAt().After(primary.UpdatedAt()):
+		return replica, nil
+	default:
+		return primary, nil
+	}
+})
+
+// ConflictSide is the side of a conflict that a value is taken from
+type ConflictSide int
+
+const (
+	PrimarySide ConflictSide = iota
+	ReplicaSide ConflictSide = iota
+)
+
+// FieldMergeResolver resolves conflicts by merging the anime field by field,
+// taking each field from the configured side.
+// Conflicts where the anime was removed from a list are left unresolved
+type FieldMergeResolver struct {
+	Status   ConflictSide
+	Episodes ConflictSide
+	Rewatch  ConflictSide
+}
+
+func (r FieldMergeResolver) Resolve(conflict Conflict) (Anime, error) {
+	if conflict.PrimaryAnime == nil || conflict.ReplicaAnime == nil {
+		return nil, ErrUnresolved
+	}
+
+	side := func(s ConflictSide) Anime {
+		if s == ReplicaSide {
+			return conflict.ReplicaAnime
+		}
+		return conflict.PrimaryAnime
+	}
+
+	merged := AnimeToHummingbird(syncedAnime{
+		Anime: conflict.PrimaryAnime,
+		id:    mergeIDs(conflict.PrimaryAnime.ID(), conflict.ReplicaAnime.ID()),
+	})
+	merged.AnimeStatus = AnimeToHummingbird(side(r.Status)).AnimeStatus
+	merged.NumEpisodesWatched = side(r.Episodes).EpisodesWatched()
+	merged.NumRewatchedTimes = side(r.Rewatch).RewatchedTimes()
+	merged.IsRewatching = side(r.Rewatch).Rewatching()
+	return merged, nil
+}
+
+// ReplicaEpisodesMerge is the field merge resolver that is chosen by name. It keeps the status
+// and rewatches of the primary list and the episodes watched of the replica list, for replicas
+// that episodes are tracked on. Other splits of the fields can only be used through the API
+var ReplicaEpisodesMerge = FieldMergeResolver{Status: PrimarySide, Episodes: ReplicaSide, Rewatch: PrimarySide}
+
+// conflictResolvers are the resolvers that can be chosen by name in configs and the command line tool
+var conflictResolvers = map[string]ConflictResolver{
+	"none":     nil,
+	"primary":  PrimaryWins,
+	"progress": MostProgressWins,
+	"recent":   MostRecentlyUpdatedWins,
+	"merge":    ReplicaEpisodesMerge,
+}
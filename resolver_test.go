@@ -0,0 +1,208 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var (
+	resolverTestOlder   = time.Date(2016, 5, 1, 0, 0, 0, 0, time.UTC)
+	resolverTestNewer   = time.Date(2016, 6, 1, 0, 0, 0, 0, time.UTC)
+	resolverTestBase    = newSyncTestAnime(2, 102, 4)
+	resolverTestPrimary = HummingbirdAnime{
+		NumEpisodesWatched: 7,
+		AnimeStatus:        "completed",
+		NumRewatchedTimes:  1,
+		LastUpdated:        resolverTestOlder,
+		Data:               HummingbirdAnimeData{Id: 2, MalID: 102, Title: "Sample text"},
+	}
+	resolverTestReplica = MALAnime{
+		SeriesAnimeDBID:   102,
+		SeriesTitle:       "Sample text",
+		MyWatchedEpisodes: 9,
+		MyStatus:          MALStatusOnHold,
+		MyTimesRewatched:  0,
+		MyLastUpdated:     resolverTestNewer.Unix(),
+	}
+)
+
+var conflictResolverTests = []struct {
+	resolver ConflictResolver
+	conflict Conflict
+	expected Anime
+	err      error
+}{
+	{
+		PrimaryWins,
+		Conflict{PrimaryAnime: resolverTestPrimary, ReplicaAnime: resolverTestReplica},
+		resolverTestPrimary,
+		nil,
+	},
+	{
+		PrimaryWins,
+		Conflict{PrimaryAnime: nil, ReplicaAnime: resolverTestReplica},
+		nil,
+		nil,
+	},
+	{
+		MostProgressWins,
+		Conflict{PrimaryAnime: resolverTestPrimary, ReplicaAnime: resolverTestReplica},
+		resolverTestPrimary,
+		nil,
+	},
+	{
+		MostProgressWins,
+		Conflict{PrimaryAnime: newSyncTestAnime(2, 102, 7), ReplicaAnime: resolverTestReplica},
+		resolverTestReplica,
+		nil,
+	},
+	{
+		MostProgressWins,
+		Conflict{PrimaryAnime: nil, ReplicaAnime: resolverTestReplica},
+		resolverTestReplica,
+		nil,
+	},
+	{
+		MostRecentlyUpdatedWins,
+		Conflict{PrimaryAnime: resolverTestPrimary, ReplicaAnime: resolverTestReplica},
+		resolverTestReplica,
+		nil,
+	},
+	{
+		MostRecentlyUpdatedWins,
+		Conflict{PrimaryAnime: resolverTestPrimary, ReplicaAnime: AnimeToMAL(newSyncTestAnime(2, 102, 9))},
+		nil,
+		ErrUnresolved,
+	},
+	{
+		MostRecentlyUpdatedWins,
+		Conflict{PrimaryAnime: resolverTestPrimary, ReplicaAnime: nil},
+		resolverTestPrimary,
+		nil,
+	},
+	{
+		FieldMergeResolver{Status: PrimarySide, Episodes: ReplicaSide, Rewatch: PrimarySide},
+		Conflict{PrimaryAnime: resolverTestPrimary, ReplicaAnime: resolverTestReplica},
+		HummingbirdAnime{
+			NumEpisodesWatched: 9,
+			AnimeStatus:        "completed",
+			NumRewatchedTimes:  1,
+			LastUpdated:        resolverTestOlder,
+			Data:               HummingbirdAnimeData{Id: 2, MalID: 102, Title: "Sample text"},
+		},
+		nil,
+	},
+	{
+		FieldMergeResolver{Status: ReplicaSide, Episodes: PrimarySide, Rewatch: ReplicaSide},
+		Conflict{PrimaryAnime: resolverTestPrimary, ReplicaAnime: resolverTestReplica},
+		HummingbirdAnime{
+			NumEpisodesWatched: 7,
+			AnimeStatus:        "on-hold",
+			NumRewatchedTimes:  0,
+			LastUpdated:        resolverTestOlder,
+			Data:               HummingbirdAnimeData{Id: 2, MalID: 102, Title: "Sample text"},
+		},
+		nil,
+	},
+	{
+		conflictResolvers["merge"],
+		Conflict{PrimaryAnime: resolverTestPrimary, ReplicaAnime: resolverTestReplica},
+		func() HummingbirdAnime {
+			merged := resolverTestPrimary
+			merged.NumEpisodesWatched = 9
+			return merged
+		}(),
+		nil,
+	},
+	{
+		FieldMergeResolver{},
+		Conflict{PrimaryAnime: resolverTestPrimary, ReplicaAnime: nil},
+		nil,
+		ErrUnresolved,
+	},
+}
+
+func TestConflictResolvers(t *testing.T) {
+	for i, test := range conflictResolverTests {
+		anime, err := test.resolver.Resolve(test.conflict)
+		if err != test.err {
+			t.Errorf("TestConflictResolvers failed: test %d want error %v got %v", i, test.err, err)
+		}
+		if !reflect.DeepEqual(anime, test.expected) {
+			t.Errorf("TestConflictResolvers failed: test %d want %+v got %+v", i, test.expected, anime)
+		}
+	}
+}
+
+func TestAnimelistManager_SyncResolvesConflicts(t *testing.T) {
+	manager, primary, replica := newSyncTestManager(t, resolverTestBase, newSyncTestAnime(3, 103, 5))
+	manager.SetConflictResolver(MostRecentlyUpdatedWins)
+
+	primary.Edit(resolverTestPrimary)
+	replica.Edit(resolverTestReplica)
+	// removals lose to edits when the most recently updated anime wins
+	primary.Remove(primary.anime[3])
+	replica.Edit(MALAnime{SeriesAnimeDBID: 103, MyStatus: MALStatusCompleted, MyLastUpdated: resolverTestNewer.Unix()})
+
+	conflicts, err := manager.Sync()
+	if err != nil || len(conflicts) != 0 {
+		t.Fatalf("TestAnimelistManager_SyncResolvesConflicts failed: %v %+v", err, conflicts)
+	}
+
+	if anime := primary.anime[2]; anime.EpisodesWatched() != 9 || anime.Status() != StatusOnHold || anime.ID() != (AnimeID{2, 102}) {
+		t.Errorf("TestAnimelistManager_SyncResolvesConflicts failed: expected replica anime in primary got %+v", anime)
+	}
+	if !reflect.DeepEqual(replica.anime[102], resolverTestReplica) {
+		t.Errorf("TestAnimelistManager_SyncResolvesConflicts failed: expected replica anime to be kept got %+v", replica.anime[102])
+	}
+	if anime, err := primary.Get(3); err != nil || anime.Status() != StatusCompleted {
+		t.Errorf("TestAnimelistManager_SyncResolvesConflicts failed: expected removed anime to be added back got %+v", anime)
+	}
+
+	// the resolved anime is the new base for the next sync
+	if conflicts, err := manager.Sync(); err != nil || len(conflicts) != 0 {
+		t.Errorf("TestAnimelistManager_SyncResolvesConflicts failed: %v %+v", err, conflicts)
+	}
+}
+
+func TestAnimelistManager_SyncCustomResolver(t *testing.T) {
+	manager, primary, replica := newSyncTestManager(t, resolverTestBase, newSyncTestAnime(3, 103, 5))
+	resolveErr := errors.New("resolver failed")
+
+	var resolved []int
+	manager.SetConflictResolver(ConflictResolverFunc(func(conflict Conflict) (Anime, error) {
+		resolved = append(resolved, conflict.ID)
+		if conflict.ID == 103 {
+			return nil, ErrUnresolved
+		}
+		return nil, nil
+	}))
+
+	primary.Edit(resolverTestPrimary)
+	replica.Edit(resolverTestReplica)
+	primary.Edit(newSyncTestAnime(3, 103, 6))
+	replica.Edit(AnimeToMAL(newSyncTestAnime(3, 103, 7)))
+
+	conflicts, err := manager.Sync()
+	if err != nil {
+		t.Fatalf("TestAnimelistManager_SyncCustomResolver failed: %v", err)
+	}
+	if !reflect.DeepEqual(resolved, []int{102, 103}) {
+		t.Errorf("TestAnimelistManager_SyncCustomResolver failed: expected resolver to be called for every conflict got %v", resolved)
+	}
+	if len(conflicts) != 1 || conflicts[0].ID != 103 {
+		t.Errorf("TestAnimelistManager_SyncCustomResolver failed: expected unresolved conflict to be returned got %+v", conflicts)
+	}
+	if primary.Contains(2) || replica.Contains(102) {
+		t.Errorf("TestAnimelistManager_SyncCustomResolver failed: expected anime resolved to nil to be removed")
+	}
+
+	manager.SetConflictResolver(ConflictResolverFunc(func(conflict Conflict) (Anime, error) {
+		return nil, resolveErr
+	}))
+	if _, err := manager.Sync(); err != resolveErr {
+		t.Errorf("TestAnimelistManager_SyncCustomResolver failed: want error %v got %v", resolveErr, err)
+	}
+}
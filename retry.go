@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy decides which failed requests are sent again and how long to wait before each retry.
+// Only failures that are safe to retry are retried: 5xx responses, 429 responses and
+// connections that were reset or refused
+type RetryPolicy struct {
+	// MaxAttempts is the most times a request is sent, including the first time
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry, which doubles for every retry after it
+	BaseDelay time.Duration
+	// MaxDelay caps the delay between retries, including delays asked for by Retry-After
+	MaxDelay time.Duration
+	// Jitter is the fraction of each delay that is random, from 0 to 1
+	Jitter float64
+}
+
+// DefaultRetryPolicy is the retry policy the lists use to send changes
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 4,
+	BaseDelay:   500 * time.Millisecond,
+	MaxDelay:    30 * time.Second,
+	Jitter:      0.5,
+}
+
+// retryableStatus returns true if a response with the status code can be retried
+func retryableStatus(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode >= 500
+}
+
+// retryableError returns true if the error sending a request can be retried
+func retryableError(err error) bool {
+	return errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// delay returns how long to wait before sending the request again after the attempt.
+// The Retry-After header of the response is used instead of the backoff if it is set
+func (policy RetryPolicy) delay(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+			if policy.MaxDelay > 0 && retryAfter > policy.MaxDelay {
+				return policy.MaxDelay
+			}
+			return retryAfter
+		}
+	}
+
+	delay := policy.BaseDelay
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+		if policy.MaxDelay > 0 && delay > policy.MaxDelay {
+			break
+		}
+	}
+	if policy.MaxDelay > 0 && delay > policy.MaxDelay {
+		delay = policy.MaxDelay
+	}
+
+	if policy.Jitter > 0 {
+		random := time.Duration(float64(delay) * policy.Jitter * rand.Float64())
+		delay = delay - time.Duration(float64(delay)*policy.Jitter) + random
+	}
+	return delay
+}
+
+// parseRetryAfter parses a Retry-After header, which is either a number of seconds or a date
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		delay := time.Until(date)
+		if delay < 0 {
+			delay = 0
+		}
+		return delay, true
+	}
+	return 0, false
+}
+
+// rewindRequest returns a copy of the request with a new body so that it can be sent again
+func rewindRequest(req *http.Request) (*http.Request, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return req, nil
+	}
+	if req.GetBody == nil {
+		return nil, errors.New("Cannot resend request without GetBody")
+	}
+
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	retryReq := *req
+	retryReq.Body = body
+	return &retryReq, nil
+}
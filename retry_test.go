@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newRetryTestServer returns a server that responds with the failure status code and
+// headers the first failures times and with 200 after that, and records the request bodies
+func newRetryTestServer(failures int, failureStatus int, header http.Header) (*httptest.Server, func() []string) {
+	var mutex sync.Mutex
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		mutex.Lock()
+		bodies = append(bodies, string(body))
+		attempt := len(bodies)
+		mutex.Unlock()
+
+		if attempt <= failures {
+			for key, values := range header {
+				w.Header()[key] = values
+			}
+			w.WriteHeader(failureStatus)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	return server, func() []string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]string(nil), bodies...)
+	}
+}
+
+var testRetryPolicy = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}
+
+var retryTests = []struct {
+	failures      int
+	failureStatus int
+	header        http.Header
+	attempts      int
+	statusCode    int
+	failed        bool
+}{
+	{0, 500, nil, 1, 200, false},
+	{2, 500, nil, 3, 200, false},
+	{2, 503, nil, 3, 200, false},
+	{1, 429, http.Header{"Retry-After": {"0"}}, 2, 200, false},
+	{3, 502, nil, 3, 502, true},
+	{2, 400, nil, 1, 400, true},
+	{2, 404, nil, 1, 404, true},
+}
+
+func TestSendRequest_Retry(t *testing.T) {
+	for _, test := range retryTests {
+		server, bodies := newRetryTestServer(test.failures, test.failureStatus, test.header)
+
+		form := url.Values{"auth_token": {"token"}, "episodes_watched": {"3"}}
+		req, _ := http.NewRequest("POST", server.URL, strings.NewReader(form.Encode()))
+		results := SendRequests(context.Background(), []*http.Request{req}, SendOptions{
+			HandleResponse: testStatusHandler,
+			Retry:          &testRetryPolicy,
+		})
+		server.Close()
+
+		result := results[0]
+		if result.Attempts != test.attempts || result.StatusCode != test.statusCode || (result.Err != nil) != test.failed {
+			t.Errorf("TestSendRequest_Retry failed: want %d attempts with status %d got %+v", test.attempts, test.statusCode, result)
+		}
+
+		sentBodies := bodies()
+		if len(sentBodies) != test.attempts {
+			t.Errorf("TestSendRequest_Retry failed: expected the server to receive %d requests got %d", test.attempts, len(sentBodies))
+		}
+		for _, body := range sentBodies {
+			if body != form.Encode() {
+				t.Errorf("TestSendRequest_Retry failed: expected every attempt to send %q got %q", form.Encode(), body)
+			}
+		}
+	}
+}
+
+func TestSendRequest_NoRetryPolicy(t *testing.T) {
+	server, bodies := newRetryTestServer(1, 500, nil)
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	results := SendRequests(context.Background(), []*http.Request{req}, SendOptions{HandleResponse: testStatusHandler})
+	if results[0].Err == nil || results[0].Attempts != 1 || len(bodies()) != 1 {
+		t.Errorf("TestSendRequest_NoRetryPolicy failed: expected a single failed attempt got %+v", results[0])
+	}
+}
+
+func TestSendRequest_RetryCancelled(t *testing.T) {
+	server, _ := newRetryTestServer(1, 429, http.Header{"Retry-After": {"60"}})
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	results := SendRequests(ctx, []*http.Request{req}, SendOptions{
+		HandleResponse: testStatusHandler,
+		Retry:          &RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond},
+	})
+	if results[0].Err == nil || time.Since(start) > 5*time.Second {
+		t.Errorf("TestSendRequest_RetryCancelled failed: expected waiting for Retry-After to be cancelled got %+v", results[0])
+	}
+}
+
+var retryDelayTests = []struct {
+	attempt    int
+	retryAfter string
+	expected   time.Duration
+}{
+	{1, "", 100 * time.Millisecond},
+	{2, "", 200 * time.Millisecond},
+	{3, "", 400 * time.Millisecond},
+	{10, "", time.Second},
+	{1, "0", 0},
+	{1, "1", time.Second},
+	{1, "120", time.Second},
+	{2, "soon", 200 * time.Millisecond},
+}
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 5, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	for _, test := range retryDelayTests {
+		resp := &http.Response{Header: http.Header{}}
+		if test.retryAfter != "" {
+			resp.Header.Set("Retry-After", test.retryAfter)
+		}
+		if delay := policy.delay(test.attempt, resp); delay != test.expected {
+			t.Errorf("TestRetryPolicy_Delay failed: attempt %d with Retry-After %q want %v got %v",
+				test.attempt, test.retryAfter, test.expected, delay)
+		}
+	}
+}
+
+func TestRetryPolicy_Jitter(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: 100 * time.Millisecond, Jitter: 0.5}
+	for i := 0; i < 100; i++ {
+		if delay := policy.delay(1, nil); delay < 50*time.Millisecond || delay > 100*time.Millisecond {
+			t.Fatalf("TestRetryPolicy_Jitter failed: expected a delay between 50ms and 100ms got %v", delay)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	date := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	if delay, ok := parseRetryAfter(date); !ok || delay <= 58*time.Minute || delay > time.Hour {
+		t.Errorf("TestParseRetryAfter failed: expected about an hour got %v", delay)
+	}
+	if _, ok := parseRetryAfter("-1"); ok {
+		t.Errorf("TestParseRetryAfter failed: expected a negative delay to be invalid")
+	}
+}
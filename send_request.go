@@ -1,41 +1,179 @@
 package main
 
 import (
-	"errors"
+	"context"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"sync"
 	"time"
 )
 
-// SendRequests sends multiple http requests asynchronously and then returns the result
-func SendRequests(requests []*http.Request, resultCh chan error, timeoutSec int, handleResponse func(*http.Response) error) {
-	client := &http.Client{}
-	timeoutChan := time.After(time.Duration(timeoutSec) * time.Second)
+// DefaultRequestTimeout is how long the lists wait for the requests of a push or undo
+const DefaultRequestTimeout = 30 * time.Second
 
-	responseChan := make(chan error)
-	for _, req := range requests {
-		go func(req *http.Request) {
-			resp, err := client.Do(req)
-			if err != nil {
-				responseChan <- err
-			} else if err = handleResponse(resp); err != nil {
-				responseChan <- err
-			} else {
-				responseChan <- nil
+// RequestResult is the result of sending a single request
+type RequestResult struct {
+	Request *http.Request
+	// StatusCode is 0 if no response was received
+	StatusCode int
+	// Err is the error sending the request or the error returned by the response handler
+	Err error
+	// Attempts is the number of times the request was sent
+	Attempts int
+	// Latency is the time from sending the request the first time to handling the last response
+	Latency time.Duration
+}
+
+// SendOptions configures how SendRequests sends requests
+type SendOptions struct {
+	// Client sends the requests, http.DefaultClient is used if it is nil
+	Client *http.Client
+	// FailFast cancels the requests that haven't finished after the first failure
+	FailFast bool
+	// HandleResponse returns an error if the response is a failure.
+	// The response body is closed after it returns
+	HandleResponse func(*http.Response) error
+	// Retry is the policy for sending failed requests again, failed requests aren't retried if it is nil
+	Retry *RetryPolicy
+	// Throttle limits the requests that are in flight and how often they are sent, including retries.
+	// The requests aren't limited if it is nil
+	Throttle *Throttle
+}
+
+// SendRequests sends the requests concurrently and returns a result for every request
+// in the same order as the requests. The requests are sent by a pool of workers that is
+// no bigger than the concurrency allowed by the throttle. It returns after every request
+// has finished or has been cancelled by the context, so no goroutines are left running
+func SendRequests(ctx context.Context, requests []*http.Request, options SendOptions) []RequestResult {
+	client := options.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	workers := len(requests)
+	if maxConcurrent := options.Throttle.MaxConcurrent(); maxConcurrent > 0 && maxConcurrent < workers {
+		workers = maxConcurrent
+	}
+
+	indexes := make(chan int, len(requests))
+	for i := range requests {
+		indexes <- i
+	}
+	close(indexes)
+
+	results := make([]RequestResult, len(requests))
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i] = sendRequest(ctx, client, requests[i], options)
+				if results[i].Err != nil && options.FailFast {
+					cancel()
+				}
 			}
-		}(req)
+		}()
 	}
+	wg.Wait()
+	return results
+}
 
-	for _, _ = range requests {
-		select {
-		case err := <-responseChan:
+// sendRequest sends a single request, sending it again while it fails in a way the
+// retry policy allows, and handles the last response
+func sendRequest(ctx context.Context, client *http.Client, req *http.Request, options SendOptions) (result RequestResult) {
+	result.Request = req
+	start := time.Now()
+	defer func() { result.Latency = time.Since(start) }()
+
+	maxAttempts := 1
+	if options.Retry != nil && options.Retry.MaxAttempts > 1 {
+		maxAttempts = options.Retry.MaxAttempts
+	}
+
+	for {
+		result.Attempts++
+		if err := options.Throttle.acquire(ctx); err != nil {
+			result.Err = err
+			return result
+		}
+		resp, err := client.Do(req.WithContext(ctx))
+
+		retry := result.Attempts < maxAttempts && ctx.Err() == nil
+		if err != nil {
+			retry = retry && retryableError(err)
+		} else {
+			retry = retry && retryableStatus(resp.StatusCode)
+		}
+		if !retry {
+			defer options.Throttle.release()
 			if err != nil {
-				resultCh <- err
-				return
+				result.Err = err
+				return result
 			}
-		case <-timeoutChan:
-			resultCh <- errors.New("SendRequests timed out!")
-			return
+			return handleResponse(result, resp, options.HandleResponse)
+		}
+
+		delay := options.Retry.delay(result.Attempts, resp)
+		if resp != nil {
+			drainResponse(resp)
+		}
+		options.Throttle.release()
+		if req, err = rewindRequest(req); err != nil {
+			result.Err = err
+			return result
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			result.Err = ctx.Err()
+			return result
 		}
 	}
-	resultCh <- nil
+}
+
+// handleResponse adds the response to the result and closes it
+func handleResponse(result RequestResult, resp *http.Response, handle func(*http.Response) error) RequestResult {
+	defer drainResponse(resp)
+
+	result.StatusCode = resp.StatusCode
+	if handle != nil {
+		result.Err = handle(resp)
+	}
+	return result
+}
+
+// drainResponse reads the rest of the response body and closes it so the connection can be reused
+func drainResponse(resp *http.Response) {
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+}
+
+// sendChangeRequests creates the request of each change with newRequest and sends them.
+// A change whose request can't be created isn't sent and its result has the error.
+// The results are in the same order as the changes
+func sendChangeRequests(ctx context.Context, changes []Change, newRequest func(Change) (*http.Request, error),
+	options SendOptions) []RequestResult {
+	results := make([]RequestResult, len(changes))
+	var requests []*http.Request
+	var indexes []int
+	for i, change := range changes {
+		request, err := newRequest(change)
+		if err != nil {
+			results[i] = RequestResult{Err: err}
+			continue
+		}
+		requests = append(requests, request)
+		indexes = append(indexes, i)
+	}
+
+	for i, result := range SendRequests(ctx, requests, options) {
+		results[indexes[i]] = result
+	}
+	return results
 }
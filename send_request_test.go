@@ -1,57 +1,122 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 )
 
-func TestSendRequest(t *testing.T) {
-	testCreateHttp := func(method string, url string) *http.Request {
-		req, _ := http.NewRequest(method, url, nil)
-		return req
+// newSendRequestTestServer returns a server that responds with 404 to /404 and 200 to other
+// paths, except /hang which doesn't respond until the request is cancelled
+func newSendRequestTestServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/hang":
+			<-r.Context().Done()
+		case "/404":
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+}
+
+func testStatusHandler(resp *http.Response) error {
+	if resp.StatusCode != 200 {
+		return errors.New("Response status code is not 200!")
 	}
+	return nil
+}
 
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusNotFound)
-	}))
+func TestSendRequest(t *testing.T) {
+	server := newSendRequestTestServer()
 	defer server.Close()
 
+	testCreateHttp := func(path string) *http.Request {
+		req, _ := http.NewRequest("GET", server.URL+path, nil)
+		return req
+	}
+
 	var sendRequestTests = []struct {
-		requests []*http.Request
-		noError  bool
+		paths       []string
+		statusCodes []int
+		failed      []bool
 	}{
-		{
-			[]*http.Request{
-				testCreateHttp("GET", "http://www.google.com"),
-				testCreateHttp("GET", "http://www.facebook.com"),
-			},
-			true,
-		},
-		{
-			[]*http.Request{
-				testCreateHttp("GET", "http://www.google.com"),
-				testCreateHttp("GET", server.URL),
-			},
-			false,
-		},
+		{[]string{"/", "/ok"}, []int{200, 200}, []bool{false, false}},
+		{[]string{"/", "/404", "/"}, []int{200, 404, 200}, []bool{false, true, false}},
+		{[]string{}, []int{}, []bool{}},
 	}
 
 	for _, test := range sendRequestTests {
-		resultCh := make(chan error)
-		go SendRequests(test.requests, resultCh, 3, func(resp *http.Response) error {
-			if resp.StatusCode != 200 {
-				return errors.New("Response status code is not 200!")
-			}
+		requests := make([]*http.Request, len(test.paths))
+		for i, path := range test.paths {
+			requests[i] = testCreateHttp(path)
+		}
 
-			return nil
-		})
-		err := <-resultCh
-		if err != nil && test.noError {
-			t.Errorf("TestSendRequest failed: Error when sending %v", err)
-		} else if err == nil && !test.noError {
-			t.Errorf("TestSendRequest failed: Expected to return an error")
+		results := SendRequests(context.Background(), requests, SendOptions{HandleResponse: testStatusHandler})
+		if len(results) != len(requests) {
+			t.Fatalf("TestSendRequest failed: expected %d results got %d", len(requests), len(results))
+		}
+		for i, result := range results {
+			if result.Request != requests[i] {
+				t.Errorf("TestSendRequest failed: result %d is not for request %d", i, i)
+			}
+			if result.StatusCode != test.statusCodes[i] {
+				t.Errorf("TestSendRequest failed: want status %d got %d", test.statusCodes[i], result.StatusCode)
+			}
+			if (result.Err != nil) != test.failed[i] {
+				t.Errorf("TestSendRequest failed: unexpected error %v for %s", result.Err, test.paths[i])
+			}
+			if result.Latency <= 0 {
+				t.Errorf("TestSendRequest failed: expected the latency to be recorded")
+			}
 		}
 	}
 }
+
+func TestSendRequest_FailFast(t *testing.T) {
+	server := newSendRequestTestServer()
+	defer server.Close()
+
+	hangReq, _ := http.NewRequest("GET", server.URL+"/hang", nil)
+	failReq, _ := http.NewRequest("GET", server.URL+"/404", nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	results := SendRequests(ctx, []*http.Request{hangReq, failReq}, SendOptions{
+		FailFast:       true,
+		HandleResponse: testStatusHandler,
+	})
+
+	if ctx.Err() != nil {
+		t.Fatalf("TestSendRequest_FailFast failed: the hanging request wasn't cancelled by the failure")
+	}
+	if results[0].Err == nil || results[0].StatusCode != 0 {
+		t.Errorf("TestSendRequest_FailFast failed: expected the hanging request to be cancelled got %+v", results[0])
+	}
+	if results[1].Err == nil || results[1].StatusCode != 404 {
+		t.Errorf("TestSendRequest_FailFast failed: expected the failed request to have status 404 got %+v", results[1])
+	}
+}
+
+func TestSendRequest_Timeout(t *testing.T) {
+	server := newSendRequestTestServer()
+	defer server.Close()
+
+	hangReq, _ := http.NewRequest("GET", server.URL+"/hang", nil)
+	okReq, _ := http.NewRequest("GET", server.URL+"/", nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	results := SendRequests(ctx, []*http.Request{hangReq, okReq}, SendOptions{HandleResponse: testStatusHandler})
+
+	if results[0].Err == nil {
+		t.Errorf("TestSendRequest_Timeout failed: expected the hanging request to time out")
+	}
+	if results[1].Err != nil || results[1].StatusCode != 200 {
+		t.Errorf("TestSendRequest_Timeout failed: expected the other request to succeed got %+v", results[1])
+	}
+}
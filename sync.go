@@ -0,0 +1,430 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"time"
+)
+
+// Conflict is an anime that was changed differently on the primary list
+// and a replica list since the last sync
+type Conflict struct {
+	// ID is the ID of the anime for the replica's list type
+	ID int
+	// Replica is the replica list that conflicts with the primary list
+	Replica Animelist
+	// Base is the anime at the last sync, nil if it was added to both lists
+	Base Anime
+	// PrimaryAnime is the anime in the primary list, nil if it was removed
+	PrimaryAnime Anime
+	// ReplicaAnime is the anime in the replica list, nil if it was removed
+	ReplicaAnime Anime
+}
+
+// syncAction is a change that a sync applies to a list
+type syncAction struct {
+	list Animelist
+	// current is the anime in the list, nil if it isn't in the list
+	current Anime
+	// anime is the anime the list should have, nil if it should be removed
+	anime Anime
+}
+
+// apply adds, edits or removes the anime in the list
+func (action syncAction) apply() {
+	switch {
+	case action.anime == nil:
+		action.list.Remove(action.current)
+	case action.current == nil:
+		action.list.Add(action.anime)
+	default:
+		action.list.Edit(action.anime)
+	}
+}
+
+// change returns the change that the action makes to the list
+func (action syncAction) change() Change {
+	switch {
+	case action.anime == nil:
+		return DeleteChange{Anime: action.current}
+	case action.current == nil:
+		return AddChange{Anime: action.anime}
+	default:
+		return EditChange{OldAnime: action.current, NewAnime: action.anime}
+	}
+}
+
+// syncedAnime is an anime with the IDs of its counterpart on another list filled in.
+// The optional fields that the anime doesn't keep are taken from the counterpart if it is set
+type syncedAnime struct {
+	Anime
+	id          AnimeID
+	counterpart Anime
+}
+
+func (a syncedAnime) ID() AnimeID {
+	return a.id
+}
+
+// fromCounterpart returns true if the field is taken from the counterpart
+func (a syncedAnime) fromCounterpart(field AnimeField) bool {
+	return a.counterpart != nil && animeFields(a.Anime)&field == 0
+}
+
+func (a syncedAnime) Score() int {
+	if a.fromCounterpart(FieldScore) {
+		return a.counterpart.Score()
+	}
+	return a.Anime.Score()
+}
+
+func (a syncedAnime) Notes() string {
+	if a.fromCounterpart(FieldNotes) {
+		return a.counterpart.Notes()
+	}
+	return a.Anime.Notes()
+}
+
+func (a syncedAnime) Private() bool {
+	if a.fromCounterpart(FieldPrivate) {
+		return a.counterpart.Private()
+	}
+	return a.Anime.Private()
+}
+
+func (a syncedAnime) StartDate() time.Time {
+	if a.fromCounterpart(FieldStartDate) {
+		return a.counterpart.StartDate()
+	}
+	return a.Anime.StartDate()
+}
+
+func (a syncedAnime) FinishDate() time.Time {
+	if a.fromCounterpart(FieldFinishDate) {
+		return a.counterpart.FinishDate()
+	}
+	return a.Anime.FinishDate()
+}
+
+func (a syncedAnime) RewatchEpisode() int {
+	if a.fromCounterpart(FieldRewatchEpisode) {
+		return a.counterpart.RewatchEpisode()
+	}
+	return a.Anime.RewatchEpisode()
+}
+
+// mergeIDs returns the ID with the missing list IDs filled in from the other ID
+func mergeIDs(id AnimeID, other AnimeID) AnimeID {
+	if id.Hummingbird == 0 {
+		id.Hummingbird = other.Hummingbird
+	}
+	if id.MyAnimeList == 0 {
+		id.MyAnimeList = other.MyAnimeList
+	}
+	return id
+}
+
+// withID returns the anime with its IDs set to the ID. Hummingbird and MAL anime keep their type
+func withID(anime Anime, id AnimeID) Anime {
+	if anime.ID() == id {
+		return anime
+	}
+	switch a := anime.(type) {
+	case HummingbirdAnime:
+		a.Data.Id, a.Data.MalID = id.Hummingbird, id.MyAnimeList
+		return a
+	case MALAnime:
+		a.HummingbirdID, a.SeriesAnimeDBID = id.Hummingbird, id.MyAnimeList
+		return a
+	default:
+		return syncedAnime{Anime: anime, id: id}
+	}
+}
+
+// mapAnimeIDs returns the anime with the IDs they are missing filled in from the ID map
+func (m *AnimelistManager) mapAnimeIDs(anime []Anime) []Anime {
+	mapped := make([]Anime, len(anime))
+	for i, a := range anime {
+		mapped[i] = withID(a, m.completeID(a))
+	}
+	return mapped
+}
+
+// ConvertAnime converts an anime to the anime type of the list type
+func ConvertAnime(anime Anime, listType int) (Anime, error) {
+	switch listType {
+	case Hummingbird:
+		return AnimeToHummingbird(anime), nil
+	case MyAnimeList:
+		return AnimeToMAL(anime), nil
+	default:
+		return nil, &ListTypeError{ListType: listType}
+	}
+}
+
+// SameAnime returns true if the list entries of both anime have the same values.
+// Optional fields are only compared if both anime keep them
+func SameAnime(a Anime, b Anime) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	fields := animeFields(a) & animeFields(b)
+	return a.Status() == b.Status() &&
+		a.EpisodesWatched() == b.EpisodesWatched() &&
+		a.RewatchedTimes() == b.RewatchedTimes() &&
+		a.Rewatching() == b.Rewatching() &&
+		(fields&FieldScore == 0 || a.Score() == b.Score()) &&
+		(fields&FieldNotes == 0 || a.Notes() == b.Notes()) &&
+		(fields&FieldPrivate == 0 || a.Private() == b.Private()) &&
+		(fields&FieldStartDate == 0 || a.StartDate().Equal(b.StartDate())) &&
+		(fields&FieldFinishDate == 0 || a.FinishDate().Equal(b.FinishDate())) &&
+		(fields&FieldRewatchEpisode == 0 || a.RewatchEpisode() == b.RewatchEpisode())
+}
+
+// IndexAnime returns a map of the anime keyed by their ID for the list type.
+// Anime without an ID for the list type are left out
+func IndexAnime(anime []Anime, listType int) map[int]Anime {
+	animeMap := make(map[int]Anime)
+	for _, a := range anime {
+		if id := a.ID().Get(listType); id != 0 {
+			animeMap[id] = a
+		}
+	}
+	return animeMap
+}
+
+// diffAnimeMaps creates a list of changes from diffing two anime maps of the same list type.
+// Anime are edited if anything about them changed
+func diffAnimeMaps(oldAnime map[int]Anime, newAnime map[int]Anime) []Change {
+	var changes []Change
+	for id, oldA := range oldAnime {
+		if newA, ok := newAnime[id]; ok {
+			if !reflect.DeepEqual(newA, oldA) {
+				changes = append(changes, EditChange{OldAnime: oldA, NewAnime: newA})
+			}
+		} else {
+			changes = append(changes, DeleteChange{Anime: oldA})
+		}
+	}
+	for id, anime := range newAnime {
+		if _, ok := oldAnime[id]; !ok {
+			changes = append(changes, AddChange{Anime: anime})
+		}
+	}
+	return changes
+}
+
+// DiffAnime creates a map of changes keyed by ID from diffing two anime maps.
+// Unlike DiffHummingbirdLists the anime can be of different types
+func DiffAnime(oldAnime map[int]Anime, newAnime map[int]Anime) map[int]Change {
+	changes := make(map[int]Change)
+	for id, oldA := range oldAnime {
+		if newA, ok := newAnime[id]; ok {
+			if !SameAnime(oldA, newA) {
+				changes[id] = EditChange{OldAnime: oldA, NewAnime: newA}
+			}
+		} else {
+			changes[id] = DeleteChange{Anime: oldA}
+		}
+	}
+	for id, anime := range newAnime {
+		if _, ok := oldAnime[id]; !ok {
+			changes[id] = AddChange{Anime: anime}
+		}
+	}
+	return changes
+}
+
+// Sync does a two-way sync between the primary list and each replica list.
+// Changes made to either list since the last sync are applied to the other list,
+// and anime that were changed differently on both lists are passed to the conflict resolver.
+// Conflicts that aren't resolved are returned without changing either list
+func (m *AnimelistManager) Sync() ([]Conflict, error) {
+	var conflicts []Conflict
+	for i := range m.replicas {
+		actions, replicaConflicts, snapshot, err := m.planSync(i)
+		if err != nil {
+			return nil, err
+		}
+		for _, action := range actions {
+			action.apply()
+		}
+
+		m.snapshots[i] = snapshot
+		conflicts = append(conflicts, replicaConflicts...)
+	}
+	return conflicts, nil
+}
+
+// Snapshot returns the anime that the primary list and the replica list had after they were
+// last synced, keyed by the replica's IDs. It is nil if the lists haven't been synced
+func (m *AnimelistManager) Snapshot(replicaIndex int) map[int]Anime {
+	return m.snapshots[replicaIndex]
+}
+
+// SetSnapshot sets the anime that the primary list and the replica list had after they were
+// last synced, so that a sync can continue from a sync done by an earlier manager
+func (m *AnimelistManager) SetSnapshot(replicaIndex int, snapshot map[int]Anime) {
+	m.snapshots[replicaIndex] = snapshot
+}
+
+// planSync returns the changes needed to sync the primary list with a replica list,
+// the unresolved conflicts between them and the snapshot of the lists after the sync
+func (m *AnimelistManager) planSync(replicaIndex int) ([]syncAction, []Conflict, map[int]Anime, error) {
+	replica := m.replicas[replicaIndex]
+	listType := replica.Type()
+
+	base := m.snapshots[replicaIndex]
+	if base == nil {
+		base = make(map[int]Anime)
+	}
+	primaryAnime := IndexAnime(m.mapAnimeIDs(m.primary.Anime()), listType)
+	replicaAnime := IndexAnime(m.mapAnimeIDs(replica.Anime()), listType)
+	primaryChanges := DiffAnime(base, primaryAnime)
+	replicaChanges := DiffAnime(base, replicaAnime)
+
+	snapshot := make(map[int]Anime)
+	for id, anime := range base {
+		snapshot[id] = anime
+	}
+
+	var actions []syncAction
+	var conflicts []Conflict
+	for _, id := range changedIDs(primaryChanges, replicaChanges) {
+		_, primaryChanged := primaryChanges[id]
+		_, replicaChanged := replicaChanges[id]
+		primaryEntry, replicaEntry := primaryAnime[id], replicaAnime[id]
+
+		// synced is the anime both lists have after the sync
+		var synced Anime
+		switch {
+		case primaryChanged && replicaChanged:
+			synced = primaryEntry
+			if SameAnime(primaryEntry, replicaEntry) {
+				break
+			}
+
+			conflict := Conflict{
+				ID:           id,
+				Replica:      replica,
+				Base:         base[id],
+				PrimaryAnime: primaryEntry,
+				ReplicaAnime: replicaEntry,
+			}
+			resolved, resolveActions, err := m.resolve(conflict)
+			if err == ErrUnresolved {
+				conflicts = append(conflicts, conflict)
+				continue
+			} else if err != nil {
+				return nil, nil, nil, err
+			}
+			actions = append(actions, resolveActions...)
+			synced = resolved
+		case primaryChanged:
+			action := newSyncAction(replica, replicaEntry, primaryEntry)
+			if action == nil {
+				continue
+			}
+			actions = append(actions, *action)
+			synced = primaryEntry
+		default:
+			action := newSyncAction(m.primary, primaryEntry, replicaEntry)
+			if action == nil {
+				continue
+			}
+			actions = append(actions, *action)
+			synced = replicaEntry
+		}
+
+		if synced == nil {
+			delete(snapshot, id)
+		} else {
+			snapshot[id] = synced
+		}
+	}
+	return actions, conflicts, snapshot, nil
+}
+
+// resolve resolves a conflict with the manager's resolver and returns the resolved anime
+// and the changes that apply it to both lists
+func (m *AnimelistManager) resolve(conflict Conflict) (Anime, []syncAction, error) {
+	if m.resolver == nil {
+		return nil, nil, ErrUnresolved
+	}
+	resolved, err := m.resolver.Resolve(conflict)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var actions []syncAction
+	entries := []struct {
+		list    Animelist
+		current Anime
+	}{
+		{m.primary, conflict.PrimaryAnime},
+		{conflict.Replica, conflict.ReplicaAnime},
+	}
+	for _, entry := range entries {
+		if SameAnime(entry.current, resolved) {
+			continue
+		}
+
+		anime := resolved
+		if anime != nil {
+			// the resolved anime can come from either list so it needs the IDs of both
+			id := anime.ID()
+			for _, other := range []Anime{conflict.PrimaryAnime, conflict.ReplicaAnime, conflict.Base} {
+				if other != nil {
+					id = mergeIDs(id, other.ID())
+				}
+			}
+			anime = syncedAnime{Anime: anime, id: id}
+		}
+
+		action := newSyncAction(entry.list, entry.current, anime)
+		if action == nil {
+			return nil, nil, ErrUnresolved
+		}
+		actions = append(actions, *action)
+	}
+	return resolved, actions, nil
+}
+
+// newSyncAction returns the action that changes the current anime in the list to the anime,
+// or nil if the anime has no ID for the list and can't be synced yet
+func newSyncAction(list Animelist, current Anime, anime Anime) *syncAction {
+	if anime == nil {
+		return &syncAction{list: list, current: current}
+	}
+
+	id := anime.ID()
+	if current != nil {
+		id = mergeIDs(id, current.ID())
+	}
+	// the fields that the anime doesn't keep are kept from the current anime in the list
+	converted, err := ConvertAnime(syncedAnime{Anime: anime, id: id, counterpart: current}, list.Type())
+	if id.Get(list.Type()) == 0 || err != nil {
+		return nil
+	}
+
+	return &syncAction{
+		list:    list,
+		current: current,
+		anime:   converted,
+	}
+}
+
+// changedIDs returns the sorted IDs of both change maps
+func changedIDs(a map[int]Change, b map[int]Change) []int {
+	var ids []int
+	for id := range a {
+		ids = append(ids, id)
+	}
+	for id := range b {
+		if _, ok := a[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	return ids
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// newSyncTestManager returns a manager with a Hummingbird primary list and
+// a MyAnimeList replica list that have already been synced once
+func newSyncTestManager(t *testing.T, anime ...Anime) (*AnimelistManager, *HummingbirdAnimeList, *MyAnimeListAnimeList) {
+	primary := NewHummingbirdAnimeList("darin_minamoto", "")
+	replica := NewMyAnimeListAnimeList("darin_minamoto", "")
+	manager := NewAnimelistManager(primary, replica)
+	for _, a := range anime {
+		primary.Add(a)
+	}
+
+	conflicts, err := manager.Sync()
+	if err != nil || len(conflicts) != 0 {
+		t.Fatalf("initial sync failed: %v %+v", err, conflicts)
+	}
+	return manager, primary, replica
+}
+
+func newSyncTestAnime(hummingbirdID int, malID int, episodes int) HummingbirdAnime {
+	return HummingbirdAnime{
+		NumEpisodesWatched: episodes,
+		AnimeStatus:        "currently-watching",
+		Data: HummingbirdAnimeData{
+			Id:    hummingbirdID,
+			MalID: malID,
+			Title: "Sample text",
+		},
+	}
+}
+
+func TestAnimelistManager_SyncFirstTime(t *testing.T) {
+	primary := NewHummingbirdAnimeList("darin_minamoto", "")
+	replica := NewMyAnimeListAnimeList("darin_minamoto", "")
+	primary.Add(newSyncTestAnime(1, 101, 3))
+	primary.Add(newSyncTestAnime(2, 102, 4))
+	replica.Add(AnimeToMAL(newSyncTestAnime(2, 102, 4)))
+	replica.Add(AnimeToMAL(newSyncTestAnime(3, 103, 5)))
+	// an anime without a Hummingbird ID can't be added to the primary list
+	replica.Add(MALAnime{SeriesAnimeDBID: 104, MyStatus: MALStatusWatching})
+
+	manager := NewAnimelistManager(primary, replica)
+	conflicts, err := manager.Sync()
+	if err != nil || len(conflicts) != 0 {
+		t.Fatalf("TestAnimelistManager_SyncFirstTime failed: %v %+v", err, conflicts)
+	}
+
+	for _, id := range []int{1, 2, 3} {
+		if !primary.Contains(id) {
+			t.Errorf("TestAnimelistManager_SyncFirstTime failed: expected primary to contain %d", id)
+		}
+		if !replica.Contains(100 + id) {
+			t.Errorf("TestAnimelistManager_SyncFirstTime failed: expected replica to contain %d", 100+id)
+		}
+	}
+	if len(primary.Anime()) != 3 {
+		t.Errorf("TestAnimelistManager_SyncFirstTime failed: want 3 primary anime got %d", len(primary.Anime()))
+	}
+	if anime, _ := primary.Get(3); anime.EpisodesWatched() != 5 || anime.ID() != (AnimeID{3, 103}) {
+		t.Errorf("TestAnimelistManager_SyncFirstTime failed: unexpected anime %+v", anime)
+	}
+}
+
+func TestAnimelistManager_SyncBothWays(t *testing.T) {
+	manager, primary, replica := newSyncTestManager(t,
+		newSyncTestAnime(1, 101, 3),
+		newSyncTestAnime(2, 102, 4),
+		newSyncTestAnime(3, 103, 5),
+	)
+
+	primary.Edit(newSyncTestAnime(1, 101, 10))
+	// anime fetched from MyAnimeList don't have a Hummingbird ID
+	replica.Edit(AnimeToMAL(newSyncTestAnime(0, 102, 11)))
+	replica.Remove(replica.anime[103])
+
+	conflicts, err := manager.Sync()
+	if err != nil || len(conflicts) != 0 {
+		t.Fatalf("TestAnimelistManager_SyncBothWays failed: %v %+v", err, conflicts)
+	}
+
+	if replica.anime[101].EpisodesWatched() != 10 {
+		t.Errorf("TestAnimelistManager_SyncBothWays failed: expected primary edit on replica got %+v", replica.anime[101])
+	}
+	if primary.anime[2].EpisodesWatched() != 11 || primary.anime[2].ID() != (AnimeID{2, 102}) {
+		t.Errorf("TestAnimelistManager_SyncBothWays failed: expected replica edit on primary got %+v", primary.anime[2])
+	}
+	if primary.Contains(3) {
+		t.Errorf("TestAnimelistManager_SyncBothWays failed: expected replica removal on primary")
+	}
+
+	// syncing again without changes does nothing
+	primaryChanges, replicaChanges := len(primary.changes), len(replica.changes)
+	if conflicts, err := manager.Sync(); err != nil || len(conflicts) != 0 {
+		t.Fatalf("TestAnimelistManager_SyncBothWays failed: %v %+v", err, conflicts)
+	}
+	if len(primary.changes) != primaryChanges || len(replica.changes) != replicaChanges {
+		t.Errorf("TestAnimelistManager_SyncBothWays failed: expected no changes from syncing twice")
+	}
+}
+
+func TestAnimelistManager_SyncConflicts(t *testing.T) {
+	manager, primary, replica := newSyncTestManager(t,
+		newSyncTestAnime(1, 101, 3),
+		newSyncTestAnime(2, 102, 4),
+		newSyncTestAnime(3, 103, 5),
+	)
+
+	// the same edit on both lists is not a conflict
+	primary.Edit(newSyncTestAnime(1, 101, 6))
+	replica.Edit(AnimeToMAL(newSyncTestAnime(1, 101, 6)))
+	// different edits on both lists are a conflict
+	primary.Edit(newSyncTestAnime(2, 102, 7))
+	replica.Edit(AnimeToMAL(newSyncTestAnime(2, 102, 8)))
+	// an edit and a removal are a conflict
+	primary.Remove(primary.anime[3])
+	replica.Edit(AnimeToMAL(newSyncTestAnime(3, 103, 9)))
+
+	conflicts, err := manager.Sync()
+	if err != nil {
+		t.Fatalf("TestAnimelistManager_SyncConflicts failed: %v", err)
+	}
+
+	expectedConflicts := []Conflict{
+		{
+			ID:           102,
+			Replica:      replica,
+			Base:         newSyncTestAnime(2, 102, 4),
+			PrimaryAnime: newSyncTestAnime(2, 102, 7),
+			ReplicaAnime: AnimeToMAL(newSyncTestAnime(2, 102, 8)),
+		},
+		{
+			ID:           103,
+			Replica:      replica,
+			Base:         newSyncTestAnime(3, 103, 5),
+			PrimaryAnime: nil,
+			ReplicaAnime: AnimeToMAL(newSyncTestAnime(3, 103, 9)),
+		},
+	}
+	if !reflect.DeepEqual(conflicts, expectedConflicts) {
+		t.Errorf("TestAnimelistManager_SyncConflicts failed: want %+v got %+v", expectedConflicts, conflicts)
+	}
+
+	// conflicting anime are left alone
+	if primary.anime[2].EpisodesWatched() != 7 || replica.anime[102].EpisodesWatched() != 8 || primary.Contains(3) {
+		t.Errorf("TestAnimelistManager_SyncConflicts failed: expected conflicting anime to not be changed")
+	}
+
+	// conflicts are reported until they are resolved
+	conflicts, _ = manager.Sync()
+	if len(conflicts) != 2 {
+		t.Errorf("TestAnimelistManager_SyncConflicts failed: want 2 conflicts got %d", len(conflicts))
+	}
+	replica.Edit(AnimeToMAL(newSyncTestAnime(2, 102, 7)))
+	conflicts, _ = manager.Sync()
+	if len(conflicts) != 1 {
+		t.Errorf("TestAnimelistManager_SyncConflicts failed: want 1 conflict got %d", len(conflicts))
+	}
+}
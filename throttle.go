@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Throttle limits how many requests to a site are in flight at once and how
+// often they are sent. A Throttle can be shared by any number of SendRequests calls
+type Throttle struct {
+	// slots has a value for every request in flight, nil if the concurrency isn't limited
+	slots chan struct{}
+
+	mutex sync.Mutex
+	// rate is the number of requests allowed per second, 0 if the rate isn't limited
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewThrottle returns a throttle that allows at most maxConcurrent requests in flight and
+// sends at most requestsPerSecond requests a second on average, with bursts of up to burst requests.
+// A limit that is 0 or less isn't enforced
+func NewThrottle(maxConcurrent int, requestsPerSecond float64, burst int) *Throttle {
+	throttle := &Throttle{rate: requestsPerSecond, burst: float64(burst)}
+	if maxConcurrent > 0 {
+		throttle.slots = make(chan struct{}, maxConcurrent)
+	}
+	if throttle.burst < 1 {
+		throttle.burst = 1
+	}
+	throttle.tokens = throttle.burst
+	throttle.last = time.Now()
+	return throttle
+}
+
+// MaxConcurrent returns the number of requests allowed in flight at once, 0 if it isn't limited
+func (t *Throttle) MaxConcurrent() int {
+	if t == nil {
+		return 0
+	}
+	return cap(t.slots)
+}
+
+// acquire waits until a request can be sent without going over the limits
+func (t *Throttle) acquire(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+
+	if t.slots != nil {
+		select {
+		case t.slots <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	wait := t.reserve()
+	if wait <= 0 {
+		return nil
+	}
+	select {
+	case <-time.After(wait):
+		return nil
+	case <-ctx.Done():
+		t.cancelReservation()
+		t.release()
+		return ctx.Err()
+	}
+}
+
+// release marks a request acquired with acquire as finished
+func (t *Throttle) release() {
+	if t != nil && t.slots != nil {
+		<-t.slots
+	}
+}
+
+// reserve takes a token from the bucket and returns how long to wait until the token is available
+func (t *Throttle) reserve() time.Duration {
+	if t.rate <= 0 {
+		return 0
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := time.Now()
+	t.tokens += now.Sub(t.last).Seconds() * t.rate
+	if t.tokens > t.burst {
+		t.tokens = t.burst
+	}
+	t.last = now
+
+	t.tokens--
+	if t.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-t.tokens / t.rate * float64(time.Second))
+}
+
+// cancelReservation returns a token that was reserved but not used
+func (t *Throttle) cancelReservation() {
+	if t.rate <= 0 {
+		return
+	}
+
+	t.mutex.Lock()
+	t.tokens++
+	t.mutex.Unlock()
+}
+
+var (
+	throttlesMutex sync.RWMutex
+	// throttles are the throttles shared by every list of a list type
+	throttles = map[int]*Throttle{
+		Hummingbird: NewThrottle(4, 5, 5),
+		MyAnimeList: NewThrottle(2, 1, 2),
+	}
+)
+
+// ListThrottle returns the throttle shared by the requests of every list of the list type
+func ListThrottle(listType int) *Throttle {
+	throttlesMutex.RLock()
+	defer throttlesMutex.RUnlock()
+	return throttles[listType]
+}
+
+// SetListThrottle sets the throttle shared by the requests of every list of the list type.
+// Setting it to nil removes the limits
+func SetListThrottle(listType int, throttle *Throttle) {
+	throttlesMutex.Lock()
+	defer throttlesMutex.Unlock()
+	throttles[listType] = throttle
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newThrottleTestServer returns a server that holds every request for the delay
+// and records the most requests it had in flight at once
+func newThrottleTestServer(delay time.Duration) (*httptest.Server, func() int) {
+	var mutex sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mutex.Unlock()
+
+		time.Sleep(delay)
+
+		mutex.Lock()
+		inFlight--
+		mutex.Unlock()
+	}))
+
+	return server, func() int {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return maxInFlight
+	}
+}
+
+func newThrottleTestRequests(url string, n int) []*http.Request {
+	requests := make([]*http.Request, n)
+	for i := range requests {
+		requests[i], _ = http.NewRequest("GET", url, nil)
+	}
+	return requests
+}
+
+func TestThrottle_MaxConcurrent(t *testing.T) {
+	server, maxInFlight := newThrottleTestServer(10 * time.Millisecond)
+	defer server.Close()
+
+	throttle := NewThrottle(3, 0, 0)
+	requests := newThrottleTestRequests(server.URL, 20)
+
+	// two calls sharing the throttle together stay under its limit
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results := SendRequests(context.Background(), requests[i*10:(i+1)*10], SendOptions{Throttle: throttle})
+			for _, result := range results {
+				if result.Err != nil {
+					t.Errorf("TestThrottle_MaxConcurrent failed: %v", result.Err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if max := maxInFlight(); max > 3 || max == 0 {
+		t.Errorf("TestThrottle_MaxConcurrent failed: expected at most 3 requests in flight got %d", max)
+	}
+}
+
+func TestThrottle_Rate(t *testing.T) {
+	server, _ := newThrottleTestServer(0)
+	defer server.Close()
+
+	throttle := NewThrottle(0, 100, 1)
+	start := time.Now()
+	results := SendRequests(context.Background(), newThrottleTestRequests(server.URL, 6), SendOptions{Throttle: throttle})
+	elapsed := time.Since(start)
+
+	for _, result := range results {
+		if result.Err != nil {
+			t.Errorf("TestThrottle_Rate failed: %v", result.Err)
+		}
+	}
+	// the first request uses the burst and the other five wait 10ms each
+	if elapsed < 45*time.Millisecond {
+		t.Errorf("TestThrottle_Rate failed: expected 6 requests at 100 a second to take at least 50ms got %v", elapsed)
+	}
+}
+
+func TestThrottle_Cancelled(t *testing.T) {
+	throttle := NewThrottle(1, 0, 0)
+	if err := throttle.acquire(context.Background()); err != nil {
+		t.Fatalf("TestThrottle_Cancelled failed: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := throttle.acquire(ctx); err != context.DeadlineExceeded {
+		t.Errorf("TestThrottle_Cancelled failed: expected waiting for a slot to time out got %v", err)
+	}
+
+	throttle.release()
+	if err := throttle.acquire(context.Background()); err != nil {
+		t.Errorf("TestThrottle_Cancelled failed: expected the slot to be free after it was released got %v", err)
+	}
+}
+
+func TestSetListThrottle(t *testing.T) {
+	oldThrottle := ListThrottle(MyAnimeList)
+	defer SetListThrottle(MyAnimeList, oldThrottle)
+
+	if oldThrottle == nil || oldThrottle == ListThrottle(Hummingbird) {
+		t.Errorf("TestSetListThrottle failed: expected each list type to have its own default throttle")
+	}
+
+	throttle := NewThrottle(1, 1, 1)
+	SetListThrottle(MyAnimeList, throttle)
+	if ListThrottle(MyAnimeList) != throttle {
+		t.Errorf("TestSetListThrottle failed: expected the throttle to be replaced")
+	}
+}
+
+// withoutListThrottles removes the throttles of every list type until the returned function is called
+func withoutListThrottles() func() {
+	hummingbirdThrottle, malThrottle := ListThrottle(Hummingbird), ListThrottle(MyAnimeList)
+	SetListThrottle(Hummingbird, nil)
+	SetListThrottle(MyAnimeList, nil)
+	return func() {
+		SetListThrottle(Hummingbird, hummingbirdThrottle)
+		SetListThrottle(MyAnimeList, malThrottle)
+	}
+}
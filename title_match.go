@@ -0,0 +1,267 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// TitleMatch is an anime on another list that might be the same anime as the anime it was matched with
+type TitleMatch struct {
+	Anime Anime
+	// Confidence is how likely the match is to be the same anime, from 0 to 1
+	Confidence float64
+	// EpisodesMatch is true if both anime have the same known number of episodes
+	EpisodesMatch bool
+}
+
+// IDSuggestion is an anime that isn't mapped to a list and the anime in that list that it might be
+type IDSuggestion struct {
+	Unmapped UnmappedAnime
+	Matches  []TitleMatch
+}
+
+// normalizedTitle is a title without the differences that don't tell anime apart
+type normalizedTitle struct {
+	base   string
+	season int
+}
+
+// titleReplacer replaces the characters that are written differently for the same title
+var titleReplacer = strings.NewReplacer(
+	"ā", "a", "â", "a", "ē", "e", "ê", "e", "ī", "i", "î", "i", "ō", "o", "ô", "o", "ū", "u", "û", "u",
+	"&", " and ", "×", " x ", "'", "", "’", "",
+)
+
+// romanizationReplacer removes the differences between the romanizations of long vowels, like Kyou and Kyō
+var romanizationReplacer = strings.NewReplacer("ou", "o", "oo", "o", "oh", "o", "uu", "u", "aa", "a", "ii", "i", "ee", "e")
+
+var seasonOrdinals = map[string]int{
+	"first": 1, "second": 2, "third": 3, "fourth": 4, "fifth": 5, "sixth": 6,
+}
+
+var seasonNumerals = map[string]int{
+	"ii": 2, "iii": 3, "iv": 4, "v": 5, "vi": 6,
+}
+
+// normalizeTitle lowercases the title, removes its punctuation, romanization variants
+// and season suffixes like "2nd Season" or "II" and returns it with the season
+func normalizeTitle(title string) normalizedTitle {
+	title = titleReplacer.Replace(strings.ToLower(title))
+	words := strings.FieldsFunc(title, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	season := 1
+	if n := len(words); n > 1 {
+		last, beforeLast := words[n-1], words[n-2]
+		switch {
+		case beforeLast == "season" && parseSeason(last) > 0:
+			season, words = parseSeason(last), words[:n-2]
+		case last == "season" && parseSeason(beforeLast) > 0:
+			season, words = parseSeason(beforeLast), words[:n-2]
+		case strings.HasPrefix(last, "s") && parseSeason(last[1:]) > 0:
+			season, words = parseSeason(last[1:]), words[:n-1]
+		case seasonNumerals[last] > 0:
+			season, words = seasonNumerals[last], words[:n-1]
+		default:
+			// a number at the end of a title is a season if it is small, unlike the 100 of Mob Psycho 100
+			if number, err := strconv.Atoi(last); err == nil && number > 1 && number < 10 {
+				season, words = number, words[:n-1]
+			}
+		}
+	}
+
+	for i, word := range words {
+		if word == "wo" {
+			words[i] = "o"
+		} else {
+			words[i] = romanizationReplacer.Replace(word)
+		}
+	}
+	return normalizedTitle{base: strings.Join(words, " "), season: season}
+}
+
+// parseSeason returns the season of a number like "2", "2nd", "second" or "II", 0 if it isn't one
+func parseSeason(word string) int {
+	if season, ok := seasonOrdinals[word]; ok {
+		return season
+	} else if season, ok := seasonNumerals[word]; ok {
+		return season
+	}
+	for _, suffix := range []string{"st", "nd", "rd", "th"} {
+		word = strings.TrimSuffix(word, suffix)
+	}
+	season, err := strconv.Atoi(word)
+	if err != nil || season <= 0 {
+		return 0
+	}
+	return season
+}
+
+// titleSimilarity returns how similar the normalized titles are from 0 to 1.
+// Titles of different seasons are at most half as similar
+func titleSimilarity(a normalizedTitle, b normalizedTitle) float64 {
+	aRunes, bRunes := []rune(a.base), []rune(b.base)
+	longest := len(aRunes)
+	if len(bRunes) > longest {
+		longest = len(bRunes)
+	}
+	if longest == 0 {
+		return 0
+	}
+
+	similarity := 1 - float64(editDistance(aRunes, bRunes))/float64(longest)
+	if a.season != b.season {
+		similarity /= 2
+	}
+	return similarity
+}
+
+// editDistance returns the Levenshtein distance between the strings
+func editDistance(a []rune, b []rune) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min3(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+func min3(a int, b int, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// episodeCount returns the number of episodes of the anime, 0 if it isn't known
+func episodeCount(anime Anime) int {
+	switch a := anime.(type) {
+	case HummingbirdAnime:
+		return a.Data.EpisodeCount
+	case MALAnime:
+		return a.SeriesEpisodes
+	case syncedAnime:
+		return episodeCount(a.Anime)
+	default:
+		return 0
+	}
+}
+
+// MatchTitles compares the title of the anime with the titles of the candidates and returns the
+// candidates that are at least minConfidence similar, ranked by confidence. Candidates with the
+// same number of episodes as the anime are ranked first among candidates with the same confidence
+func MatchTitles(anime Anime, candidates []Anime, minConfidence float64) []TitleMatch {
+	title := normalizeTitle(anime.Title())
+	episodes := episodeCount(anime)
+
+	var matches []TitleMatch
+	for _, candidate := range candidates {
+		confidence := titleSimilarity(title, normalizeTitle(candidate.Title()))
+		if confidence < minConfidence || confidence == 0 {
+			continue
+		}
+		matches = append(matches, TitleMatch{
+			Anime:         candidate,
+			Confidence:    confidence,
+			EpisodesMatch: episodes > 0 && episodes == episodeCount(candidate),
+		})
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].Confidence != matches[j].Confidence {
+			return matches[i].Confidence > matches[j].Confidence
+		}
+		return matches[i].EpisodesMatch && !matches[j].EpisodesMatch
+	})
+	return matches
+}
+
+// SuggestIDs matches the titles of the unmapped anime with the unmapped anime of the lists they
+// are synced with, and returns up to limit candidates for each unmapped anime that is at least
+// minConfidence similar to one. A limit of 0 returns every candidate
+func (m *AnimelistManager) SuggestIDs(minConfidence float64, limit int) []IDSuggestion {
+	var suggestions []IDSuggestion
+	unmapped := m.Unmapped()
+	for _, entry := range unmapped {
+		// the candidates are the anime of the other side of the sync that are missing the ID for its list
+		var candidates []Anime
+		seen := make(map[int]bool)
+		for _, other := range unmapped {
+			if other.List.Type() != entry.ListType || other.ListType != entry.List.Type() ||
+				(other.List != m.primary && entry.List != m.primary) {
+				continue
+			}
+			if id := other.Anime.ID().Get(entry.ListType); !seen[id] {
+				seen[id] = true
+				candidates = append(candidates, other.Anime)
+			}
+		}
+
+		matches := MatchTitles(entry.Anime, candidates, minConfidence)
+		if limit > 0 && len(matches) > limit {
+			matches = matches[:limit]
+		}
+		if len(matches) > 0 {
+			suggestions = append(suggestions, IDSuggestion{Unmapped: entry, Matches: matches})
+		}
+	}
+	return suggestions
+}
+
+// MutualSuggestions returns the suggestions whose best candidate has the unmapped anime as its best
+// candidate too, once for each pair of anime. Suggestions for both anime of a pair would otherwise
+// be accepted twice, and a best candidate that isn't mutual would replace the mapping of the other
+func MutualSuggestions(suggestions []IDSuggestion) []IDSuggestion {
+	type animeKey struct {
+		listType int
+		id       int
+	}
+	best := make(map[animeKey]animeKey)
+	for _, suggestion := range suggestions {
+		unmapped := suggestion.Unmapped
+		key := animeKey{unmapped.List.Type(), unmapped.Anime.ID().Get(unmapped.List.Type())}
+		best[key] = animeKey{unmapped.ListType, suggestion.Matches[0].Anime.ID().Get(unmapped.ListType)}
+	}
+
+	var mutual []IDSuggestion
+	accepted := make(map[animeKey]bool)
+	for _, suggestion := range suggestions {
+		unmapped := suggestion.Unmapped
+		key := animeKey{unmapped.List.Type(), unmapped.Anime.ID().Get(unmapped.List.Type())}
+		match := best[key]
+		if best[match] != key || accepted[key] || accepted[match] {
+			continue
+		}
+		accepted[key], accepted[match] = true, true
+		mutual = append(mutual, suggestion)
+	}
+	return mutual
+}
+
+// Accept maps the IDs of the anime to the IDs of the matched anime as a mapping made by hand
+// and returns true if the mapping is new
+func (m *IDMap) Accept(anime Anime, match TitleMatch) bool {
+	id := mergeIDs(anime.ID(), match.Anime.ID())
+	if mapped, ok := m.Lookup(Hummingbird, id.Hummingbird); ok && mapped == id {
+		m.Set(id, IDSourceManual)
+		return false
+	}
+	return m.Set(id, IDSourceManual)
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var normalizeTitleTests = []struct {
+	title    string
+	expected normalizedTitle
+}{
+	{"Death Note", normalizedTitle{"death note", 1}},
+	{"Steins;Gate", normalizedTitle{"steins gate", 1}},
+	{"Shingeki no Kyojin Season 2", normalizedTitle{"shingeki no kyojin", 2}},
+	{"Shingeki no Kyojin 2nd Season", normalizedTitle{"shingeki no kyojin", 2}},
+	{"Haikyuu!! Second Season", normalizedTitle{"haikyu", 2}},
+	{"Haikyū!! 2", normalizedTitle{"haikyu", 2}},
+	{"Natsume Yuujinchou San", normalizedTitle{"natsume yujincho san", 1}},
+	{"Natsume Yūjin-chō S3", normalizedTitle{"natsume yujin cho", 3}},
+	{"Durarara!!x2", normalizedTitle{"durarara x2", 1}},
+	{"Fate/Zero II", normalizedTitle{"fate zero", 2}},
+	{"Fate/Zero Season II", normalizedTitle{"fate zero", 2}},
+	{"Mob Psycho 100", normalizedTitle{"mob psycho 100", 1}},
+	{"Kimi ni Todoke", normalizedTitle{"kimi ni todoke", 1}},
+	{"Sakura-sou no Pet na Kanojo", normalizedTitle{"sakura so no pet na kanojo", 1}},
+	{"Hataraku Maou-sama!", normalizedTitle{"hataraku mao sama", 1}},
+	{"Tiger & Bunny", normalizedTitle{"tiger and bunny", 1}},
+}
+
+func TestNormalizeTitle(t *testing.T) {
+	for _, test := range normalizeTitleTests {
+		if normalized := normalizeTitle(test.title); normalized != test.expected {
+			t.Errorf("TestNormalizeTitle failed: %q: want %+v got %+v", test.title, test.expected, normalized)
+		}
+	}
+}
+
+// newTitleTestAnime returns a MAL anime with the title and number of episodes
+func newTitleTestAnime(id int, title string, episodes int) MALAnime {
+	return MALAnime{SeriesAnimeDBID: id, SeriesTitle: title, SeriesEpisodes: episodes, MyStatus: MALStatusWatching}
+}
+
+func TestMatchTitles(t *testing.T) {
+	anime := HummingbirdAnime{
+		AnimeStatus: "currently-watching",
+		Data:        HummingbirdAnimeData{Id: 1, Title: "Shingeki no Kyojin Season 2", EpisodeCount: 12},
+	}
+	candidates := []Anime{
+		newTitleTestAnime(101, "Shingeki no Kyojin", 25),
+		newTitleTestAnime(102, "Shingeki no Kyojin 2nd Season", 25),
+		newTitleTestAnime(103, "Shingeki no Kyojin Season II", 12),
+		newTitleTestAnime(104, "Death Note", 37),
+	}
+
+	matches := MatchTitles(anime, candidates, 0.3)
+	if len(matches) != 3 {
+		t.Fatalf("TestMatchTitles failed: expected 3 matches got %+v", matches)
+	}
+	// the candidates of the same season are ranked first and the episode count breaks their tie
+	if matches[0].Anime.ID().MyAnimeList != 103 || !matches[0].EpisodesMatch || matches[0].Confidence != 1 {
+		t.Errorf("TestMatchTitles failed: expected anime 103 to be the best match got %+v", matches[0])
+	}
+	if matches[1].Anime.ID().MyAnimeList != 102 || matches[1].EpisodesMatch || matches[1].Confidence != 1 {
+		t.Errorf("TestMatchTitles failed: expected anime 102 to be the second match got %+v", matches[1])
+	}
+	if matches[2].Anime.ID().MyAnimeList != 101 || matches[2].Confidence != 0.5 {
+		t.Errorf("TestMatchTitles failed: expected anime 101 to be a match of another season got %+v", matches[2])
+	}
+
+	if matches := MatchTitles(anime, candidates, 0.9); len(matches) != 2 {
+		t.Errorf("TestMatchTitles failed: expected the matches below the minimum confidence to be left out got %+v", matches)
+	}
+}
+
+func TestAnimelistManager_SuggestIDs(t *testing.T) {
+	primary := NewHummingbirdAnimeList("darin_minamoto", "")
+	replica := NewMyAnimeListAnimeList("darin_minamoto", "")
+	primary.Add(HummingbirdAnime{AnimeStatus: "completed", Data: HummingbirdAnimeData{Id: 1, Title: "Haikyū!! 2", EpisodeCount: 25}})
+	primary.Add(HummingbirdAnime{AnimeStatus: "completed", Data: HummingbirdAnimeData{Id: 2, MalID: 102, Title: "Haikyuu!!"}})
+	replica.Add(newTitleTestAnime(101, "Haikyuu!! Second Season", 25))
+	replica.Add(newTitleTestAnime(102, "Haikyuu!!", 25))
+	replica.Add(newTitleTestAnime(103, "Haikyuu!! Karasuno Koukou vs. Shiratorizawa Gakuen Koukou", 10))
+
+	ids := NewIDMap()
+	manager := NewAnimelistManager(primary, replica)
+	manager.SetIDMap(ids)
+	suggestions := manager.SuggestIDs(0.6, 0)
+	// anime 102 is mapped so it isn't a candidate, and anime 103 isn't similar enough
+	if len(suggestions) != 2 || len(suggestions[0].Matches) != 1 || suggestions[0].Unmapped.Anime.ID().Hummingbird != 1 {
+		t.Fatalf("TestAnimelistManager_SuggestIDs failed: unexpected suggestions %+v", suggestions)
+	}
+	match := suggestions[0].Matches[0]
+	if match.Anime.ID().MyAnimeList != 101 || match.Confidence != 1 || !match.EpisodesMatch {
+		t.Errorf("TestAnimelistManager_SuggestIDs failed: expected anime 101 to be suggested got %+v", match)
+	}
+	// the MAL anime without a Hummingbird ID gets the unmapped Hummingbird anime as its candidate
+	if reverse := suggestions[1]; reverse.Unmapped.List != replica || reverse.Matches[0].Anime.ID().Hummingbird != 1 {
+		t.Errorf("TestAnimelistManager_SuggestIDs failed: unexpected suggestion %+v", reverse)
+	}
+
+	if !ids.Accept(suggestions[0].Unmapped.Anime, match) {
+		t.Fatalf("TestAnimelistManager_SuggestIDs failed: expected the match to be accepted")
+	}
+	if mappings := ids.Mappings(); len(mappings) != 1 || mappings[0] != (IDMapping{AnimeID{1, 101}, IDSourceManual}) {
+		t.Errorf("TestAnimelistManager_SuggestIDs failed: unexpected mappings %+v", mappings)
+	}
+	// anime 103 is still unmapped but every Hummingbird anime is mapped, so it has no candidates
+	if suggestions := manager.SuggestIDs(0.6, 0); len(suggestions) != 0 {
+		t.Errorf("TestAnimelistManager_SuggestIDs failed: expected no suggestions left got %+v", suggestions)
+	}
+	if unmapped := manager.Unmapped(); len(unmapped) != 1 || unmapped[0].Anime.ID().MyAnimeList != 103 {
+		t.Errorf("TestAnimelistManager_SuggestIDs failed: expected only anime 103 to be unmapped got %+v", unmapped)
+	}
+}
+
+func TestRunCLI_SuggestIDs(t *testing.T) {
+	test, cleanup := newCLITest(t)
+	defer cleanup()
+	entry := newSyncTestAnime(1, 0, 3)
+	entry.Data.Title = "Haikyū!! 2"
+	test.hummingbird.SetEntry("darin_minamoto", entry)
+	test.myAnimeList.SetEntry("darin_minamoto", newTitleTestAnime(101, "Haikyuu!! Second Season", 25))
+
+	test.run(0, "-list", "hummingbird", "fetch")
+	test.run(0, "-list", "myanimelist", "fetch")
+	if output := test.run(0, "ids", "suggest"); !strings.Contains(output, "MyAnimeList 101  100%") {
+		t.Errorf("TestRunCLI_SuggestIDs failed: expected anime 101 to be suggested got %q", output)
+	}
+	test.run(1, "ids", "suggest", "-min", "2")
+
+	if output := test.run(0, "ids", "suggest", "-accept"); !strings.Contains(output, "Mapped 1 anime") {
+		t.Errorf("TestRunCLI_SuggestIDs failed: expected the suggestions to be accepted got %q", output)
+	}
+	if output := test.run(0, "ids"); !strings.Contains(output, "1            101          manual") {
+		t.Errorf("TestRunCLI_SuggestIDs failed: expected the accepted mapping got %q", output)
+	}
+}
+
+func TestMutualSuggestions(t *testing.T) {
+	primary := NewHummingbirdAnimeList("darin_minamoto", "")
+	replica := NewMyAnimeListAnimeList("darin_minamoto", "")
+	hummingbird := func(id int) HummingbirdAnime {
+		return HummingbirdAnime{Data: HummingbirdAnimeData{Id: id}}
+	}
+	suggestion := func(list Animelist, listType int, anime Anime, best Anime) IDSuggestion {
+		return IDSuggestion{UnmappedAnime{list, listType, anime}, []TitleMatch{{Anime: best, Confidence: 1}}}
+	}
+	suggestions := []IDSuggestion{
+		// anime 1 and 101 are each other's best candidate
+		suggestion(primary, MyAnimeList, hummingbird(1), newTitleTestAnime(101, "", 0)),
+		suggestion(replica, Hummingbird, newTitleTestAnime(101, "", 0), hummingbird(1)),
+		// anime 102 is the best candidate of anime 2, but anime 3 is the best candidate of anime 102
+		suggestion(primary, MyAnimeList, hummingbird(2), newTitleTestAnime(102, "", 0)),
+		suggestion(replica, Hummingbird, newTitleTestAnime(102, "", 0), hummingbird(3)),
+		suggestion(primary, MyAnimeList, hummingbird(3), newTitleTestAnime(102, "", 0)),
+	}
+
+	mutual := MutualSuggestions(suggestions)
+	if len(mutual) != 2 || mutual[0].Unmapped.Anime.ID().Hummingbird != 1 || mutual[1].Unmapped.Anime.ID().MyAnimeList != 102 {
+		t.Fatalf("TestMutualSuggestions failed: expected one suggestion for each mutual pair got %+v", mutual)
+	}
+
+	ids := NewIDMap()
+	for _, suggestion := range mutual {
+		if !ids.Accept(suggestion.Unmapped.Anime, suggestion.Matches[0]) {
+			t.Errorf("TestMutualSuggestions failed: expected %+v to be a new mapping", suggestion)
+		}
+	}
+	if ids.Accept(suggestions[1].Unmapped.Anime, suggestions[1].Matches[0]) {
+		t.Errorf("TestMutualSuggestions failed: expected accepting the same mapping again to not be new")
+	}
+	expected := []IDMapping{{AnimeID{1, 101}, IDSourceManual}, {AnimeID{3, 102}, IDSourceManual}}
+	if mappings := ids.Mappings(); !reflect.DeepEqual(mappings, expected) {
+		t.Errorf("TestMutualSuggestions failed: want %+v got %+v", expected, mappings)
+	}
+}
@@ -1,5 +1,10 @@
 package main
 
+import (
+	"fmt"
+	"time"
+)
+
 const (
 	Hummingbird = iota
 	MyAnimeList = iota
@@ -13,20 +18,48 @@ const (
 	StatusPlanToWatch = iota
 )
 
+// StatusUnknown is the status of an anime whose status on its site isn't one of the statuses
+const StatusUnknown = -1
+
+// ListTypeName returns the name of the anime list site for the list type
+func ListTypeName(listType int) string {
+	switch listType {
+	case Hummingbird:
+		return "Hummingbird"
+	case MyAnimeList:
+		return "MyAnimeList"
+	default:
+		return fmt.Sprintf("unknown list %d", listType)
+	}
+}
+
 type AnimeID struct {
 	Hummingbird int
 	MyAnimeList int
 }
 
+// Get returns the ID of the anime on the list type, 0 if it isn't known or the list type isn't supported
 func (id AnimeID) Get(listType int) int {
+	animeID, _ := id.Lookup(listType)
+	return animeID
+}
+
+// Lookup returns the ID of the anime on the list type. It returns ErrMissingID if the ID
+// isn't known and a *ListTypeError if the list type isn't supported
+func (id AnimeID) Lookup(listType int) (int, error) {
+	var animeID int
 	switch listType {
 	case Hummingbird:
-		return id.Hummingbird
+		animeID = id.Hummingbird
 	case MyAnimeList:
-		return id.MyAnimeList
+		animeID = id.MyAnimeList
 	default:
-		panic("Invalid anime list")
+		return 0, &ListTypeError{ListType: listType}
+	}
+	if animeID == 0 {
+		return 0, ErrMissingID
 	}
+	return animeID, nil
 }
 
 type Anime interface {
@@ -36,62 +69,242 @@ type Anime interface {
 	EpisodesWatched() int
 	RewatchedTimes() int
 	Rewatching() bool
+	// UpdatedAt returns when the list entry was last updated, or the zero time if it isn't known
+	UpdatedAt() time.Time
+	// Score returns the score of the anime out of 10, 0 if it isn't scored
+	Score() int
+	Notes() string
+	Private() bool
+	// StartDate and FinishDate return the days the anime was started and finished
+	// at midnight UTC, or the zero time if they aren't known
+	StartDate() time.Time
+	FinishDate() time.Time
+	// RewatchEpisode returns the episode that the rewatch of the anime is on
+	RewatchEpisode() int
+}
+
+// AnimeField is a set of the optional fields of an anime that not every list keeps
+type AnimeField int
+
+const (
+	FieldScore AnimeField = 1 << iota
+	FieldNotes
+	FieldPrivate
+	FieldStartDate
+	FieldFinishDate
+	FieldRewatchEpisode
+)
+
+// AllFields is the set of every optional field
+const AllFields = FieldScore | FieldNotes | FieldPrivate | FieldStartDate | FieldFinishDate | FieldRewatchEpisode
+
+// ListFields returns the optional fields that the list type keeps
+func ListFields(listType int) AnimeField {
+	switch listType {
+	case Hummingbird:
+		return FieldScore | FieldNotes | FieldPrivate
+	case MyAnimeList:
+		return FieldScore | FieldNotes | FieldStartDate | FieldFinishDate | FieldRewatchEpisode
+	default:
+		return 0
+	}
+}
+
+// animeFields returns the optional fields that the anime keeps.
+// Anime of types that aren't from a list keep every field
+func animeFields(anime Anime) AnimeField {
+	switch a := anime.(type) {
+	case HummingbirdAnime:
+		return ListFields(Hummingbird)
+	case MALAnime:
+		return ListFields(MyAnimeList)
+	case syncedAnime:
+		if a.counterpart != nil {
+			return animeFields(a.Anime) | animeFields(a.counterpart)
+		}
+		return animeFields(a.Anime)
+	default:
+		return AllFields
+	}
 }
 
+// Animelist is an anime list on a site that tracks local changes and pushes them to the site.
+// IDs passed to Get and Contains are IDs for the list's Type
 type Animelist interface {
 	Type() int
+	Fetch() error
 	Add(anime Anime)
 	Edit(anime Anime)
 	Get(id int) (Anime, error)
 	Remove(anime Anime)
-	Push()
-	Undo()
+	Push() error
+	PlanPush() (*PushPlan, error)
+	Undo() error
+	Redo() error
+	History() []HistoryEntry
 	Anime() []Anime
 	Contains(id int) bool
-	AuthToken() string
 }
 
 // Manages multiple anime lists by syncing changes to the others
 type AnimelistManager struct {
 	primary  Animelist
 	replicas []Animelist
+
+	// snapshots holds the anime of the last sync between the primary
+	// and each replica, keyed by the replica's ID
+	snapshots []map[int]Anime
+
+	// resolver resolves conflicts during a sync, conflicts are returned if it is nil
+	resolver ConflictResolver
+
+	// ids fills in the IDs that anime are missing for the other lists, if it is set
+	ids *IDMap
+}
+
+// UnmappedAnime is an anime in a list that doesn't have an ID for another list it is synced with,
+// even after checking the ID map, so it can't be synced until it is mapped by hand
+type UnmappedAnime struct {
+	List Animelist
+	// ListType is the list type that the anime has no ID for
+	ListType int
+	Anime    Anime
+}
+
+// NewAnimelistManager creates a manager that syncs the replica lists to the primary list
+func NewAnimelistManager(primary Animelist, replicas ...Animelist) *AnimelistManager {
+	return &AnimelistManager{
+		primary:   primary,
+		replicas:  replicas,
+		snapshots: make([]map[int]Anime, len(replicas)),
+	}
+}
+
+// SetConflictResolver sets the resolver that is called for every conflict found when syncing.
+// If the resolver is nil conflicts are returned from Sync instead
+func (m *AnimelistManager) SetConflictResolver(resolver ConflictResolver) {
+	m.resolver = resolver
 }
 
-// Add adds an anime to all of the lists
+// SetIDMap sets the ID map that is used to fill in the IDs that anime are missing for the other lists.
+// Fetching learns the IDs of the fetched anime that have the IDs of every list
+func (m *AnimelistManager) SetIDMap(ids *IDMap) {
+	m.ids = ids
+}
+
+// completeID returns the ID of the anime with the IDs it is missing filled in from the ID map
+func (m *AnimelistManager) completeID(anime Anime) AnimeID {
+	if m.ids == nil {
+		return anime.ID()
+	}
+	return m.ids.Complete(anime.ID())
+}
+
+// lists returns the primary list followed by the replica lists
+func (m *AnimelistManager) lists() []Animelist {
+	return append([]Animelist{m.primary}, m.replicas...)
+}
+
+// Fetch fetches all of the lists and returns the first error
+func (m *AnimelistManager) Fetch() error {
+	for _, list := range m.lists() {
+		if err := list.Fetch(); err != nil {
+			return err
+		}
+		if m.ids != nil {
+			m.ids.Learn(list.Anime())
+		}
+	}
+	return nil
+}
+
+// Push pushes the changes of all of the lists and returns the first error
+func (m *AnimelistManager) Push() error {
+	for _, list := range m.lists() {
+		if err := list.Push(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Add adds an anime to all of the lists. Lists that the anime has no ID for are skipped
 func (m *AnimelistManager) Add(anime Anime) {
-	m.primary.Add(anime)
-	for _, replica := range m.replicas {
-		replica.Add(anime)
+	for _, list := range m.lists() {
+		if mapped := m.mapAnime(anime, list.Type()); mapped != nil {
+			list.Add(mapped)
+		}
 	}
 }
 
-// Edit changes an anime to all of the lists
+// Edit changes an anime to all of the lists. Lists that the anime has no ID for are skipped
 func (m *AnimelistManager) Edit(anime Anime) {
-	m.primary.Edit(anime)
-	for _, replica := range m.replicas {
-		replica.Edit(anime)
+	for _, list := range m.lists() {
+		if mapped := m.mapAnime(anime, list.Type()); mapped != nil {
+			list.Edit(mapped)
+		}
 	}
 }
 
-// Remove an anime from all of the lists
+// Remove an anime from all of the lists. Lists that the anime has no ID for are skipped
 func (m *AnimelistManager) Remove(anime Anime) {
-	m.primary.Remove(anime)
-	for _, replica := range m.replicas {
-		replica.Remove(anime)
+	for _, list := range m.lists() {
+		if mapped := m.mapAnime(anime, list.Type()); mapped != nil {
+			list.Remove(mapped)
+		}
+	}
+}
+
+// mapAnime returns the anime with the IDs it is missing filled in from the ID map,
+// or nil if it has no ID for the list type
+func (m *AnimelistManager) mapAnime(anime Anime, listType int) Anime {
+	id := m.completeID(anime)
+	if id.Get(listType) == 0 {
+		return nil
 	}
+	return withID(anime, id)
 }
 
-// Sync syncs the replica lists to the primary list
-func (m *AnimelistManager) Sync() error {
-	for id, anime := range m.primary.Anime() {
-		for _, replica := range m.replicas {
-			if replica.Contains(id) {
-				replica.Edit(anime)
-			} else {
-				replica.Add(anime)
+// Unmapped returns the anime in the lists that have no ID for a list they are synced with and no
+// anime in that list has their ID, even after checking the ID map. They are left out of syncs until they are mapped
+func (m *AnimelistManager) Unmapped() []UnmappedAnime {
+	var unmapped []UnmappedAnime
+	// checked holds the list types that each list was checked for, so that the
+	// primary list isn't checked twice for replicas of the same type
+	checked := make(map[Animelist]map[int]bool)
+	check := func(list Animelist, listType int, counterparts []Animelist) {
+		if checked[list] == nil {
+			checked[list] = make(map[int]bool)
+		} else if checked[list][listType] {
+			return
+		}
+		checked[list][listType] = true
+
+		paired := make(map[int]bool)
+		for _, counterpart := range counterparts {
+			for _, a := range counterpart.Anime() {
+				if id := m.completeID(a).Get(list.Type()); id != 0 {
+					paired[id] = true
+				}
+			}
+		}
+		for _, a := range list.Anime() {
+			if m.completeID(a).Get(listType) == 0 && !paired[a.ID().Get(list.Type())] {
+				unmapped = append(unmapped, UnmappedAnime{List: list, ListType: listType, Anime: a})
 			}
 		}
 	}
-
-	return nil
+	for _, replica := range m.replicas {
+		var counterparts []Animelist
+		for _, other := range m.replicas {
+			if other.Type() == replica.Type() {
+				counterparts = append(counterparts, other)
+			}
+		}
+		check(m.primary, replica.Type(), counterparts)
+	}
+	for _, replica := range m.replicas {
+		check(replica, m.primary.Type(), []Animelist{m.primary})
+	}
+	return unmapped
 }
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+var managerTestAnime = HummingbirdAnime{
+	NumEpisodesWatched: 11,
+	NumRewatchedTimes:  2,
+	AnimeStatus:        "currently-watching",
+	Data: HummingbirdAnimeData{
+		Id:    50,
+		MalID: 20,
+		Title: "Sample text",
+	},
+}
+
+func TestAnimelistManager_AddEditRemove(t *testing.T) {
+	primary := NewHummingbirdAnimeList("darin_minamoto", "")
+	replica := NewMyAnimeListAnimeList("darin_minamoto", "")
+	manager := NewAnimelistManager(primary, replica)
+
+	manager.Add(managerTestAnime)
+	if !primary.Contains(50) || !replica.Contains(20) {
+		t.Fatalf("TestAnimelistManager_AddEditRemove failed: expected anime to be added to every list")
+	}
+
+	editedAnime := managerTestAnime
+	editedAnime.NumEpisodesWatched = 12
+	manager.Edit(editedAnime)
+
+	for _, list := range []Animelist{primary, replica} {
+		anime, err := list.Get(editedAnime.ID().Get(list.Type()))
+		if err != nil {
+			t.Fatalf("TestAnimelistManager_AddEditRemove failed: %v", err)
+		}
+		if anime.EpisodesWatched() != 12 {
+			t.Errorf("TestAnimelistManager_AddEditRemove failed: want 12 episodes got %d", anime.EpisodesWatched())
+		}
+	}
+
+	manager.Remove(editedAnime)
+	if len(primary.Anime()) != 0 || len(replica.Anime()) != 0 {
+		t.Errorf("TestAnimelistManager_AddEditRemove failed: expected anime to be removed from every list")
+	}
+
+	expectedChanges := []Change{
+		AddChange{managerTestAnime},
+		EditChange{OldAnime: managerTestAnime, NewAnime: editedAnime},
+		DeleteChange{editedAnime},
+	}
+	if !reflect.DeepEqual(primary.changes, expectedChanges) {
+		t.Errorf("TestAnimelistManager_AddEditRemove failed: want %+v got %+v", expectedChanges, primary.changes)
+	}
+	if len(replica.changes) != len(expectedChanges) {
+		t.Errorf("TestAnimelistManager_AddEditRemove failed: want %d replica changes got %d",
+			len(expectedChanges), len(replica.changes))
+	}
+}
+
+func TestAnimelistManager_FetchReturnsError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	primary := NewMyAnimeListAnimeList("darin_minamoto", "", ListOptions{
+		BaseURL: server.URL,
+		Retry:   &RetryPolicy{MaxAttempts: 1},
+	})
+	manager := NewAnimelistManager(primary)
+
+	if err := manager.Fetch(); err == nil {
+		t.Errorf("TestAnimelistManager_FetchReturnsError failed: expected an error from Fetch")
+	}
+}
+
+func TestAnimelistManager_UndoReturnsError(t *testing.T) {
+	lists := []Animelist{
+		NewHummingbirdAnimeList("darin_minamoto", ""),
+		NewMyAnimeListAnimeList("darin_minamoto", ""),
+	}
+
+	for _, list := range lists {
+		if err := list.Undo(); err == nil {
+			t.Errorf("TestAnimelistManager_UndoReturnsError failed: expected an error undoing an empty list")
+		}
+	}
+}